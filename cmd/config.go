@@ -5,8 +5,10 @@ import (
 	nerrors "errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -27,6 +29,42 @@ var configCmd = &cobra.Command{
 	Args:  cobra.NoArgs,
 }
 
+// serverPolicyFlags are the flags of the trash, versions and extraction
+// settings, which are stored and only changed when given.
+var serverPolicyFlags = []string{
+	"disable-trash",
+	"trash-retention",
+	"versions",
+	"versions-max-age",
+	"extract-max-size",
+	"extract-max-files",
+}
+
+// setServerPolicy sets the setting of ser given by the flag key to val.
+func setServerPolicy(ser *settings.Server, key, val string) error {
+	var err error
+	switch key {
+	case "disable-trash":
+		var disable bool
+		disable, err = strconv.ParseBool(val)
+		ser.EnableTrash = !disable
+	case "trash-retention":
+		ser.TrashRetention, err = time.ParseDuration(val)
+	case "versions":
+		ser.Versions.Keep, err = strconv.Atoi(val)
+	case "versions-max-age":
+		ser.Versions.MaxAge, err = time.ParseDuration(val)
+	case "extract-max-size":
+		ser.ExtractMaxSize, err = strconv.ParseInt(val, 10, 64)
+	case "extract-max-files":
+		ser.ExtractMaxFiles, err = strconv.Atoi(val)
+	}
+	if err != nil {
+		return fmt.Errorf("--%s: %w", key, err)
+	}
+	return nil
+}
+
 func addConfigFlags(flags *pflag.FlagSet) {
 	addServerFlags(flags)
 	addUserFlags(flags)
@@ -160,6 +198,13 @@ func printSettings(ser *settings.Server, set *settings.Settings, auther auth.Aut
 	fmt.Fprintf(w, "\tTLS Cert:\t%s\n", ser.TLSCert)
 	fmt.Fprintf(w, "\tTLS Key:\t%s\n", ser.TLSKey)
 	fmt.Fprintf(w, "\tExec Enabled:\t%t\n", ser.EnableExec)
+	fmt.Fprintf(w, "\tTrash Enabled:\t%t\n", ser.EnableTrash)
+	fmt.Fprintf(w, "\tTrash Dir:\t%s\n", ser.TrashDir)
+	fmt.Fprintf(w, "\tTrash Retention:\t%s\n", ser.TrashRetention)
+	fmt.Fprintf(w, "\tVersions Kept:\t%d\n", ser.Versions.Keep)
+	fmt.Fprintf(w, "\tVersions Max Age:\t%s\n", ser.Versions.MaxAge)
+	fmt.Fprintf(w, "\tExtract Max Size:\t%d\n", ser.ExtractMaxSize)
+	fmt.Fprintf(w, "\tExtract Max Files:\t%d\n", ser.ExtractMaxFiles)
 	fmt.Fprintln(w, "\nDefaults:")
 	fmt.Fprintf(w, "\tScope:\t%s\n", set.Defaults.Scope)
 	fmt.Fprintf(w, "\tLocale:\t%s\n", set.Defaults.Locale)
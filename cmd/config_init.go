@@ -42,15 +42,20 @@ override the options.`,
 			},
 		}
 
-		ser := &settings.Server{
-			Address: mustGetString(flags, "address"),
-			Socket:  mustGetString(flags, "socket"),
-			Root:    mustGetString(flags, "root"),
-			BaseURL: mustGetString(flags, "baseurl"),
-			TLSKey:  mustGetString(flags, "key"),
-			TLSCert: mustGetString(flags, "cert"),
-			Port:    mustGetString(flags, "port"),
-			Log:     mustGetString(flags, "log"),
+		ser := settings.DefaultServer()
+		ser.Address = mustGetString(flags, "address")
+		ser.Socket = mustGetString(flags, "socket")
+		ser.Root = mustGetString(flags, "root")
+		ser.BaseURL = mustGetString(flags, "baseurl")
+		ser.TLSKey = mustGetString(flags, "key")
+		ser.TLSCert = mustGetString(flags, "cert")
+		ser.Port = mustGetString(flags, "port")
+		ser.Log = mustGetString(flags, "log")
+		ser.TrashDir = mustGetString(flags, "trash-dir")
+		for _, key := range serverPolicyFlags {
+			if flags.Changed(key) {
+				checkErr(setServerPolicy(ser, key, flags.Lookup(key).Value.String()))
+			}
 		}
 
 		err := d.store.Settings.Save(s)
@@ -43,6 +43,11 @@ you want to change. Other options will remain unchanged.`,
 				ser.Port = mustGetString(flags, flag.Name)
 			case "log":
 				ser.Log = mustGetString(flags, flag.Name)
+			case "trash-dir":
+				ser.TrashDir = mustGetString(flags, flag.Name)
+			case "disable-trash", "trash-retention", "versions", "versions-max-age",
+				"extract-max-size", "extract-max-files":
+				checkErr(setServerPolicy(ser, flag.Name, flag.Value.String()))
 			case "signup":
 				set.Signup = mustGetBool(flags, flag.Name)
 			case "auth.method":
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/users"
+)
+
+func init() {
+	rootCmd.AddCommand(deviceCmd)
+
+	addDeviceFlags(deviceCmd.PersistentFlags())
+	deviceCmd.PersistentFlags().Bool("json", false, "print the output as JSON")
+}
+
+// addDeviceFlags adds the flags selecting the device commands talk to.
+func addDeviceFlags(flags *pflag.FlagSet) {
+	flags.StringArray("ga-addr", []string{"127.0.0.1:5001"}, "ga file server addr, as [name=][tcp://|tls://]host:port or [name=]unix:///path")
+	flags.String("ga-ca", "", "PEM file of the certificate authorities trusted for tls:// ga file servers (defaults to the system ones)")
+	flags.String("ga-cert", "", "PEM file of the client certificate sent to tls:// ga file servers")
+	flags.String("ga-key", "", "PEM file of the key of --ga-cert")
+	flags.String("device", "", "name of the ga-addr to use (defaults to the first one)")
+	flags.Duration("ga-timeout", services.DefaultOpTimeout, "timeout of a single request to the ga file server")
+	flags.Int("ga-block-size", services.DefaultBlockSize, "largest chunk moved by a single read or write to the ga file server")
+}
+
+var deviceCmd = &cobra.Command{
+	Use:   "device",
+	Short: "Device file management utility",
+	Long: `Device file management utility. The commands talk to the ga file
+server directly, without the web server.
+
+Remote paths may contain glob patterns, as accepted by path.Match;
+quote them so that the shell does not expand them.`,
+	Args: cobra.NoArgs,
+}
+
+// openDevice connects to the device selected by the device flags.
+func openDevice(flags *pflag.FlagSet) *services.Fsync {
+	return dialDevice(flags, deviceAddr(flags))
+}
+
+// dialDevice connects to the ga file server at addr.
+func dialDevice(flags *pflag.FlagSet, addr string) *services.Fsync {
+	opTimeout, err := flags.GetDuration("ga-timeout")
+	checkErr(err)
+	blockSize, err := flags.GetInt("ga-block-size")
+	checkErr(err)
+	fs, err := services.NewFsyncWithOptions(addr, services.Options{
+		PoolSize:  1,
+		OpTimeout: opTimeout,
+		BlockSize: blockSize,
+		TLSConfig: getDeviceTLSConfig(flags),
+	})
+	checkErr(err)
+	return fs
+}
+
+// deviceAddr returns the address of the device selected by the device flags.
+func deviceAddr(flags *pflag.FlagSet) string {
+	name := mustGetString(flags, "device")
+	for _, device := range getDeviceAddrs(flags) {
+		deviceName, addr, found := strings.Cut(device, "=")
+		if !found {
+			deviceName, addr = users.DefaultDevice, device
+		}
+		if name == "" || name == deviceName {
+			return addr
+		}
+	}
+	checkErr(fmt.Errorf("--device: unknown device %q", name))
+	return ""
+}
+
+// expandRemote returns the device paths matching the patterns. A pattern
+// without matches is an error, as in a shell with failglob set.
+func expandRemote(fs afero.Fs, patterns []string) []string {
+	var names []string
+	for _, pattern := range patterns {
+		pattern = path.Clean("/" + pattern)
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			names = append(names, pattern)
+			continue
+		}
+		matches, err := afero.Glob(fs, pattern)
+		checkErr(err)
+		if len(matches) == 0 {
+			checkErr(fmt.Errorf("%s: no matches found", pattern))
+		}
+		names = append(names, matches...)
+	}
+	return names
+}
+
+// deviceEntry is the JSON form of a file.
+type deviceEntry struct {
+	Path      string    `json:"path"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modified"`
+	IsDir     bool      `json:"isDir"`
+	IsSymlink bool      `json:"isSymlink"`
+	Target    string    `json:"target,omitempty"`
+}
+
+func newDeviceEntry(name string, info os.FileInfo) deviceEntry {
+	entry := deviceEntry{
+		Path:      name,
+		Name:      info.Name(),
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		IsDir:     info.IsDir(),
+		IsSymlink: info.Mode()&os.ModeSymlink != 0,
+	}
+	if link, ok := info.(interface{ LinkTarget() string }); ok {
+		entry.Target = link.LinkTarget()
+	}
+	return entry
+}
+
+// deviceTransfer is the JSON form of a file pulled or pushed.
+type deviceTransfer struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Size        int64  `json:"size"`
+}
+
+func printJSON(data interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	checkErr(encoder.Encode(data))
+}
+
+// progressBar draws the progress of a transfer on one line.
+type progressBar struct {
+	out         io.Writer
+	total, done uint64
+	drawn       time.Time
+}
+
+const progressBarWidth = 40
+
+func newProgressBar(out io.Writer, total uint64) *progressBar {
+	return &progressBar{out: out, total: total}
+}
+
+// handler is given to the push and pull functions of services.Fsync.
+func (p *progressBar) handler(size uint64, status string) {
+	p.done += size
+	if time.Since(p.drawn) < 100*time.Millisecond && p.done < p.total {
+		return
+	}
+	p.drawn = time.Now()
+
+	ratio := 1.0
+	if p.total > 0 && p.done < p.total {
+		ratio = float64(p.done) / float64(p.total)
+	}
+	filled := int(ratio * progressBarWidth)
+	fmt.Fprintf(p.out, "\r%-8s [%s%s] %3.0f%% %s/%s", status,
+		strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		ratio*100, formatSize(p.done), formatSize(p.total)) //nolint:gomnd
+}
+
+func (p *progressBar) finish() {
+	if !p.drawn.IsZero() {
+		fmt.Fprintln(p.out)
+	}
+}
+
+func formatSize(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
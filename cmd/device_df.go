@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	deviceCmd.AddCommand(deviceDfCmd)
+}
+
+var deviceDfCmd = &cobra.Command{
+	Use:   "df",
+	Short: "Show the disk usage of the device",
+	Long:  `Show the capacity and the free space of the device.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fs := openDevice(cmd.Flags())
+		defer fs.Close()
+
+		usage, err := fs.Usage("/")
+		checkErr(err)
+
+		if mustGetBool(cmd.Flags(), "json") {
+			printJSON(usage)
+			return
+		}
+		fmt.Printf("Model: %s\n", usage.Model)
+		fmt.Printf("Total: %s\n", formatSize(usage.Total))
+		fmt.Printf("Used: %s\n", formatSize(usage.Used()))
+		fmt.Printf("Free: %s\n", formatSize(usage.Free))
+		fmt.Printf("Block size: %d\n", usage.BlockSize)
+	},
+}
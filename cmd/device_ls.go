@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+func init() {
+	deviceCmd.AddCommand(deviceLsCmd)
+}
+
+var deviceLsCmd = &cobra.Command{
+	Use:   "ls [path]...",
+	Short: "List device files",
+	Long:  `List device files, and the content of device directories.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fs := openDevice(cmd.Flags())
+		defer fs.Close()
+
+		if len(args) == 0 {
+			args = []string{"/"}
+		}
+		entries := []deviceEntry{}
+		for _, name := range expandRemote(fs, args) {
+			info, err := fs.Stat(name)
+			checkErr(err)
+			if !info.IsDir() {
+				entries = append(entries, newDeviceEntry(name, info))
+				continue
+			}
+			infos, err := govfs.ReadDir(fs, name)
+			checkErr(err)
+			for _, info := range infos {
+				entries = append(entries, newDeviceEntry(path.Join(name, info.Name()), info))
+			}
+		}
+
+		if mustGetBool(cmd.Flags(), "json") {
+			printJSON(entries)
+			return
+		}
+		printDeviceEntries(entries)
+	},
+}
+
+func printDeviceEntries(entries []deviceEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	for _, entry := range entries {
+		kind, name := "-", entry.Path
+		switch {
+		case entry.IsDir:
+			kind = "d"
+		case entry.IsSymlink:
+			kind, name = "l", name+" -> "+entry.Target
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", kind, entry.Size, entry.ModTime.Format("2006-01-02 15:04:05"), name)
+	}
+	w.Flush()
+}
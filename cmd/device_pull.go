@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+func init() {
+	deviceCmd.AddCommand(devicePullCmd)
+	devicePullCmd.Flags().Bool("dry-run", false, "print what would be pulled without pulling it")
+}
+
+var devicePullCmd = &cobra.Command{
+	Use:   "pull <remote>... <local>",
+	Short: "Copy device files to this machine",
+	Long: `Copy device files, and directories with their contents, to this
+machine. With several remote paths, or when local is a directory, they
+are copied into local.`,
+	Args: cobra.MinimumNArgs(2), //nolint:gomnd
+	Run: func(cmd *cobra.Command, args []string) {
+		fs := openDevice(cmd.Flags())
+		defer fs.Close()
+		asJSON := mustGetBool(cmd.Flags(), "json")
+
+		sources := expandRemote(fs, args[:len(args)-1])
+		dst := args[len(args)-1]
+		info, err := os.Stat(dst)
+		into := len(sources) > 1 || strings.HasSuffix(dst, "/") || (err == nil && info.IsDir())
+
+		var (
+			dirs      []string
+			transfers = []deviceTransfer{}
+			total     uint64
+		)
+		for _, src := range sources {
+			target := dst
+			if into {
+				target = filepath.Join(dst, path.Base(src))
+			}
+			checkErr(govfs.Walk(fs, src, func(name string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				local := filepath.Join(target, filepath.FromSlash(strings.TrimPrefix(name, src)))
+				if info.IsDir() {
+					dirs = append(dirs, local)
+					return nil
+				}
+				transfers = append(transfers, deviceTransfer{Source: name, Destination: local, Size: info.Size()})
+				total += uint64(info.Size())
+				return nil
+			}))
+		}
+
+		if !mustGetBool(cmd.Flags(), "dry-run") {
+			for _, dir := range dirs {
+				checkErr(os.MkdirAll(dir, 0755)) //nolint:gomnd
+			}
+			bar := newProgressBar(os.Stderr, total)
+			handler := bar.handler
+			if asJSON {
+				handler = nil
+			}
+			for _, t := range transfers {
+				checkErr(os.MkdirAll(filepath.Dir(t.Destination), 0755)) //nolint:gomnd
+				checkErr(fs.PullFileWithHandler(t.Source, t.Destination, handler))
+			}
+			bar.finish()
+		}
+		printTransfers(transfers, asJSON)
+	},
+}
+
+func printTransfers(transfers []deviceTransfer, asJSON bool) {
+	if asJSON {
+		printJSON(transfers)
+		return
+	}
+	for _, t := range transfers {
+		fmt.Printf("%s -> %s (%s)\n", t.Source, t.Destination, formatSize(uint64(t.Size)))
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	deviceCmd.AddCommand(devicePushCmd)
+	devicePushCmd.Flags().Bool("dry-run", false, "print what would be pushed without pushing it")
+}
+
+var devicePushCmd = &cobra.Command{
+	Use:   "push <local>... <remote>",
+	Short: "Copy files of this machine to the device",
+	Long: `Copy files, and directories with their contents, to the device.
+With several local paths, or when remote is a directory, they are copied
+into remote.`,
+	Args: cobra.MinimumNArgs(2), //nolint:gomnd
+	Run: func(cmd *cobra.Command, args []string) {
+		fs := openDevice(cmd.Flags())
+		defer fs.Close()
+		asJSON := mustGetBool(cmd.Flags(), "json")
+
+		var sources []string
+		for _, pattern := range args[:len(args)-1] {
+			matches, err := filepath.Glob(pattern)
+			checkErr(err)
+			if len(matches) == 0 {
+				matches = []string{pattern}
+			}
+			sources = append(sources, matches...)
+		}
+		dst := path.Clean("/" + args[len(args)-1])
+		info, err := fs.Stat(dst)
+		into := len(sources) > 1 || strings.HasSuffix(args[len(args)-1], "/") || (err == nil && info.IsDir())
+
+		var (
+			dirs      []string
+			transfers = []deviceTransfer{}
+			total     uint64
+		)
+		for _, src := range sources {
+			target := dst
+			if into {
+				target = path.Join(dst, filepath.Base(src))
+			}
+			checkErr(filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				rel, err := filepath.Rel(src, name)
+				if err != nil {
+					return err
+				}
+				remote := path.Join(target, filepath.ToSlash(rel))
+				if info.IsDir() {
+					dirs = append(dirs, remote)
+					return nil
+				}
+				transfers = append(transfers, deviceTransfer{Source: name, Destination: remote, Size: info.Size()})
+				total += uint64(info.Size())
+				return nil
+			}))
+		}
+
+		if !mustGetBool(cmd.Flags(), "dry-run") {
+			for _, dir := range dirs {
+				checkErr(fs.MkdirAll(dir, 0755)) //nolint:gomnd
+			}
+			bar := newProgressBar(os.Stderr, total)
+			handler := bar.handler
+			if asJSON {
+				handler = nil
+			}
+			for _, t := range transfers {
+				checkErr(fs.MkdirAll(path.Dir(t.Destination), 0755)) //nolint:gomnd
+				checkErr(fs.PushWithHandler(t.Source, t.Destination, handler))
+			}
+			bar.finish()
+		}
+		printTransfers(transfers, asJSON)
+	},
+}
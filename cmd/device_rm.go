@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	deviceCmd.AddCommand(deviceRmCmd)
+	deviceRmCmd.Flags().BoolP("recursive", "r", false, "remove directories and their contents")
+	deviceRmCmd.Flags().Bool("dry-run", false, "print what would be removed without removing it")
+}
+
+var deviceRmCmd = &cobra.Command{
+	Use:   "rm <path>...",
+	Short: "Remove device files",
+	Long:  `Remove device files, and directories with --recursive.`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fs := openDevice(cmd.Flags())
+		defer fs.Close()
+		recursive := mustGetBool(cmd.Flags(), "recursive")
+		dryRun := mustGetBool(cmd.Flags(), "dry-run")
+
+		names := expandRemote(fs, args)
+		for _, name := range names {
+			info, _, err := fs.LstatIfPossible(name)
+			checkErr(err)
+			if info.IsDir() && !recursive {
+				checkErr(fmt.Errorf("%s: is a directory", name))
+			}
+		}
+
+		for _, name := range names {
+			if !dryRun {
+				checkErr(fs.RmTree(name))
+			}
+			if !mustGetBool(cmd.Flags(), "json") {
+				fmt.Println(name)
+			}
+		}
+		if mustGetBool(cmd.Flags(), "json") {
+			printJSON(names)
+		}
+	},
+}
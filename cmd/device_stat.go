@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	deviceCmd.AddCommand(deviceStatCmd)
+}
+
+var deviceStatCmd = &cobra.Command{
+	Use:   "stat <path>...",
+	Short: "Show the status of device files",
+	Long:  `Show the status of device files; links are not followed.`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fs := openDevice(cmd.Flags())
+		defer fs.Close()
+
+		entries := []deviceEntry{}
+		for _, name := range expandRemote(fs, args) {
+			info, _, err := fs.LstatIfPossible(name)
+			checkErr(err)
+			entries = append(entries, newDeviceEntry(name, info))
+		}
+
+		if mustGetBool(cmd.Flags(), "json") {
+			printJSON(entries)
+			return
+		}
+		for _, entry := range entries {
+			fmt.Printf("Path: %s\n", entry.Path)
+			fmt.Printf("Size: %d\n", entry.Size)
+			fmt.Printf("Modified: %s\n", entry.ModTime.Format("2006-01-02 15:04:05"))
+			switch {
+			case entry.IsDir:
+				fmt.Println("Type: directory")
+			case entry.IsSymlink:
+				fmt.Printf("Type: symbolic link to %s\n", entry.Target)
+			default:
+				fmt.Println("Type: regular file")
+			}
+		}
+	},
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/govfs/treesync"
+)
+
+func init() {
+	deviceCmd.AddCommand(deviceSyncCmd)
+
+	flags := deviceSyncCmd.Flags()
+	flags.String("direction", "push", "what to sync: push (to the device), pull (from the device) or both")
+	flags.String("mode", string(treesync.ModeUpdate), "update (copy what is missing or older) or mirror (also delete what the source lacks)")
+	flags.Bool("checksum", false, "compare the content of the files of the same size instead of their modification time")
+	flags.Duration("modify-window", time.Millisecond, "largest difference between two modification times still considered equal (devices report them to the microsecond)")
+	flags.StringArray("exclude", nil, "glob of the files and directories left alone on both sides; matched against the base name, or the path relative to the roots when it has a slash")
+	flags.String("manifest", "", "file recording the progress of the sync (defaults to one in the user cache directory)")
+	flags.Bool("dry-run", false, "print what would be done without doing it")
+}
+
+var deviceSyncCmd = &cobra.Command{
+	Use:   "sync <local> <remote>",
+	Short: "Synchronize a local directory and a device directory",
+	Long: `Synchronize a local directory and a device directory, transferring
+only the files that differ in size and modification time, or content
+with --checksum.
+
+With --mode update, files missing on the destination or older there are
+copied and nothing is deleted; --direction both then syncs the newest
+files both ways. With --mode mirror, the destination is made identical to
+the source.
+
+The progress is recorded in a manifest, so that running the same command
+again after an interruption only does the remaining work.`,
+	Args: cobra.ExactArgs(2), //nolint:gomnd
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		direction := mustGetString(flags, "direction")
+		window, err := flags.GetDuration("modify-window")
+		checkErr(err)
+		exclude, err := flags.GetStringArray("exclude")
+		checkErr(err)
+		opts := treesync.Options{
+			Mode:     treesync.Mode(mustGetString(flags, "mode")),
+			Checksum: mustGetBool(flags, "checksum"),
+			Window:   window,
+			Exclude:  exclude,
+		}
+
+		var directions []string
+		switch direction {
+		case "push", "pull":
+			directions = []string{direction}
+		case "both":
+			if opts.Mode != treesync.ModeUpdate {
+				checkErr(fmt.Errorf("--direction both needs --mode %s", treesync.ModeUpdate))
+			}
+			directions = []string{"push", "pull"}
+		default:
+			checkErr(fmt.Errorf("--direction: unknown direction %q", direction))
+		}
+
+		local, err := filepath.Abs(args[0])
+		checkErr(err)
+		remote := path.Clean("/" + args[1])
+		device := openDevice(flags)
+		defer device.Close()
+		localFs := govfs.NewScopedFs(govfs.NewOsFs(), local)
+		remoteFs := govfs.NewScopedFs(device, remote)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		actions := []deviceSyncAction{}
+		for _, direction := range directions {
+			opts.Manifest = syncManifest(flags, direction, local, remote)
+			src, dst := afero.Fs(localFs), afero.Fs(remoteFs)
+			if direction == "pull" {
+				src, dst = dst, src
+			}
+
+			bar := newProgressBar(os.Stderr, 0)
+			if !mustGetBool(flags, "json") {
+				opts.Handler = bar.handler
+			}
+			plan, err := treesync.NewPlan(ctx, src, dst, opts)
+			checkErr(err)
+			if !mustGetBool(flags, "dry-run") {
+				bar.total = uint64(plan.Size())
+				err = plan.Run(ctx)
+				bar.finish()
+				if err != nil {
+					checkErr(fmt.Errorf("%w; run the same command again to resume", err))
+				}
+			}
+			for _, action := range plan.Actions {
+				actions = append(actions, deviceSyncAction{Direction: direction, Action: action})
+			}
+		}
+		printSyncActions(actions, mustGetBool(flags, "json"))
+	},
+}
+
+// deviceSyncAction is the JSON form of a change made by a sync.
+type deviceSyncAction struct {
+	Direction string `json:"direction"`
+	treesync.Action
+}
+
+// syncManifest returns the manifest of the sync of local and remote in
+// direction.
+func syncManifest(flags *pflag.FlagSet, direction, local, remote string) string {
+	if name := mustGetString(flags, "manifest"); name != "" {
+		if mustGetString(flags, "direction") == "both" {
+			return name + "." + direction
+		}
+		return name
+	}
+	dir, err := os.UserCacheDir()
+	checkErr(err)
+	key := sha1.Sum([]byte(deviceAddr(flags) + "\n" + local + "\n" + remote + "\n" + direction)) //nolint:gosec
+	return filepath.Join(dir, "filebrowser", "sync", hex.EncodeToString(key[:])+".json")
+}
+
+func printSyncActions(actions []deviceSyncAction, asJSON bool) {
+	if asJSON {
+		printJSON(actions)
+		return
+	}
+	for _, a := range actions {
+		if a.Op == treesync.OpCopy {
+			fmt.Printf("%s %s %s (%s)\n", a.Direction, a.Op, a.Path, formatSize(uint64(a.Size)))
+			continue
+		}
+		fmt.Printf("%s %s %s\n", a.Direction, a.Op, a.Path)
+	}
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+func init() {
+	deviceCmd.AddCommand(deviceTreeCmd)
+}
+
+var deviceTreeCmd = &cobra.Command{
+	Use:   "tree [path]...",
+	Short: "Show device directories as trees",
+	Long: `Show device directories as trees. With --json, every file below
+them is listed instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fs := openDevice(cmd.Flags())
+		defer fs.Close()
+
+		if len(args) == 0 {
+			args = []string{"/"}
+		}
+		names := expandRemote(fs, args)
+
+		if !mustGetBool(cmd.Flags(), "json") {
+			for _, name := range names {
+				checkErr(fs.TreeView(name, "", true))
+			}
+			return
+		}
+
+		entries := []deviceEntry{}
+		for _, name := range names {
+			checkErr(govfs.Walk(fs, name, func(name string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				entries = append(entries, newDeviceEntry(name, info))
+				return nil
+			}))
+		}
+		printJSON(entries)
+	},
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(devicesCmd)
+	devicesCmd.PersistentFlags().Bool("json", false, "print the output as JSON")
+}
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "Devices management utility",
+	Long: `Devices management utility. The devices stored in the database
+are attached when the server starts, in addition to the ga-addr ones,
+which they replace when they have the same name. Admins can attach and
+detach devices of a running server through the /api/devices resource.`,
+	Args: cobra.NoArgs,
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/device"
+)
+
+func init() {
+	devicesCmd.AddCommand(devicesAddCmd)
+	addDeviceConfigFlags(devicesAddCmd)
+}
+
+// addDeviceConfigFlags adds the flags setting the fields of a stored device.
+func addDeviceConfigFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("mount", nil, "extra ga service, as <mount point>=<addr> as in --ga-mount")
+	cmd.Flags().String("cache-ttl", "", "how long file metadata is cached, such as 1s; 0 disables the cache (defaults to --ga-cache-ttl)")
+	cmd.Flags().Bool("disabled", false, "store the device without attaching it")
+}
+
+var devicesAddCmd = &cobra.Command{
+	Use:   "add <name> <addr>",
+	Short: "Store a new device",
+	Long: `Store a new device, attached when the server starts unless it is
+disabled. The address is as in --ga-addr, without the name; the device
+does not need to be online.`,
+	Args: cobra.ExactArgs(2), //nolint:gomnd
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		mounts, err := cmd.Flags().GetStringArray("mount")
+		checkErr(err)
+		dev := &device.Device{
+			Name:     args[0],
+			Addr:     args[1],
+			Mounts:   mounts,
+			CacheTTL: mustGetString(cmd.Flags(), "cache-ttl"),
+			Disabled: mustGetBool(cmd.Flags(), "disabled"),
+		}
+		checkErr(d.store.Devices.Create(dev))
+		fmt.Printf("device %s added\n", dev.Name)
+	}, pythonConfig{}),
+}
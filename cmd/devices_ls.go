@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/device"
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/users"
+)
+
+func init() {
+	devicesCmd.AddCommand(devicesLsCmd)
+	flags := devicesLsCmd.Flags()
+	flags.String("ga-ca", "", "PEM file of the certificate authorities trusted for tls:// ga file servers (defaults to the system ones)")
+	flags.String("ga-cert", "", "PEM file of the client certificate sent to tls:// ga file servers")
+	flags.String("ga-key", "", "PEM file of the key of --ga-cert")
+	flags.Duration("ga-timeout", services.DefaultOpTimeout, "timeout of a single request to the ga file server")
+}
+
+// deviceState is the JSON form of a stored device and its state.
+type deviceState struct {
+	*device.Device
+	Connected bool         `json:"connected"`
+	Error     string       `json:"error,omitempty"`
+	Usage     *govfs.Usage `json:"usage,omitempty"`
+}
+
+var devicesLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List all devices",
+	Long: `List the devices stored in the database, connecting to those that
+are not disabled to show their state and storage.`,
+	Args: cobra.NoArgs,
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		devs, err := d.store.Devices.All()
+		if err != errors.ErrNotExist {
+			checkErr(err)
+		}
+		sort.Slice(devs, func(i, j int) bool {
+			return devs[i].Name < devs[j].Name
+		})
+
+		opTimeout, err := cmd.Flags().GetDuration("ga-timeout")
+		checkErr(err)
+		device.SetDefaults(users.DeviceOptions{Options: services.Options{
+			PoolSize:          1,
+			ReconnectAttempts: 1,
+			OpTimeout:         opTimeout,
+			TLSConfig:         getDeviceTLSConfig(cmd.Flags()),
+		}})
+		for _, dev := range devs {
+			if !dev.Disabled {
+				checkErr(dev.Attach())
+			}
+		}
+		statuses := users.DeviceStatuses(context.Background())
+
+		list := make([]deviceState, 0, len(devs))
+		for _, dev := range devs {
+			state := deviceState{Device: dev}
+			if status, ok := statuses[dev.Name]; ok {
+				state.Connected, state.Error, state.Usage = status.Connected, status.Error, status.Usage
+			}
+			list = append(list, state)
+		}
+		if mustGetBool(cmd.Flags(), "json") {
+			printJSON(list)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+		fmt.Fprintln(w, "Name\tAddress\tMounts\tState\tModel\tFree\tTotal")
+		for _, dev := range list {
+			state, model, free, total := "disabled", "", "", ""
+			switch {
+			case dev.Disabled:
+			case !dev.Connected:
+				state = "offline"
+			default:
+				state = "online"
+				if dev.Usage != nil {
+					model = dev.Usage.Model
+					free, total = formatSize(dev.Usage.Free), formatSize(dev.Usage.Total)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", dev.Name, dev.Addr,
+				strings.Join(dev.Mounts, ","), state, model, free, total)
+		}
+		checkErr(w.Flush())
+	}, pythonConfig{}),
+}
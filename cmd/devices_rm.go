@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	devicesCmd.AddCommand(devicesRmCmd)
+}
+
+var devicesRmCmd = &cobra.Command{
+	Use:   "rm <name>...",
+	Short: "Delete devices",
+	Long:  `Delete stored devices by name.`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		for _, name := range args {
+			checkErr(d.store.Devices.Delete(name))
+			fmt.Printf("device %s deleted\n", name)
+		}
+	}, pythonConfig{}),
+}
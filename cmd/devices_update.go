@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	devicesCmd.AddCommand(devicesUpdateCmd)
+	devicesUpdateCmd.Flags().String("addr", "", "new address of the device")
+	addDeviceConfigFlags(devicesUpdateCmd)
+}
+
+var devicesUpdateCmd = &cobra.Command{
+	Use:   "update <name>",
+	Short: "Update a device",
+	Long: `Update a stored device. Only the flags given are changed; use
+--disabled=false to attach a disabled device again.`,
+	Args: cobra.ExactArgs(1),
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		dev, err := d.store.Devices.Get(args[0])
+		checkErr(err)
+
+		flags := cmd.Flags()
+		if flags.Changed("addr") {
+			dev.Addr = mustGetString(flags, "addr")
+		}
+		if flags.Changed("mount") {
+			dev.Mounts, err = flags.GetStringArray("mount")
+			checkErr(err)
+		}
+		if flags.Changed("cache-ttl") {
+			dev.CacheTTL = mustGetString(flags, "cache-ttl")
+		}
+		if flags.Changed("disabled") {
+			dev.Disabled = mustGetBool(flags, "disabled")
+		}
+		checkErr(d.store.Devices.Save(dev))
+		fmt.Printf("device %s updated\n", dev.Name)
+	}, pythonConfig{}),
+}
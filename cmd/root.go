@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
@@ -13,6 +16,7 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/afero"
@@ -22,8 +26,13 @@ import (
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/filebrowser/filebrowser/v2/auth"
+	"github.com/filebrowser/filebrowser/v2/device"
 	"github.com/filebrowser/filebrowser/v2/diskcache"
+	fberrors "github.com/filebrowser/filebrowser/v2/errors"
 	"github.com/filebrowser/filebrowser/v2/frontend"
+	"github.com/filebrowser/filebrowser/v2/govfs/afcfs"
+	"github.com/filebrowser/filebrowser/v2/govfs/cache"
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
 	fbhttp "github.com/filebrowser/filebrowser/v2/http"
 	"github.com/filebrowser/filebrowser/v2/img"
 	"github.com/filebrowser/filebrowser/v2/settings"
@@ -55,7 +64,15 @@ func init() {
 
 func addServerFlags(flags *pflag.FlagSet) {
 	flags.Bool("ga", true, "enabled ga mode")
-	flags.String("ga-addr", "127.0.0.1:5001", "ga file server addr")
+	flags.StringArray("ga-addr", []string{"127.0.0.1:5001"}, "ga file server addr, as [name=][tcp://|tls://]host:port or [name=]unix:///path; repeat to serve several devices")
+	flags.String("ga-ca", "", "PEM file of the certificate authorities trusted for tls:// ga file servers (defaults to the system ones)")
+	flags.String("ga-cert", "", "PEM file of the client certificate sent to tls:// ga file servers")
+	flags.String("ga-key", "", "PEM file of the key of --ga-cert")
+	flags.StringArray("ga-mount", nil, "extra ga service, as [device:]<mount point>=<addr> with addr as in --ga-addr and mount point /apps/<bundle>/Documents, /apps/<bundle> or /crashreports")
+	flags.Int("ga-conns", services.DefaultPoolSize, "number of connections opened to the ga file server")
+	flags.Duration("ga-timeout", services.DefaultOpTimeout, "timeout of a single request to the ga file server")
+	flags.Int("ga-block-size", services.DefaultBlockSize, "largest chunk moved by a single read or write to the ga file server")
+	flags.StringArray("ga-cache-ttl", []string{cache.DefaultTTL.String()}, "how long file metadata of the ga file server is cached, as [name=]duration; 0 disables the cache")
 	flags.StringP("address", "a", "127.0.0.1", "address to listen on")
 	flags.StringP("log", "l", "stdout", "log output")
 	flags.StringP("port", "p", "8080", "port to listen on")
@@ -71,6 +88,13 @@ func addServerFlags(flags *pflag.FlagSet) {
 	flags.Bool("disable-preview-resize", false, "disable resize of image previews")
 	flags.Bool("disable-exec", false, "disables Command Runner feature")
 	flags.Bool("disable-type-detection-by-header", false, "disables type detection by reading file headers")
+	flags.Bool("disable-trash", false, "delete files for good instead of moving them to the trash")
+	flags.String("trash-dir", "", "local directory holding the trash of every user (defaults to a hidden directory in the scope of each user)")
+	flags.String("trash-retention", settings.DefaultTrashRetention.String(), "how long deleted files are kept in the trash; 0 keeps them until they are purged")
+	flags.Int("versions", 0, "number of previous contents kept of the files overwritten; 0 disables the versions")
+	flags.String("versions-max-age", "0", "how long the previous contents of files are kept; 0 keeps them until there are more than --versions")
+	flags.Int64("extract-max-size", settings.DefaultExtractMaxSize, "largest size of the files extracted from an archive, in bytes; 0 disables the limit")
+	flags.Int("extract-max-files", settings.DefaultExtractMaxFiles, "largest number of files extracted from an archive; 0 disables the limit")
 }
 
 var rootCmd = &cobra.Command{
@@ -142,6 +166,10 @@ user created with the credentials from options "username" and "password".`,
 		root, err := filepath.Abs(server.Root)
 		checkErr(err)
 		server.Root = root
+		if server.TrashDir != "" {
+			server.TrashDir, err = filepath.Abs(server.TrashDir)
+			checkErr(err)
+		}
 
 		adr := server.Address + ":" + server.Port
 
@@ -182,6 +210,13 @@ user created with the credentials from options "username" and "password".`,
 
 		defer listener.Close()
 
+		if server.EnableTrash && server.TrashRetention > 0 {
+			go sweepTrash(d.store, server)
+		}
+		if server.Versions.Enabled() && server.Versions.MaxAge > 0 {
+			go sweepVersions(d.store, server)
+		}
+
 		log.Println("Listening on", listener.Addr().String())
 		//nolint: gosec
 		if err := http.Serve(listener, handler); err != nil {
@@ -203,8 +238,42 @@ func getRunParams(flags *pflag.FlagSet, st *storage.Storage) *settings.Server {
 	checkErr(err)
 
 	if _, set := getParamB(flags, "ga"); set {
-		users.SetFs(getParam(flags, "ga-addr"))
+		poolSize, err := flags.GetInt("ga-conns")
+		checkErr(err)
+		opTimeout, err := flags.GetDuration("ga-timeout")
+		checkErr(err)
+		blockSize, err := flags.GetInt("ga-block-size")
+		checkErr(err)
+		opts := services.Options{
+			PoolSize:  poolSize,
+			OpTimeout: opTimeout,
+			BlockSize: blockSize,
+			TLSConfig: getDeviceTLSConfig(flags),
+		}
+		mounts := getDeviceMounts(flags)
+		cacheTTLs := getDeviceCacheTTLs(flags)
+		device.SetDefaults(users.DeviceOptions{Options: opts, CacheTTL: cacheTTLs[""]})
+		for _, value := range getDeviceAddrs(flags) {
+			name, addr, found := strings.Cut(value, "=")
+			if !found {
+				name, addr = users.DefaultDevice, value
+			}
+			ttl, ok := cacheTTLs[name]
+			if !ok {
+				ttl = cacheTTLs[""]
+			}
+			checkErr(users.AddDevice(name, addr, users.DeviceOptions{
+				Options:  opts,
+				Mounts:   mounts[name],
+				CacheTTL: ttl,
+			}))
+			delete(mounts, name)
+		}
+		for name := range mounts {
+			checkErr(fmt.Errorf("--ga-mount: unknown device %q", name))
+		}
 	}
+	attachDevices(st)
 
 	if val, set := getParamB(flags, "root"); set {
 		server.Root = val
@@ -267,6 +336,16 @@ func getRunParams(flags *pflag.FlagSet, st *storage.Storage) *settings.Server {
 	_, disableExec := getParamB(flags, "disable-exec")
 	server.EnableExec = !disableExec
 
+	if val, set := getParamB(flags, "trash-dir"); set {
+		server.TrashDir = val
+	}
+
+	for _, key := range serverPolicyFlags {
+		if val, set := getParamB(flags, key); set {
+			checkErr(setServerPolicy(server, key, val))
+		}
+	}
+
 	return server
 }
 
@@ -278,7 +357,10 @@ func getRunParams(flags *pflag.FlagSet, st *storage.Storage) *settings.Server {
 // the flag and then the value from env/config/gotten by viper.
 // https://github.com/spf13/viper/pull/331
 func getParamB(flags *pflag.FlagSet, key string) (string, bool) {
-	value, _ := flags.GetString(key)
+	value := ""
+	if flag := flags.Lookup(key); flag != nil {
+		value = flag.Value.String()
+	}
 
 	// If set on Flags, use it.
 	if flags.Changed(key) {
@@ -299,6 +381,109 @@ func getParam(flags *pflag.FlagSet, key string) string {
 	return val
 }
 
+// getDeviceAddrs returns the ga-addr values, which may be given several
+// times, following the same precedence as getParamB.
+func getDeviceAddrs(flags *pflag.FlagSet) []string {
+	addrs, _ := flags.GetStringArray("ga-addr")
+	if !flags.Changed("ga-addr") && v.IsSet("ga-addr") {
+		addrs = v.GetStringSlice("ga-addr")
+	}
+	return addrs
+}
+
+// getDeviceTLSConfig returns the configuration of the connections to tls://
+// ga file servers, or nil when the defaults do.
+func getDeviceTLSConfig(flags *pflag.FlagSet) *tls.Config {
+	ca, cert, key := getParam(flags, "ga-ca"), getParam(flags, "ga-cert"), getParam(flags, "ga-key")
+	if ca == "" && cert == "" && key == "" {
+		return nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca != "" {
+		data, err := os.ReadFile(ca)
+		checkErr(err)
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			checkErr(fmt.Errorf("--ga-ca: no certificate found in %s", ca))
+		}
+	}
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		checkErr(err)
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config
+}
+
+// attachDevices attaches the devices stored by the devices command and the
+// API, which replace the ga-addr ones of the same name, and warns about the
+// devices that cannot be reached. Offline devices stay attached and are
+// dialed again by the next request.
+func attachDevices(st *storage.Storage) {
+	stored, err := st.Devices.All()
+	if err != nil && err != fberrors.ErrNotExist {
+		checkErr(err)
+	}
+	for _, dev := range stored {
+		if dev.Disabled {
+			_ = users.RemoveDevice(dev.Name)
+			continue
+		}
+		if err := dev.Attach(); err != nil {
+			log.Printf("device %s: %v", dev.Name, err)
+		}
+	}
+
+	go func() {
+		for name, status := range users.DeviceStatuses(context.Background()) {
+			if !status.Connected {
+				log.Printf("Warning: device %s is offline: %s", name, status.Error)
+			}
+		}
+	}()
+}
+
+// getDeviceMounts returns the ga-mount values grouped by device name.
+func getDeviceMounts(flags *pflag.FlagSet) map[string][]afcfs.MountConfig {
+	values, err := flags.GetStringArray("ga-mount")
+	checkErr(err)
+
+	mounts := map[string][]afcfs.MountConfig{}
+	for _, value := range values {
+		device := users.DefaultDevice
+		if !strings.HasPrefix(value, "/") {
+			device, value, _ = strings.Cut(value, ":")
+		}
+		mount, err := afcfs.ParseMount(value)
+		checkErr(err)
+		mounts[device] = append(mounts[device], mount)
+	}
+	return mounts
+}
+
+// getDeviceCacheTTLs returns the ga-cache-ttl values by device name; the
+// value for devices without their own is under the empty name.
+func getDeviceCacheTTLs(flags *pflag.FlagSet) map[string]time.Duration {
+	values, err := flags.GetStringArray("ga-cache-ttl")
+	checkErr(err)
+	if !flags.Changed("ga-cache-ttl") && v.IsSet("ga-cache-ttl") {
+		values = v.GetStringSlice("ga-cache-ttl")
+	}
+
+	ttls := map[string]time.Duration{}
+	for _, value := range values {
+		name, ttl, found := strings.Cut(value, "=")
+		if !found {
+			name, ttl = "", value
+		}
+		d, err := time.ParseDuration(ttl)
+		checkErr(err)
+		ttls[name] = d
+	}
+	return ttls
+}
+
 func setupLog(logMethod string) {
 	switch logMethod {
 	case "stdout":
@@ -362,14 +547,19 @@ func quickSetup(flags *pflag.FlagSet, d pythonData) {
 	err = d.store.Settings.Save(set)
 	checkErr(err)
 
-	ser := &settings.Server{
-		BaseURL: getParam(flags, "baseurl"),
-		Port:    getParam(flags, "port"),
-		Log:     getParam(flags, "log"),
-		TLSKey:  getParam(flags, "key"),
-		TLSCert: getParam(flags, "cert"),
-		Address: getParam(flags, "address"),
-		Root:    getParam(flags, "root"),
+	ser := settings.DefaultServer()
+	ser.BaseURL = getParam(flags, "baseurl")
+	ser.Port = getParam(flags, "port")
+	ser.Log = getParam(flags, "log")
+	ser.TLSKey = getParam(flags, "key")
+	ser.TLSCert = getParam(flags, "cert")
+	ser.Address = getParam(flags, "address")
+	ser.Root = getParam(flags, "root")
+	ser.TrashDir = getParam(flags, "trash-dir")
+	for _, key := range serverPolicyFlags {
+		if val, set := getParamB(flags, key); set {
+			checkErr(setServerPolicy(ser, key, val))
+		}
 	}
 
 	err = d.store.Settings.SaveServer(ser)
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/snapshot"
+)
+
+func init() {
+	rootCmd.AddCommand(snapshotsCmd)
+	snapshotsCmd.PersistentFlags().Bool("json", false, "print the output as JSON")
+	snapshotsCmd.PersistentFlags().Uint("user", 0, "id of the user owning the snapshots, 0 for the command line")
+}
+
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots",
+	Short: "Snapshots management utility",
+	Long: `Snapshots management utility. A snapshot records the path, size,
+modification time and, optionally, hash of the files of a tree, so that
+the files created, modified or deleted since can be listed.`,
+	Args: cobra.NoArgs,
+}
+
+// snapshotSource returns the filesystem a snapshot taken from the command
+// line was read from, and a function closing it.
+func snapshotSource(flags *pflag.FlagSet, snap *snapshot.Snapshot) (afero.Fs, func()) {
+	switch snap.Source {
+	case "":
+		checkErr(fmt.Errorf("%s: taken in the scope of user %d, compare it with another snapshot", snap.Name, snap.UserID))
+		return nil, nil
+	case snapshot.SourceLocal:
+		return govfs.NewOsFs(), func() {}
+	default:
+		fs := dialDevice(flags, snap.Source)
+		return fs, func() { fs.Close() }
+	}
+}
+
+func printDiff(diff *snapshot.Diff, asJSON bool) {
+	if asJSON {
+		printJSON(diff)
+		return
+	}
+	for _, entry := range diff.Created {
+		fmt.Printf("A\t%s\n", entry.Path)
+	}
+	for _, change := range diff.Modified {
+		fmt.Printf("M\t%s\n", change.Path)
+	}
+	for _, entry := range diff.Deleted {
+		fmt.Printf("D\t%s\n", entry.Path)
+	}
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/snapshot"
+)
+
+func init() {
+	snapshotsCmd.AddCommand(snapshotsDiffCmd)
+	addDeviceFlags(snapshotsDiffCmd.Flags())
+}
+
+var snapshotsDiffCmd = &cobra.Command{
+	Use:   "diff <name> [other]",
+	Short: "List the changes between two snapshots",
+	Long: `List the files created (A), modified (M) and deleted (D) between
+the snapshots name and other. Without other, the snapshot is compared
+with the current state of the tree it was taken of; the device flags
+then only set the timeouts and sizes of the requests.`,
+	Args: cobra.RangeArgs(1, 2), //nolint:gomnd
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		user := mustGetUint(cmd.Flags(), "user")
+		from, err := d.store.Snapshots.Get(user, args[0])
+		checkErr(err)
+
+		var (
+			to     []snapshot.Entry
+			toName string
+		)
+		if len(args) == 2 { //nolint:gomnd
+			snap, err := d.store.Snapshots.Get(user, args[1])
+			checkErr(err)
+			to, toName = snap.Entries, snap.Name
+		} else {
+			fs, closeFs := snapshotSource(cmd.Flags(), from)
+			defer closeFs()
+			to, err = snapshot.Capture(fs, from.Path, from.Algo, nil)
+			checkErr(err)
+		}
+
+		diff := snapshot.Compare(from.Entries, to)
+		diff.From, diff.To = from.Name, toName
+		printDiff(diff, mustGetBool(cmd.Flags(), "json"))
+	}, pythonConfig{}),
+}
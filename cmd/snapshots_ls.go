@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/snapshot"
+)
+
+func init() {
+	snapshotsCmd.AddCommand(snapshotsLsCmd)
+}
+
+var snapshotsLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List all snapshots",
+	Long:  `List all snapshots.`,
+	Args:  cobra.NoArgs,
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		snaps, err := d.store.Snapshots.All()
+		if err != errors.ErrNotExist {
+			checkErr(err)
+		}
+		sort.Slice(snaps, func(i, j int) bool {
+			return snaps[i].Created.Before(snaps[j].Created)
+		})
+
+		if mustGetBool(cmd.Flags(), "json") {
+			list := make([]snapshot.Snapshot, 0, len(snaps))
+			for _, snap := range snaps {
+				s := *snap
+				s.Entries = nil
+				list = append(list, s)
+			}
+			printJSON(list)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+		fmt.Fprintln(w, "Name\tUser\tSource\tPath\tAlgo\tEntries\tCreated")
+		for _, snap := range snaps {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%d\t%s\n", snap.Name, snap.UserID, snap.Source,
+				snap.Path, snap.Algo, len(snap.Entries), snap.Created.Format(time.RFC3339))
+		}
+		checkErr(w.Flush())
+	}, pythonConfig{}),
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	snapshotsCmd.AddCommand(snapshotsRmCmd)
+}
+
+var snapshotsRmCmd = &cobra.Command{
+	Use:   "rm <name>...",
+	Short: "Delete snapshots",
+	Long:  `Delete snapshots by name.`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		for _, name := range args {
+			snap, err := d.store.Snapshots.Get(mustGetUint(cmd.Flags(), "user"), name)
+			checkErr(err)
+			checkErr(d.store.Snapshots.Delete(snap.ID))
+			fmt.Printf("snapshot %s deleted\n", name)
+		}
+	}, pythonConfig{}),
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/snapshot"
+)
+
+func init() {
+	snapshotsCmd.AddCommand(snapshotsTakeCmd)
+	flags := snapshotsTakeCmd.Flags()
+	addDeviceFlags(flags)
+	flags.Bool("local", false, "take the snapshot of a directory of this machine instead of the device")
+	flags.String("algo", "", "hash the files with md5, sha1, sha256 or sha512")
+}
+
+var snapshotsTakeCmd = &cobra.Command{
+	Use:   "take <name> <path>",
+	Short: "Take a snapshot of a device or local directory",
+	Long: `Take a snapshot of a device directory, or a local one with --local,
+and save it under name.`,
+	Args: cobra.ExactArgs(2), //nolint:gomnd
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		flags := cmd.Flags()
+		snap := &snapshot.Snapshot{
+			Name:    args[0],
+			UserID:  mustGetUint(flags, "user"),
+			Algo:    mustGetString(flags, "algo"),
+			Created: time.Now(),
+		}
+
+		var fs afero.Fs
+		if mustGetBool(flags, "local") {
+			dir, err := filepath.Abs(args[1])
+			checkErr(err)
+			fs, snap.Source, snap.Path = govfs.NewOsFs(), snapshot.SourceLocal, dir
+		} else {
+			snap.Source, snap.Path = deviceAddr(flags), path.Clean("/"+args[1])
+			device := dialDevice(flags, snap.Source)
+			defer device.Close()
+			fs = device
+		}
+
+		var err error
+		snap.Entries, err = snapshot.Capture(fs, snap.Path, snap.Algo, nil)
+		checkErr(err)
+		checkErr(d.store.Snapshots.Create(snap))
+		fmt.Printf("snapshot %s taken: %d entries\n", snap.Name, len(snap.Entries))
+	}, pythonConfig{}),
+}
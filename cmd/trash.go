@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/filebrowser/filebrowser/v2/device"
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/settings"
+	"github.com/filebrowser/filebrowser/v2/storage"
+	"github.com/filebrowser/filebrowser/v2/trash"
+	"github.com/filebrowser/filebrowser/v2/users"
+	"github.com/filebrowser/filebrowser/v2/versions"
+)
+
+func init() {
+	rootCmd.AddCommand(trashCmd)
+	flags := trashCmd.PersistentFlags()
+	flags.Bool("json", false, "print the output as JSON")
+	flags.Bool("ga", true, "the server runs in ga mode")
+	flags.StringArray("ga-addr", []string{"127.0.0.1:5001"}, "ga file server addr of the server, as [name=][tcp://|tls://]host:port or [name=]unix:///path")
+	flags.String("ga-ca", "", "PEM file of the certificate authorities trusted for tls:// ga file servers (defaults to the system ones)")
+	flags.String("ga-cert", "", "PEM file of the client certificate sent to tls:// ga file servers")
+	flags.String("ga-key", "", "PEM file of the key of --ga-cert")
+	flags.Duration("ga-timeout", services.DefaultOpTimeout, "timeout of a single request to the ga file server")
+}
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Trash management utility",
+	Long: `Trash management utility. Unless the server runs with
+--disable-trash, the files deleted by the users are moved to a hidden
+area at the root of their scope, or to --trash-dir, and purged once
+they are older than --trash-retention.
+
+Restoring and purging items reaches the devices of the users: give the
+ga flags the server runs with.`,
+	Args: cobra.NoArgs,
+}
+
+// trashSweepInterval is the longest time between two sweeps of the trash.
+const trashSweepInterval = time.Hour
+
+// userFsFunc returns a function returning the filesystem of a user.
+func userFsFunc(st *storage.Storage, server *settings.Server) func(id uint) (afero.Fs, error) {
+	return func(id uint) (afero.Fs, error) {
+		user, err := st.Users.Get(server.Root, id)
+		if err != nil {
+			return nil, err
+		}
+		return user.Fs, nil
+	}
+}
+
+// sweepTrash purges the trash items older than the retention of server,
+// forever.
+func sweepTrash(st *storage.Storage, server *settings.Server) {
+	t := &trash.Trash{Store: st.Trash, LocalDir: server.TrashDir}
+	interval := trashSweepInterval
+	if server.TrashRetention < interval {
+		interval = server.TrashRetention
+	}
+	for {
+		purged, err := t.Sweep(time.Now().Add(-server.TrashRetention), userFsFunc(st, server))
+		if err != nil {
+			log.Printf("trash: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("trash: %d expired items purged", purged)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// sweepVersions removes the versions older than the policy of server
+// allows from the filesystem of every user, forever.
+func sweepVersions(st *storage.Storage, server *settings.Server) {
+	interval := trashSweepInterval
+	if server.Versions.MaxAge < interval {
+		interval = server.Versions.MaxAge
+	}
+	for {
+		all, err := st.Users.Gets(server.Root)
+		if err != nil && err != errors.ErrNotExist {
+			log.Printf("versions: %v", err)
+		}
+		removed := 0
+		for _, user := range all {
+			n, err := versions.Sweep(user.Fs, server.Versions) //nolint:govet
+			if err != nil {
+				log.Printf("versions of %s: %v", user.Username, err)
+			}
+			removed += n
+		}
+		if removed > 0 {
+			log.Printf("versions: %d expired versions removed", removed)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// attachTrashDevices attaches the devices of the server, so that the
+// filesystem of its users is reachable.
+func attachTrashDevices(flags *pflag.FlagSet, st *storage.Storage) {
+	if !mustGetBool(flags, "ga") {
+		return
+	}
+	opTimeout, err := flags.GetDuration("ga-timeout")
+	checkErr(err)
+	opts := users.DeviceOptions{Options: services.Options{
+		PoolSize:          1,
+		ReconnectAttempts: 1,
+		OpTimeout:         opTimeout,
+		TLSConfig:         getDeviceTLSConfig(flags),
+	}}
+	device.SetDefaults(opts)
+	for _, value := range getDeviceAddrs(flags) {
+		name, addr, found := strings.Cut(value, "=")
+		if !found {
+			name, addr = users.DefaultDevice, value
+		}
+		checkErr(users.AddDevice(name, addr, opts))
+	}
+
+	stored, err := st.Devices.All()
+	if err != nil && err != errors.ErrNotExist {
+		checkErr(err)
+	}
+	for _, dev := range stored {
+		if dev.Disabled {
+			_ = users.RemoveDevice(dev.Name)
+			continue
+		}
+		checkErr(dev.Attach())
+	}
+}
+
+// openTrash returns the trash of the server and a function returning the
+// filesystem of its users.
+func openTrash(flags *pflag.FlagSet, st *storage.Storage) (*trash.Trash, func(id uint) (afero.Fs, error)) {
+	server, err := st.Settings.GetServer()
+	checkErr(err)
+	attachTrashDevices(flags, st)
+	return &trash.Trash{Store: st.Trash, LocalDir: server.TrashDir}, userFsFunc(st, server)
+}
+
+// getTrashItem returns the trash item whose id is arg.
+func getTrashItem(d pythonData, arg string) *trash.Item {
+	id, err := strconv.ParseUint(arg, 10, 0)
+	checkErr(err)
+	item, err := d.store.Trash.Get(uint(id))
+	checkErr(err)
+	return item
+}
+
+// getTrashUser returns the user whose username or id is arg.
+func getTrashUser(d pythonData, arg string) *users.User {
+	var (
+		user *users.User
+		err  error
+	)
+	username, id := parseUsernameOrID(arg)
+	if username != "" {
+		user, err = d.store.Users.Get("", username)
+	} else {
+		user, err = d.store.Users.Get("", id)
+	}
+	checkErr(err)
+	return user
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+)
+
+func init() {
+	trashCmd.AddCommand(trashEmptyCmd)
+	flags := trashEmptyCmd.Flags()
+	flags.String("user", "", "empty the trash of this user, by username or id, instead of every user")
+	flags.Duration("older-than", 0, "only purge the items deleted longer ago than this")
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Empty the trash",
+	Long: `Delete the trash items of every user, or of --user, for good. With
+--older-than, only the items deleted longer ago are purged, as the
+server does with --trash-retention.`,
+	Args: cobra.NoArgs,
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		flags := cmd.Flags()
+		olderThan, err := flags.GetDuration("older-than")
+		checkErr(err)
+		before := time.Now().Add(-olderThan)
+		t, fsOf := openTrash(flags, d.store)
+
+		arg := mustGetString(flags, "user")
+		if arg == "" {
+			purged, err := t.Sweep(before, fsOf) //nolint:govet
+			fmt.Printf("%d items purged\n", purged)
+			checkErr(err)
+			return
+		}
+
+		user := getTrashUser(d, arg)
+		items, err := d.store.Trash.FindByUserID(user.ID)
+		if err != errors.ErrNotExist {
+			checkErr(err)
+		}
+		fs, err := fsOf(user.ID)
+		checkErr(err)
+		purged := 0
+		for _, item := range items {
+			if item.Deleted.Before(before) {
+				checkErr(t.Purge(fs, item))
+				purged++
+			}
+		}
+		fmt.Printf("%d items purged\n", purged)
+	}, pythonConfig{}),
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/trash"
+)
+
+func init() {
+	trashCmd.AddCommand(trashLsCmd)
+	trashLsCmd.Flags().String("user", "", "list the trash of this user, by username or id, instead of every user")
+}
+
+var trashLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the trash items",
+	Long:  `List the trash items, the latest deleted first.`,
+	Args:  cobra.NoArgs,
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		var (
+			items []*trash.Item
+			err   error
+		)
+		if arg := mustGetString(cmd.Flags(), "user"); arg != "" {
+			user := getTrashUser(d, arg)
+			items, err = d.store.Trash.FindByUserID(user.ID)
+		} else {
+			items, err = d.store.Trash.All()
+		}
+		if err != errors.ErrNotExist {
+			checkErr(err)
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Deleted.After(items[j].Deleted)
+		})
+
+		if mustGetBool(cmd.Flags(), "json") {
+			if items == nil {
+				items = []*trash.Item{}
+			}
+			printJSON(items)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+		fmt.Fprintln(w, "ID\tUser\tPath\tSize\tDeleted\tLocal")
+		for _, item := range items {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%t\n", item.ID, item.UserID, item.Path,
+				formatSize(uint64(item.Size)), item.Deleted.Format("2006-01-02 15:04:05"), item.Local != "")
+		}
+		checkErr(w.Flush())
+	}, pythonConfig{}),
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	trashCmd.AddCommand(trashPurgeCmd)
+}
+
+var trashPurgeCmd = &cobra.Command{
+	Use:   "purge <id>...",
+	Short: "Delete trash items for good",
+	Long:  `Delete trash items for good.`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		t, fsOf := openTrash(cmd.Flags(), d.store)
+		for _, arg := range args {
+			item := getTrashItem(d, arg)
+			fs, err := fsOf(item.UserID)
+			checkErr(err)
+			checkErr(t.Purge(fs, item))
+			fmt.Printf("%d purged\n", item.ID)
+		}
+	}, pythonConfig{}),
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashRestoreCmd.Flags().String("to", "", "path to restore a single item to, in the scope of its user (defaults to where it was deleted from)")
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore <id>...",
+	Short: "Restore trash items",
+	Long: `Restore trash items to where they were deleted from, or to --to.
+An item whose path is taken again is not restored.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		to := mustGetString(cmd.Flags(), "to")
+		if to != "" && len(args) != 1 {
+			checkErr(fmt.Errorf("--to restores a single item"))
+		}
+		t, fsOf := openTrash(cmd.Flags(), d.store)
+		for _, arg := range args {
+			item := getTrashItem(d, arg)
+			fs, err := fsOf(item.UserID)
+			checkErr(err)
+			dst := item.Path
+			if to != "" {
+				dst = path.Clean("/" + to)
+			}
+			checkErr(t.Restore(fs, item, dst))
+			fmt.Printf("%d restored to %s\n", item.ID, dst)
+		}
+	}, pythonConfig{}),
+}
@@ -74,6 +74,7 @@ func addUserFlags(flags *pflag.FlagSet) {
 	flags.Bool("lockPassword", false, "lock password")
 	flags.StringSlice("commands", nil, "a list of the commands a user can execute")
 	flags.String("scope", ".", "scope for users")
+	flags.String("device", "", "ga device of users (defaults to \""+users.DefaultDevice+"\")")
 	flags.String("locale", "en", "locale for users")
 	flags.String("viewMode", string(users.ListViewMode), "view mode for users")
 	flags.Bool("singleClick", false, "use single clicks only")
@@ -93,6 +94,8 @@ func getUserDefaults(flags *pflag.FlagSet, defaults *settings.UserDefaults, all
 		switch flag.Name {
 		case "scope":
 			defaults.Scope = mustGetString(flags, flag.Name)
+		case "device":
+			defaults.Device = mustGetString(flags, flag.Name)
 		case "locale":
 			defaults.Locale = mustGetString(flags, flag.Name)
 		case "viewMode":
@@ -0,0 +1,84 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs/afcfs"
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/users"
+)
+
+// Device is an AFC server registered at runtime. Devices that are not
+// disabled are attached to the server when it starts.
+type Device struct {
+	Name string `json:"name" storm:"id"`
+	Addr string `json:"addr"`
+	// Mounts are the extra services of the device, as
+	// "<mount point>=<addr>".
+	Mounts []string `json:"mounts,omitempty"`
+	// CacheTTL is a duration, such as "1s"; empty means the default of the
+	// server and "0" disables the cache.
+	CacheTTL string `json:"cacheTTL,omitempty"`
+	Disabled bool   `json:"disabled"`
+}
+
+var (
+	defaultsMutex sync.RWMutex
+	defaults      users.DeviceOptions
+)
+
+// SetDefaults sets the connection options and cache TTL of the devices
+// attached by Attach. Their mounts are ignored.
+func SetDefaults(opts users.DeviceOptions) {
+	defaultsMutex.Lock()
+	defer defaultsMutex.Unlock()
+	defaults = opts
+}
+
+// Options returns the options of the device on top of the defaults.
+func (d *Device) Options() (users.DeviceOptions, error) {
+	if d.Name == "" || strings.ContainsAny(d.Name, `/\=:`) {
+		return users.DeviceOptions{}, fmt.Errorf("device name %q: %w", d.Name, errors.ErrInvalidRequestParams)
+	}
+	if _, _, _, err := services.ParseAddr(d.Addr); err != nil {
+		return users.DeviceOptions{}, fmt.Errorf("%v: %w", err, errors.ErrInvalidRequestParams)
+	}
+
+	defaultsMutex.RLock()
+	opts := defaults
+	defaultsMutex.RUnlock()
+
+	opts.Mounts = nil
+	for _, value := range d.Mounts {
+		mount, err := afcfs.ParseMount(value)
+		if err != nil {
+			return users.DeviceOptions{}, fmt.Errorf("%v: %w", err, errors.ErrInvalidRequestParams)
+		}
+		if _, _, _, err = services.ParseAddr(mount.Addr); err != nil {
+			return users.DeviceOptions{}, fmt.Errorf("%v: %w", err, errors.ErrInvalidRequestParams)
+		}
+		opts.Mounts = append(opts.Mounts, mount)
+	}
+	if d.CacheTTL != "" {
+		ttl, err := time.ParseDuration(d.CacheTTL)
+		if err != nil || ttl < 0 {
+			return users.DeviceOptions{}, fmt.Errorf("cache TTL %q: %w", d.CacheTTL, errors.ErrInvalidRequestParams)
+		}
+		opts.CacheTTL = ttl
+	}
+	return opts, nil
+}
+
+// Attach registers the device with users.AddDevice. The device does not
+// have to be online.
+func (d *Device) Attach() error {
+	opts, err := d.Options()
+	if err != nil {
+		return err
+	}
+	return users.AddDevice(d.Name, d.Addr, opts)
+}
@@ -0,0 +1,63 @@
+package device
+
+import (
+	"github.com/filebrowser/filebrowser/v2/errors"
+)
+
+// StorageBackend is the interface to implement for a device storage.
+type StorageBackend interface {
+	All() ([]*Device, error)
+	Get(name string) (*Device, error)
+	Save(d *Device) error
+	Delete(name string) error
+}
+
+// Storage is a storage.
+type Storage struct {
+	back StorageBackend
+}
+
+// NewStorage creates a device storage from a backend.
+func NewStorage(back StorageBackend) *Storage {
+	return &Storage{back: back}
+}
+
+// All wraps a StorageBackend.All.
+func (s *Storage) All() ([]*Device, error) {
+	return s.back.All()
+}
+
+// Get wraps a StorageBackend.Get.
+func (s *Storage) Get(name string) (*Device, error) {
+	return s.back.Get(name)
+}
+
+// Create saves a new device. It fails with errors.ErrExist when the name is
+// taken.
+func (s *Storage) Create(d *Device) error {
+	if _, err := d.Options(); err != nil {
+		return err
+	}
+	_, err := s.back.Get(d.Name)
+	switch err {
+	case nil:
+		return errors.ErrExist
+	case errors.ErrNotExist:
+		return s.back.Save(d)
+	default:
+		return err
+	}
+}
+
+// Save validates and saves a device, replacing any device of the same name.
+func (s *Storage) Save(d *Device) error {
+	if _, err := d.Options(); err != nil {
+		return err
+	}
+	return s.back.Save(d)
+}
+
+// Delete wraps a StorageBackend.Delete.
+func (s *Storage) Delete(name string) error {
+	return s.back.Delete(name)
+}
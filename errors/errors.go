@@ -18,4 +18,7 @@ var (
 	ErrInvalidRequestParams = errors.New("invalid request params")
 	ErrSourceIsParent       = errors.New("source is parent")
 	ErrRootUserDeletion     = errors.New("user with id 1 can't be deleted")
+	ErrUnknownDevice        = errors.New("unknown device")
+	ErrInvalidJobState      = errors.New("invalid job state")
+	ErrArchiveTooLarge      = errors.New("archive exceeds the extraction limits")
 )
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	stdErrors "errors"
 	"hash"
 	"io"
 	"log"
@@ -20,6 +21,7 @@ import (
 	"github.com/spf13/afero"
 
 	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
 	"github.com/filebrowser/filebrowser/v2/rules"
 )
 
@@ -44,6 +46,9 @@ type FileInfo struct {
 	Checksums  map[string]string `json:"checksums,omitempty"`
 	Token      string            `json:"token,omitempty"`
 	currentDir []os.FileInfo     `json:"-"`
+
+	// RemoteChecksums marks the checksums computed by the storage itself.
+	RemoteChecksums map[string]bool `json:"remoteChecksums,omitempty"`
 }
 
 // FileOptions are the options when getting a file info.
@@ -148,7 +153,8 @@ func stat(opts FileOptions) (*FileInfo, error) {
 }
 
 // Checksum checksums a given File for a given User, using a specific
-// algorithm. The checksums data is saved on File object.
+// algorithm. The checksums data is saved on File object. When the storage
+// can hash the file itself with algo, the content is not read.
 func (i *FileInfo) Checksum(algo string) error {
 	if i.IsDir {
 		return errors.ErrIsDirectory
@@ -158,12 +164,6 @@ func (i *FileInfo) Checksum(algo string) error {
 		i.Checksums = map[string]string{}
 	}
 
-	reader, err := i.Fs.Open(i.Path)
-	if err != nil {
-		return err
-	}
-	defer reader.Close()
-
 	var h hash.Hash
 
 	//nolint:gosec
@@ -180,6 +180,27 @@ func (i *FileInfo) Checksum(algo string) error {
 		return errors.ErrInvalidOption
 	}
 
+	if hasher, ok := i.Fs.(govfs.Hasher); ok {
+		sum, err := hasher.Hash(i.Path, algo, 0, i.Size)
+		if err == nil {
+			i.Checksums[algo] = hex.EncodeToString(sum)
+			if i.RemoteChecksums == nil {
+				i.RemoteChecksums = map[string]bool{}
+			}
+			i.RemoteChecksums[algo] = true
+			return nil
+		}
+		if !stdErrors.Is(err, govfs.ErrNotSupported) {
+			return err
+		}
+	}
+
+	reader, err := i.Fs.Open(i.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
 	_, err = io.Copy(h, reader)
 	if err != nil {
 		return err
@@ -1,14 +1,26 @@
 package fileutils
 
 import (
+	"context"
+	"io"
 	"os"
 	"path"
 
 	"github.com/spf13/afero"
 )
 
+// Progress is told the bytes copied and the files done by the functions
+// taking one.
+type Progress func(bytes int64, files int)
+
 // Copy copies a file or folder from one place to another.
 func Copy(fs afero.Fs, src, dst string) error {
+	return CopyContext(context.Background(), fs, src, dst, nil)
+}
+
+// CopyContext is Copy, stopping once ctx is done and reporting to progress,
+// which may be nil.
+func CopyContext(ctx context.Context, fs afero.Fs, src, dst string, progress Progress) error {
 	if src = path.Clean("/" + src); src == "" {
 		return os.ErrNotExist
 	}
@@ -31,9 +43,44 @@ func Copy(fs afero.Fs, src, dst string) error {
 		return err
 	}
 
+	c := &copier{ctx: ctx, progress: progress}
 	if info.IsDir() {
-		return CopyDir(fs, src, dst)
+		return c.copyDir(fs, src, dst)
+	}
+
+	return c.copyFile(fs, src, dst)
+}
+
+// copier carries the context and progress of a copy.
+type copier struct {
+	ctx      context.Context
+	progress Progress
+}
+
+func (c *copier) report(bytes int64, files int) {
+	if c.progress != nil {
+		c.progress(bytes, files)
 	}
+}
+
+// reader fails with the error of the context once it is done, and reports
+// the bytes read.
+func (c *copier) reader(r io.Reader) io.Reader {
+	return &progressReader{c: c, r: r}
+}
 
-	return CopyFile(fs, src, dst)
+type progressReader struct {
+	c *copier
+	r io.Reader
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	if err := r.c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.c.report(int64(n), 0)
+	}
+	return n, err
 }
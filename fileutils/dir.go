@@ -1,15 +1,23 @@
 package fileutils
 
 import (
+	"context"
 	"errors"
 
 	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
 )
 
 // CopyDir copies a directory from source to dest and all
 // of its sub-directories. It doesn't stop if it finds an error
 // during the copy. Returns an error if any.
 func CopyDir(fs afero.Fs, source, dest string) error {
+	c := &copier{ctx: context.Background()}
+	return c.copyDir(fs, source, dest)
+}
+
+func (c *copier) copyDir(fs afero.Fs, source, dest string) error {
 	// Get properties of source.
 	srcinfo, err := fs.Stat(source)
 	if err != nil {
@@ -22,8 +30,7 @@ func CopyDir(fs afero.Fs, source, dest string) error {
 		return err
 	}
 
-	dir, _ := fs.Open(source)
-	obs, err := dir.Readdir(-1)
+	obs, err := govfs.ReadDir(fs, source)
 	if err != nil {
 		return err
 	}
@@ -31,24 +38,33 @@ func CopyDir(fs afero.Fs, source, dest string) error {
 	var errs []error
 
 	for _, obj := range obs {
+		// Do not go on with the next file once the copy is canceled.
+		if err = c.ctx.Err(); err != nil {
+			return err
+		}
+
 		fsource := source + "/" + obj.Name()
 		fdest := dest + "/" + obj.Name()
 
 		if obj.IsDir() {
 			// Create sub-directories, recursively.
-			err = CopyDir(fs, fsource, fdest)
+			err = c.copyDir(fs, fsource, fdest)
 			if err != nil {
 				errs = append(errs, err)
 			}
 		} else {
 			// Perform the file copy.
-			err = CopyFile(fs, fsource, fdest)
+			err = c.copyFile(fs, fsource, fdest)
 			if err != nil {
 				errs = append(errs, err)
 			}
 		}
 	}
 
+	if err = c.ctx.Err(); err != nil {
+		return err
+	}
+
 	var errString string
 	for _, err := range errs {
 		errString += err.Error() + "\n"
@@ -1,28 +1,35 @@
 package fileutils
 
 import (
-	"io"
-	"os"
+	"context"
 	"path"
 	"path/filepath"
 
 	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
 )
 
 // MoveFile moves file from src to dst.
 // By default the rename filesystem system call is used. If src and dst point to different volumes
 // the file copy is used as a fallback
 func MoveFile(fs afero.Fs, src, dst string) error {
+	return MoveFileContext(context.Background(), fs, src, dst, nil)
+}
+
+// MoveFileContext is MoveFile, stopping once ctx is done and reporting the
+// progress of the copy fallback to progress, which may be nil.
+func MoveFileContext(ctx context.Context, fs afero.Fs, src, dst string, progress Progress) error {
 	if fs.Rename(src, dst) == nil {
 		return nil
 	}
 	// fallback
-	err := Copy(fs, src, dst)
+	err := CopyContext(ctx, fs, src, dst, progress)
 	if err != nil {
 		_ = fs.Remove(dst)
 		return err
 	}
-	if err := fs.RemoveAll(src); err != nil {
+	if err := govfs.RemoveAll(fs, src); err != nil {
 		return err
 	}
 	return nil
@@ -31,6 +38,11 @@ func MoveFile(fs afero.Fs, src, dst string) error {
 // CopyFile copies a file from source to dest and returns
 // an error if any.
 func CopyFile(fs afero.Fs, source, dest string) error {
+	c := &copier{ctx: context.Background()}
+	return c.copyFile(fs, source, dest)
+}
+
+func (c *copier) copyFile(fs afero.Fs, source, dest string) error {
 	// Open the source file.
 	src, err := fs.Open(source)
 	if err != nil {
@@ -45,15 +57,9 @@ func CopyFile(fs afero.Fs, source, dest string) error {
 		return err
 	}
 
-	// Create the destination file.
-	dst, err := fs.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0775) //nolint:gomnd
-	if err != nil {
-		return err
-	}
-	defer dst.Close()
-
-	// Copy the contents of the file.
-	_, err = io.Copy(dst, src)
+	// Copy the contents of the file, readers of dest never see
+	// a partial copy.
+	err = govfs.WriteFile(fs, dest, c.reader(src), 0775) //nolint:gomnd
 	if err != nil {
 		return err
 	}
@@ -68,6 +74,7 @@ func CopyFile(fs afero.Fs, source, dest string) error {
 		return err
 	}
 
+	c.report(0, 1)
 	return nil
 }
 
@@ -27,7 +27,7 @@ export async function upload(
   filePath = removePrefix(filePath);
   let resourcePath = `${tusEndpoint}${filePath}?override=${overwrite}`;
 
-  await createUpload(resourcePath);
+  await createUpload(resourcePath, content.size);
 
   return new Promise((resolve, reject) => {
     let upload = new tus.Upload(content, {
@@ -85,9 +85,12 @@ export async function upload(
   });
 }
 
-async function createUpload(resourcePath) {
+async function createUpload(resourcePath, length) {
   let headResp = await fetchURL(resourcePath, {
     method: "POST",
+    headers: {
+      "Upload-Length": length,
+    },
   });
   if (headResp.status !== 201) {
     throw new Error(
@@ -5,9 +5,13 @@ import (
 	"path"
 	"syscall"
 
-	"git.woa.com/CloudTesting/UDT/ioskit/ioskit/services"
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
 )
 
+// VFile is a synthesized directory of the virtual root. It lists the next
+// path segments of the mount points below it.
 type VFile struct {
 	absPath string
 	names   []string
@@ -74,3 +78,56 @@ func (f *VFile) Truncate(size int64) error {
 func (f *VFile) WriteString(s string) (ret int, err error) {
 	return -1, syscall.EPFNOSUPPORT
 }
+
+// mergedDir is a directory served by a mount that also has mount points
+// below it. Its listings show them next to the entries of the service.
+type mergedDir struct {
+	afero.File
+	extra []string
+}
+
+func (f *mergedDir) Readdir(count int) (fi []os.FileInfo, err error) {
+	if count > 0 {
+		return f.File.Readdir(count)
+	}
+
+	infos, err := f.File.Readdir(count)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if !f.shadowed(info.Name()) {
+			fi = append(fi, info)
+		}
+	}
+	for _, name := range f.extra {
+		fi = append(fi, services.NewDirStatInfo(name))
+	}
+	return fi, nil
+}
+
+func (f *mergedDir) Readdirnames(count int) (names []string, err error) {
+	if count > 0 {
+		return f.File.Readdirnames(count)
+	}
+
+	all, err := f.File.Readdirnames(count)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range all {
+		if !f.shadowed(name) {
+			names = append(names, name)
+		}
+	}
+	return append(names, f.extra...), nil
+}
+
+func (f *mergedDir) shadowed(name string) bool {
+	for _, extra := range f.extra {
+		if name == extra {
+			return true
+		}
+	}
+	return false
+}
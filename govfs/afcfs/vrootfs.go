@@ -1,74 +1,246 @@
 package afcfs
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"path"
 	"runtime"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/filebrowser/filebrowser/v2/govfs"
 	"github.com/filebrowser/filebrowser/v2/govfs/services"
 	"github.com/spf13/afero"
 )
 
 const (
-	afcMountPath = ""
-	// crashreportsMountPath = "/crashreports"
-	// sandboxMountPath      = "/apps"
-	// documentsPath         = "/Documents"
-	documentsDirName = "Documents"
+	afcMountPath          = ""
+	crashreportsMountPath = "/crashreports"
+	sandboxMountPath      = "/apps"
+	documentsDirName      = "Documents"
 )
 
+// MountConfig describes an AFC service mounted into the virtual root next
+// to the media partition.
+type MountConfig struct {
+	Type     services.FsType
+	BundleId string //only used for house_arrest
+	Addr     string
+}
+
+// ParseMount parses a mount given as "<mount point>=<addr>". The mount point
+// selects the service: /apps/<bundle>/Documents for the documents of an app,
+// /apps/<bundle> for its whole container and /crashreports for crash logs.
+func ParseMount(s string) (MountConfig, error) {
+	point, addr, found := strings.Cut(s, "=")
+	if !found || addr == "" {
+		return MountConfig{}, fmt.Errorf("invalid mount %q: expected <mount point>=<addr>", s)
+	}
+
+	point = path.Clean(point)
+	if point == crashreportsMountPath {
+		return MountConfig{Type: services.CrashReportFs, Addr: addr}, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(point, sandboxMountPath+"/"), "/")
+	if strings.HasPrefix(point, sandboxMountPath+"/") && parts[0] != "" {
+		switch {
+		case len(parts) == 1:
+			return MountConfig{Type: services.HouseArrestContainerFs, BundleId: parts[0], Addr: addr}, nil
+		case len(parts) == 2 && parts[1] == documentsDirName:
+			return MountConfig{Type: services.HouseArrestDocumentFs, BundleId: parts[0], Addr: addr}, nil
+		}
+	}
+	return MountConfig{}, fmt.Errorf("invalid mount point %q", point)
+}
+
+// Point returns where the service appears in the virtual root.
+func (c MountConfig) Point() string {
+	switch c.Type {
+	case services.CrashReportFs:
+		return crashreportsMountPath
+	case services.HouseArrestContainerFs:
+		return path.Join(sandboxMountPath, c.BundleId)
+	case services.HouseArrestDocumentFs:
+		return path.Join(sandboxMountPath, c.BundleId, documentsDirName)
+	default:
+		return afcMountPath
+	}
+}
+
+// mountRoot returns the directory of the service that is exposed at its
+// mount point. house_arrest vends the documents of an app below Documents.
+func mountRoot(t services.FsType) string {
+	if t == services.HouseArrestDocumentFs {
+		return "/" + documentsDirName
+	}
+	return "/"
+}
+
+type mount struct {
+	point string
+	fs    *services.Fsync
+}
+
+// VirtualRootFs assembles several AFC services into one tree. Paths are
+// resolved against the mount with the longest matching mount point; the
+// directories above mount points that no service provides are synthesized.
 type VirtualRootFs struct {
 	afero.Fs
-	addr        string
-	mountPoints map[string]*services.Fsync
+	addr string
+	// mounts is ordered by decreasing mount point length
+	mounts []*mount
 }
 
 func NewVfs(addr string) (*VirtualRootFs, error) {
-	afcFs, err := services.NewFsync(addr)
+	return NewVfsWithOptions(addr, services.Options{})
+}
+
+// NewVfsWithOptions mounts the media partition served at addr as the root,
+// and each of mounts at its mount point.
+func NewVfsWithOptions(addr string, opts services.Options, mounts ...MountConfig) (*VirtualRootFs, error) {
+	rootFs := &VirtualRootFs{addr: addr}
+
+	afcFs, err := services.NewFsyncWithOptions(addr, opts)
 	if err != nil {
 		return nil, err
 	}
+	rootFs.Mount(afcMountPath, afcFs)
 
-	rootFs := &VirtualRootFs{
-		addr:        addr,
-		mountPoints: make(map[string]*services.Fsync),
+	for _, m := range mounts {
+		f, err := services.NewFsyncWithOptions(m.Addr, opts)
+		if err != nil {
+			_ = rootFs.Close()
+			return nil, fmt.Errorf("mount %q: %w", m.Point(), err)
+		}
+		f.FsType = m.Type
+		f.BundleId = m.BundleId
+		rootFs.Mount(m.Point(), f)
 	}
-	rootFs.Mount(afcMountPath, afcFs)
 	return rootFs, nil
 }
 
-func (fs *VirtualRootFs) trimPath(path string, mountPoint string) string {
-	trimmedPath := strings.TrimPrefix(path, mountPoint)
-	if !strings.HasPrefix(trimmedPath, "/") {
-		trimmedPath = "/" + trimmedPath
+// findMountPoint returns the mount serving filepath and the path on it.
+func (fs *VirtualRootFs) findMountPoint(filepath string) (f afero.Fs, p string) {
+	m, np := fs.findMountPoint2(filepath)
+	if m == nil {
+		return nil, ""
 	}
-	return trimmedPath
+	return m.fs, np
 }
 
-func (fs *VirtualRootFs) findMountPoint(filepath string) (f afero.Fs, p string) {
-	nf, _, np := fs.findMountPoint2(filepath)
-	return nf, np
+func (fs *VirtualRootFs) findMountPoint2(filepath string) (m *mount, newPath string) {
+	filepath = path.Clean("/" + filepath)
+	for _, m := range fs.mounts {
+		if m.point != afcMountPath && filepath != m.point && !strings.HasPrefix(filepath, m.point+"/") {
+			continue
+		}
+		rel := strings.TrimPrefix(filepath, m.point)
+		return m, path.Join(mountRoot(m.fs.FsType), rel)
+	}
+	return nil, ""
+}
+
+// virtualChildren returns the names of the entries a listing of dir has to
+// show for the mount points below it.
+func (fs *VirtualRootFs) virtualChildren(dir string) []string {
+	dir = path.Clean("/" + dir)
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range fs.mounts {
+		if !strings.HasPrefix(m.point, prefix) {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(m.point, prefix), "/", 2)[0] //nolint:gomnd
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isVirtualDir reports whether name is synthesized: it lies above a mount
+// point and is not itself served by a mount other than the root.
+func (fs *VirtualRootFs) isVirtualDir(name string) bool {
+	m, _ := fs.findMountPoint2(name)
+	if m != nil && m.point != afcMountPath {
+		return false
+	}
+	return len(fs.virtualChildren(name)) > 0 && path.Clean("/"+name) != "/"
 }
 
-func (fs *VirtualRootFs) findMountPoint2(filepath string) (f afero.Fs, mountPoint, newPath string) {
-	for mp, f := range fs.mountPoints {
-		if strings.HasPrefix(filepath, mp) {
-			np := strings.TrimPrefix(filepath, mp)
-			return f, mp, np
+// inVirtualDir reports whether name is a synthesized directory or lies in
+// one without being served by a mount. Such paths cannot be written.
+func (fs *VirtualRootFs) inVirtualDir(name string) bool {
+	if m, _ := fs.findMountPoint2(name); m != nil && m.point != afcMountPath {
+		return false
+	}
+	for p := path.Clean("/" + name); p != "/"; p = path.Dir(p) {
+		if len(fs.virtualChildren(p)) > 0 {
+			return true
 		}
 	}
-	return nil, "", ""
+	return false
 }
 
 func (fs *VirtualRootFs) Mount(mountPath string, vfs *services.Fsync) {
-	fs.mountPoints[mountPath] = vfs
+	fs.mounts = append(fs.mounts, &mount{point: mountPath, fs: vfs})
+	sort.SliceStable(fs.mounts, func(i, j int) bool {
+		return len(fs.mounts[i].point) > len(fs.mounts[j].point)
+	})
+}
+
+// Unmount removes the mount at mountPath and closes its connections.
+func (fs *VirtualRootFs) Unmount(mountPath string) error {
+	for i, m := range fs.mounts {
+		if m.point == mountPath {
+			fs.mounts = append(fs.mounts[:i], fs.mounts[i+1:]...)
+			return m.fs.Close()
+		}
+	}
+	return os.ErrNotExist
+}
+
+// Close closes the connections of every mount.
+func (fs *VirtualRootFs) Close() error {
+	var err error
+	for _, m := range fs.mounts {
+		if cerr := m.fs.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// WithContext returns a view of fs whose requests on every mount are bound
+// to ctx.
+func (fs *VirtualRootFs) WithContext(ctx context.Context) afero.Fs {
+	bound := &VirtualRootFs{
+		addr:   fs.addr,
+		mounts: make([]*mount, 0, len(fs.mounts)),
+	}
+	for _, m := range fs.mounts {
+		bound.mounts = append(bound.mounts, &mount{point: m.point, fs: m.fs.WithContext(ctx).(*services.Fsync)})
+	}
+	return bound
 }
 
-func (fs *VirtualRootFs) Unmount(mountPath string) {
-	// TODO: need call fs.Unmount
-	delete(fs.mountPoints, mountPath)
+// Health reports the first mount whose AFC server cannot be reached.
+func (fs *VirtualRootFs) Health() error {
+	for _, m := range fs.mounts {
+		if err := m.fs.Health(); err != nil {
+			return fmt.Errorf("mount %q: %w", m.point, err)
+		}
+	}
+	return nil
 }
 
 func winPathToUnix(name string) string {
@@ -82,7 +254,7 @@ func (fs *VirtualRootFs) Create(name string) (afero.File, error) {
 	name = winPathToUnix(name)
 
 	mp, newPath := fs.findMountPoint(name)
-	if mp == nil {
+	if mp == nil || fs.inVirtualDir(name) {
 		return nil, syscall.EPERM
 	}
 	return mp.Create(newPath)
@@ -92,7 +264,7 @@ func (fs *VirtualRootFs) Mkdir(name string, perm os.FileMode) error {
 	name = winPathToUnix(name)
 
 	mp, newPath := fs.findMountPoint(name)
-	if mp == nil {
+	if mp == nil || fs.inVirtualDir(name) {
 		return syscall.EPERM
 	}
 
@@ -102,8 +274,11 @@ func (fs *VirtualRootFs) Mkdir(name string, perm os.FileMode) error {
 func (fs *VirtualRootFs) MkdirAll(name string, perm os.FileMode) error {
 	name = winPathToUnix(name)
 
+	if fs.isVirtualDir(name) {
+		return nil
+	}
 	mp, newPath := fs.findMountPoint(name)
-	if mp == nil {
+	if mp == nil || fs.inVirtualDir(name) {
 		return syscall.EPERM
 	}
 
@@ -120,18 +295,39 @@ func (fs *VirtualRootFs) Open(name string) (afero.File, error) {
 func (fs *VirtualRootFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
 	name = winPathToUnix(name)
 
+	if fs.isVirtualDir(name) {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, syscall.EPERM
+		}
+		return &VFile{absPath: name, names: fs.virtualChildren(name)}, nil
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 && fs.inVirtualDir(name) {
+		return nil, syscall.EPERM
+	}
 	f, newPath := fs.findMountPoint(name)
-	if f != nil {
-		return f.OpenFile(newPath, flag, perm)
+	if f == nil {
+		if children := fs.virtualChildren(name); len(children) > 0 {
+			return &VFile{absPath: name, names: children}, nil
+		}
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	file, err := f.OpenFile(newPath, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if children := fs.virtualChildren(name); len(children) > 0 {
+		return &mergedDir{File: file, extra: children}, nil
 	}
-	return nil, syscall.ENOENT
+	return file, nil
 }
 
 func (fs *VirtualRootFs) Remove(name string) error {
 	name = winPathToUnix(name)
 
 	mp, newPath := fs.findMountPoint(name)
-	if mp == nil {
+	if mp == nil || fs.inVirtualDir(name) {
 		return syscall.EPERM
 	}
 
@@ -142,7 +338,7 @@ func (fs *VirtualRootFs) RemoveAll(name string) error {
 	name = winPathToUnix(name)
 
 	mp, newPath := fs.findMountPoint(name)
-	if mp == nil {
+	if mp == nil || fs.inVirtualDir(name) {
 		return syscall.EPERM
 	}
 
@@ -153,20 +349,30 @@ func (fs *VirtualRootFs) Rename(oldname, newname string) error {
 	oldname = winPathToUnix(oldname)
 	newname = winPathToUnix(newname)
 
-	mp, point, oldname2 := fs.findMountPoint2(oldname)
-	if mp == nil {
+	oldMount, oldname2 := fs.findMountPoint2(oldname)
+	if oldMount == nil || fs.inVirtualDir(oldname) || fs.inVirtualDir(newname) {
 		return syscall.EPERM
 	}
-	newname2 := fs.trimPath(newname, point)
-	return mp.Rename(oldname2, newname2)
+	newMount, newname2 := fs.findMountPoint2(newname)
+	if newMount != oldMount {
+		// callers such as fileutils.MoveFile fall back to copying
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	return oldMount.fs.Rename(oldname2, newname2)
 }
 
 func (fs *VirtualRootFs) Stat(name string) (os.FileInfo, error) {
 	name = winPathToUnix(name)
 
+	if fs.isVirtualDir(name) {
+		return services.NewDirStatInfo(name), nil
+	}
 	mp, newPath := fs.findMountPoint(name)
 	if mp == nil {
-		return services.NewDirStatInfo(name), nil
+		if len(fs.virtualChildren(name)) > 0 {
+			return services.NewDirStatInfo(name), nil
+		}
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
 	}
 
 	return mp.Stat(newPath)
@@ -198,9 +404,130 @@ func (fs *VirtualRootFs) Chtimes(name string, atime time.Time, mtime time.Time)
 	name = winPathToUnix(name)
 
 	mp, newPath := fs.findMountPoint(name)
-	if mp == nil {
+	if mp == nil || fs.inVirtualDir(name) {
 		return syscall.EPERM
 	}
 
 	return mp.Chtimes(newPath, atime, mtime)
 }
+
+func (fs *VirtualRootFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	name = winPathToUnix(name)
+
+	if fs.isVirtualDir(name) {
+		return services.NewDirStatInfo(name), true, nil
+	}
+	mp, newPath := fs.findMountPoint2(name)
+	if mp == nil {
+		info, err := fs.Stat(name)
+		return info, false, err
+	}
+	return mp.fs.LstatIfPossible(newPath)
+}
+
+// SymlinkIfPossible creates newname pointing to oldname. Relative targets are
+// stored as given, absolute targets must lie on the same mount as the link
+// and are stored as paths on that service.
+func (fs *VirtualRootFs) SymlinkIfPossible(oldname, newname string) error {
+	oldname = winPathToUnix(oldname)
+	newname = winPathToUnix(newname)
+
+	m, newname2 := fs.findMountPoint2(newname)
+	if m == nil || fs.inVirtualDir(newname) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+
+	target := oldname
+	if path.IsAbs(oldname) {
+		var targetMount *mount
+		targetMount, target = fs.findMountPoint2(oldname)
+		if targetMount != m {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EXDEV}
+		}
+	}
+	return m.fs.SymlinkIfPossible(target, newname2)
+}
+
+// ReadlinkIfPossible returns the target of the link at name, with absolute
+// targets translated back into the virtual root.
+func (fs *VirtualRootFs) ReadlinkIfPossible(name string) (string, error) {
+	name = winPathToUnix(name)
+
+	m, newPath := fs.findMountPoint2(name)
+	if m == nil || fs.isVirtualDir(name) {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	target, err := m.fs.ReadlinkIfPossible(newPath)
+	if err != nil || !path.IsAbs(target) {
+		return target, err
+	}
+
+	root := mountRoot(m.fs.FsType)
+	target = path.Clean(target)
+	if root != "/" && target != root && !strings.HasPrefix(target, root+"/") {
+		// the target is outside of what the mount exposes
+		return target, nil
+	}
+	return path.Join("/", m.point, strings.TrimPrefix(target, root)), nil
+}
+
+func (fs *VirtualRootFs) RemoveTree(name string) error {
+	name = winPathToUnix(name)
+
+	mp, newPath := fs.findMountPoint2(name)
+	if mp == nil || fs.inVirtualDir(name) {
+		return syscall.EPERM
+	}
+	return mp.fs.RemoveTree(newPath)
+}
+
+// TreeSize is only served by a mount for trees without other mounts below
+// them; for the others govfs.TreeSize walks the merged tree.
+func (fs *VirtualRootFs) TreeSize(name string) (int64, error) {
+	name = winPathToUnix(name)
+
+	mp, newPath := fs.findMountPoint2(name)
+	if mp == nil || len(fs.virtualChildren(name)) > 0 {
+		return 0, govfs.ErrNotSupported
+	}
+	return mp.fs.TreeSize(newPath)
+}
+
+// EnumerateDir is only served by a mount for directories without virtual
+// entries, the others are listed by Open.
+func (fs *VirtualRootFs) EnumerateDir(name string, fn func(info os.FileInfo) error) error {
+	name = winPathToUnix(name)
+
+	mp, newPath := fs.findMountPoint2(name)
+	if mp == nil || len(fs.virtualChildren(name)) > 0 {
+		return govfs.ErrNotSupported
+	}
+	return mp.fs.EnumerateDir(newPath, fn)
+}
+
+func (fs *VirtualRootFs) Hash(name, algo string, off, length int64) ([]byte, error) {
+	mp, newPath := fs.findMountPoint2(winPathToUnix(name))
+	if mp == nil {
+		return nil, govfs.ErrNotSupported
+	}
+	return mp.fs.Hash(newPath, algo, off, length)
+}
+
+func (fs *VirtualRootFs) WriteFileAtomic(name string, r io.Reader, perm os.FileMode) error {
+	name = winPathToUnix(name)
+
+	mp, newPath := fs.findMountPoint2(name)
+	if mp == nil || fs.inVirtualDir(name) {
+		return govfs.ErrNotSupported
+	}
+	return mp.fs.WriteFileAtomic(newPath, r, perm)
+}
+
+// Usage reports the storage of the device; the mounts all live on it.
+func (fs *VirtualRootFs) Usage(name string) (*govfs.Usage, error) {
+	mp, _ := fs.findMountPoint2(winPathToUnix(name))
+	if mp == nil {
+		return nil, govfs.ErrNotSupported
+	}
+	return mp.fs.Usage("/")
+}
@@ -0,0 +1,174 @@
+package afcfs
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/govfs/services/afctest"
+)
+
+func TestParseMount(t *testing.T) {
+	testCases := map[string]struct {
+		mount string
+		want  MountConfig
+		err   bool
+	}{
+		"documents":    {mount: "/apps/com.example.app/Documents=h:1", want: MountConfig{Type: services.HouseArrestDocumentFs, BundleId: "com.example.app", Addr: "h:1"}},
+		"container":    {mount: "/apps/com.example.app=h:1", want: MountConfig{Type: services.HouseArrestContainerFs, BundleId: "com.example.app", Addr: "h:1"}},
+		"crashreports": {mount: "/crashreports/=h:1", want: MountConfig{Type: services.CrashReportFs, Addr: "h:1"}},
+		"no addr":      {mount: "/crashreports", err: true},
+		"unknown":      {mount: "/media=h:1", err: true},
+		"apps root":    {mount: "/apps=h:1", err: true},
+	}
+
+	for name, tc := range testCases {
+		got, err := ParseMount(tc.mount)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", name, got, tc.want)
+		}
+	}
+}
+
+func readDirNames(t *testing.T, fs afero.Fs, name string) []string {
+	t.Helper()
+	infos, err := afero.ReadDir(fs, name)
+	if err != nil {
+		t.Fatalf("ReadDir(%q): %v", name, err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestVirtualRootFsMounts(t *testing.T) {
+	media, app, crashes := afctest.NewServer(), afctest.NewServer(), afctest.NewServer()
+	defer media.Close()
+	defer app.Close()
+	defer crashes.Close()
+
+	for srv, name := range map[*afctest.Server]string{
+		media:   "/DCIM/photo.jpg",
+		app:     "/Documents/notes.txt",
+		crashes: "/panic.ips",
+	} {
+		if err := afero.WriteFile(srv.Fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs, err := NewVfsWithOptions(media.Addr(), services.Options{PoolSize: 1},
+		MountConfig{Type: services.HouseArrestDocumentFs, BundleId: "com.example.app", Addr: app.Addr()},
+		MountConfig{Type: services.CrashReportFs, Addr: crashes.Addr()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if got, want := readDirNames(t, fs, "/"), []string{"DCIM", "apps", "crashreports"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("root listing = %v, want %v", got, want)
+	}
+	if got, want := readDirNames(t, fs, "/apps"), []string{"com.example.app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("apps listing = %v, want %v", got, want)
+	}
+	if got, want := readDirNames(t, fs, "/apps/com.example.app/Documents"), []string{"notes.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("documents listing = %v, want %v", got, want)
+	}
+	if got, want := readDirNames(t, fs, "/crashreports"), []string{"panic.ips"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("crashreports listing = %v, want %v", got, want)
+	}
+
+	info, err := fs.Stat("/apps/com.example.app")
+	if err != nil || !info.IsDir() {
+		t.Errorf("virtual directory: %v %v", info, err)
+	}
+
+	data, err := afero.ReadFile(fs, "/apps/com.example.app/Documents/notes.txt")
+	if err != nil || string(data) != "/Documents/notes.txt" {
+		t.Errorf("read through the documents mount: %q %v", data, err)
+	}
+
+	if err = afero.WriteFile(fs, "/crashreports/new.ips", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := afero.Exists(crashes.Fs, "/new.ips"); !ok {
+		t.Error("file written to the crashreports mount is missing")
+	}
+
+	if err = fs.Mkdir("/apps/other", 0755); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("creating in a virtual directory: got %v", err)
+	}
+
+	var linkErr *os.LinkError
+	if err = fs.Rename("/DCIM/photo.jpg", "/crashreports/photo.jpg"); !errors.As(err, &linkErr) {
+		t.Errorf("rename across mounts: got %v", err)
+	}
+
+	if err = fs.Unmount(crashreportsMountPath); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readDirNames(t, fs, "/"), []string{"DCIM", "apps"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("root listing after unmount = %v, want %v", got, want)
+	}
+}
+
+func TestVirtualRootFsSymlinks(t *testing.T) {
+	media, app := afctest.NewServer(), afctest.NewServer()
+	defer media.Close()
+	defer app.Close()
+	if err := afero.WriteFile(app.Fs, "/Documents/notes.txt", []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := NewVfsWithOptions(media.Addr(), services.Options{PoolSize: 1},
+		MountConfig{Type: services.HouseArrestDocumentFs, BundleId: "com.example.app", Addr: app.Addr()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	const docs = "/apps/com.example.app/Documents"
+	if err = fs.SymlinkIfPossible(docs+"/notes.txt", docs+"/latest.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := afero.Exists(app.Fs, "/Documents/latest.txt"); !ok {
+		t.Fatal("link is missing on the device")
+	}
+	if target, err := fs.ReadlinkIfPossible(docs + "/latest.txt"); err != nil || target != docs+"/notes.txt" {
+		t.Errorf("readlink = %q %v", target, err)
+	}
+	info, _, err := fs.LstatIfPossible(docs + "/latest.txt")
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat = %v %v", info, err)
+	}
+	data, err := afero.ReadFile(fs, docs+"/latest.txt")
+	if err != nil || string(data) != "notes" {
+		t.Errorf("read through the link = %q %v", data, err)
+	}
+
+	if err = fs.SymlinkIfPossible(docs+"/notes.txt", "/notes.txt"); !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("link across mounts: got %v", err)
+	}
+	if err = fs.SymlinkIfPossible("/DCIM", "/apps/link"); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("link in a virtual directory: got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package govfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// AtomicWriter is implemented by filesystems that replace the content of a
+// file in a single step, so that readers see either the old or the new
+// content. WriteFileAtomic may only return ErrNotSupported before reading
+// from r.
+type AtomicWriter interface {
+	WriteFileAtomic(name string, r io.Reader, perm os.FileMode) error
+}
+
+// WriteFile replaces the content of name with r without exposing partial
+// content: with WriteFileAtomic on AtomicWriters, by writing a sibling
+// temporary file and renaming it over name on the others.
+func WriteFile(fs afero.Fs, name string, r io.Reader, perm os.FileMode) error {
+	if writer, ok := fs.(AtomicWriter); ok {
+		err := writer.WriteFileAtomic(name, r, perm)
+		if !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return ReplaceFile(fs, name, r, perm)
+}
+
+// TempName returns the name of a hidden temporary file next to name.
+func TempName(name string) string {
+	suffix := make([]byte, 6) //nolint:gomnd
+	_, _ = rand.Read(suffix)
+	dir, base := path.Split(name)
+	return path.Join(dir, "."+base+"."+hex.EncodeToString(suffix)+".tmp")
+}
+
+// ReplaceFile writes r to a temporary file next to name, syncs it and
+// renames it over name. The temporary file is removed when any step fails.
+// When name exists, its mode is kept rather than perm, and when it is a
+// symbolic link of the local filesystem, its target is replaced rather than
+// the link.
+func ReplaceFile(fs afero.Fs, name string, r io.Reader, perm os.FileMode) error {
+	fs, name = resolveLinks(fs, name)
+	keep := false
+	if info, err := fs.Stat(name); err == nil {
+		perm, keep = info.Mode().Perm(), true
+	}
+
+	tmp := TempName(name)
+	f, err := fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if keep {
+		// the mode given on creation is restricted by the umask; backends
+		// without modes refuse the change, which is fine
+		_ = fs.Chmod(tmp, perm)
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fs.Rename(tmp, name)
+	}
+	if err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// resolveLinks returns the filesystem and path of the file name designates
+// once its symbolic links are followed. Only the links of the local
+// filesystem are followed, other paths are returned unchanged.
+func resolveLinks(fs afero.Fs, name string) (afero.Fs, string) {
+	real, target := fs, name
+	if scoped, ok := fs.(*ScopedFs); ok {
+		realPath, err := scoped.RealPath(name)
+		if err != nil {
+			return fs, name
+		}
+		real, target = scoped.Source(), realPath
+	}
+	switch real.(type) {
+	case *OsFs, *afero.OsFs:
+	default:
+		return fs, name
+	}
+
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil || resolved == target {
+		// name is not a link, or does not exist yet
+		return fs, name
+	}
+	return real, resolved
+}
@@ -0,0 +1,72 @@
+package govfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestWriteFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/a/notes.txt", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	errDropped := errors.New("connection dropped")
+	in := io.MultiReader(strings.NewReader("partial"), &failingReader{err: errDropped})
+	if err := WriteFile(fs, "/a/notes.txt", in, 0644); !errors.Is(err, errDropped) {
+		t.Fatalf("interrupted write: got %v", err)
+	}
+	if data, _ := afero.ReadFile(fs, "/a/notes.txt"); string(data) != "old" {
+		t.Errorf("content after an interrupted write: %q", data)
+	}
+
+	if err := WriteFile(fs, "/a/notes.txt", strings.NewReader("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(fs, "/a/notes.txt"); string(data) != "new" {
+		t.Errorf("content after a write: %q", data)
+	}
+	if infos, _ := afero.ReadDir(fs, "/a"); len(infos) != 1 {
+		t.Errorf("temporary files were left: %d entries", len(infos))
+	}
+}
+
+func TestReplaceFileLocal(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewScopedFs(NewOsFs(), dir)
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("old"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "run.sh"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/run.sh", strings.NewReader("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "run.sh")); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("mode after a write: %v %v", info.Mode(), err)
+	}
+
+	if err := os.Symlink("run.sh", filepath.Join(dir, "link.sh")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+	if err := WriteFile(fs, "/link.sh", strings.NewReader("through the link"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "link.sh")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("the link was replaced: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "run.sh")); string(data) != "through the link" {
+		t.Errorf("content of the link target: %q", data)
+	}
+}
+
+type failingReader struct{ err error }
+
+func (r *failingReader) Read([]byte) (int, error) { return 0, r.err }
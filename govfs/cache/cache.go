@@ -0,0 +1,481 @@
+// Package cache provides an afero.Fs that keeps the stat of files and the
+// listing of directories of a slow backend for a short time. Changes made
+// through the cache invalidate what they affect; changes made on the
+// backend by others show up once the entries expire.
+package cache
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+const (
+	// DefaultTTL is how long entries are kept when Options.TTL is not set.
+	DefaultTTL = 5 * time.Second
+	// DefaultMaxEntries bounds the number of cached paths when
+	// Options.MaxEntries is not set.
+	DefaultMaxEntries = 100000
+)
+
+// Options tunes a cache.
+type Options struct {
+	// TTL is how long entries are served without asking the backend.
+	TTL time.Duration
+	// MaxEntries is the number of paths kept before expired entries are
+	// dropped, and everything if none is expired.
+	MaxEntries int
+}
+
+type bypassKey struct{}
+
+// Bypass returns a context under which filesystems bound with
+// govfs.WithContext read from the backend instead of the cache. What they
+// read still refreshes the cache.
+func Bypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey{}, true)
+}
+
+// entry is what is known about one path.
+type entry struct {
+	info    os.FileInfo // Stat
+	linfo   os.FileInfo // LstatIfPossible
+	lstated bool        // whether the source could lstat
+	dir     []os.FileInfo
+	expires time.Time
+}
+
+type store struct {
+	mu      sync.Mutex
+	opts    Options
+	entries map[string]*entry
+}
+
+// get returns the entry of name if it did not expire.
+func (s *store) get(name string) (entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok || time.Now().After(e.expires) {
+		return entry{}, false
+	}
+	return *e, true
+}
+
+// update changes the entry of name with fn, starting from an empty entry
+// when it expired.
+func (s *store) update(name string, fn func(e *entry)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	e, ok := s.entries[name]
+	if !ok || now.After(e.expires) {
+		if len(s.entries) >= s.opts.MaxEntries {
+			s.evict(now)
+		}
+		e = &entry{expires: now.Add(s.opts.TTL)}
+		s.entries[name] = e
+	}
+	fn(e)
+}
+
+// evict drops the expired entries, or all of them if none expired. The
+// caller must hold the lock.
+func (s *store) evict(now time.Time) {
+	for name, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, name)
+		}
+	}
+	if len(s.entries) >= s.opts.MaxEntries {
+		s.entries = map[string]*entry{}
+	}
+}
+
+// invalidate forgets name, everything below it when tree is set, and the
+// listing and stat of its parent.
+func (s *store) invalidate(name string, tree bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, name)
+	delete(s.entries, path.Dir(name))
+	if !tree {
+		return
+	}
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p := range s.entries {
+		if strings.HasPrefix(p, prefix) {
+			delete(s.entries, p)
+		}
+	}
+}
+
+// Fs caches the metadata of the wrapped filesystem.
+type Fs struct {
+	afero.Fs
+	store  *store
+	bypass bool
+}
+
+// New returns a cache in front of source.
+func New(source afero.Fs, opts Options) *Fs {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	return &Fs{
+		Fs:    source,
+		store: &store{opts: opts, entries: map[string]*entry{}},
+	}
+}
+
+func key(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// WithContext binds the source to ctx, sharing the cache with fs. The
+// result bypasses the cache if ctx was returned by Bypass.
+func (fs *Fs) WithContext(ctx context.Context) afero.Fs {
+	bound := *fs
+	bound.Fs = govfs.WithContext(fs.Fs, ctx)
+	bound.bypass = ctx.Value(bypassKey{}) != nil
+	return &bound
+}
+
+func (fs *Fs) Name() string {
+	return "CacheFs"
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	k := key(name)
+	if e, ok := fs.store.get(k); ok && e.info != nil && !fs.bypass {
+		return e.info, nil
+	}
+
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	fs.store.update(k, func(e *entry) { e.info = info })
+	return info, nil
+}
+
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	lstater, ok := fs.Fs.(afero.Lstater)
+	if !ok {
+		info, err := fs.Stat(name)
+		return info, false, err
+	}
+
+	k := key(name)
+	if e, ok := fs.store.get(k); ok && e.linfo != nil && !fs.bypass {
+		return e.linfo, e.lstated, nil
+	}
+
+	info, lstated, err := lstater.LstatIfPossible(name)
+	if err != nil {
+		return nil, lstated, err
+	}
+	fs.store.update(k, func(e *entry) {
+		e.linfo, e.lstated = info, lstated
+	})
+	return info, lstated, nil
+}
+
+// readDir returns the cached listing of name.
+func (fs *Fs) readDir(name string) ([]os.FileInfo, bool) {
+	if fs.bypass {
+		return nil, false
+	}
+	e, ok := fs.store.get(key(name))
+	if !ok || e.dir == nil {
+		return nil, false
+	}
+	return e.dir, true
+}
+
+// storeDir caches the listing of name and, with it, the lstat of every
+// entry.
+func (fs *Fs) storeDir(name string, infos []os.FileInfo) {
+	k := key(name)
+	fs.store.update(k, func(e *entry) {
+		e.dir = append([]os.FileInfo{}, infos...)
+	})
+	for _, info := range infos {
+		info := info
+		fs.store.update(path.Join(k, info.Name()), func(e *entry) {
+			e.linfo, e.lstated = info, true
+			if info.Mode()&os.ModeSymlink == 0 {
+				e.info = info
+			}
+		})
+	}
+}
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	f, err := fs.Fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	writable := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	if writable {
+		fs.store.invalidate(key(name), false)
+	}
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: f, fs: fs, name: name, writable: writable}, nil
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666) //nolint:gomnd
+}
+
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	defer fs.store.invalidate(key(name), false)
+	return fs.Fs.Mkdir(name, perm)
+}
+
+func (fs *Fs) MkdirAll(name string, perm os.FileMode) error {
+	defer func() {
+		for k := key(name); k != "/"; k = path.Dir(k) {
+			fs.store.invalidate(k, false)
+		}
+	}()
+	return fs.Fs.MkdirAll(name, perm)
+}
+
+func (fs *Fs) Remove(name string) error {
+	defer fs.store.invalidate(key(name), true)
+	return fs.Fs.Remove(name)
+}
+
+func (fs *Fs) RemoveAll(name string) error {
+	defer fs.store.invalidate(key(name), true)
+	return fs.Fs.RemoveAll(name)
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	defer fs.store.invalidate(key(newname), true)
+	defer fs.store.invalidate(key(oldname), true)
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	defer fs.store.invalidate(key(name), false)
+	return fs.Fs.Chmod(name, mode)
+}
+
+func (fs *Fs) Chown(name string, uid, gid int) error {
+	defer fs.store.invalidate(key(name), false)
+	return fs.Fs.Chown(name, uid, gid)
+}
+
+func (fs *Fs) Chtimes(name string, atime, mtime time.Time) error {
+	defer fs.store.invalidate(key(name), false)
+	return fs.Fs.Chtimes(name, atime, mtime)
+}
+
+func (fs *Fs) SymlinkIfPossible(oldname, newname string) error {
+	linker, ok := fs.Fs.(afero.Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+	}
+	defer fs.store.invalidate(key(newname), false)
+	return linker.SymlinkIfPossible(oldname, newname)
+}
+
+func (fs *Fs) ReadlinkIfPossible(name string) (string, error) {
+	reader, ok := fs.Fs.(afero.LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+	}
+	return reader.ReadlinkIfPossible(name)
+}
+
+func (fs *Fs) RemoveTree(name string) error {
+	remover, ok := fs.Fs.(govfs.TreeRemover)
+	if !ok {
+		return govfs.ErrNotSupported
+	}
+	defer fs.store.invalidate(key(name), true)
+	return remover.RemoveTree(name)
+}
+
+func (fs *Fs) TreeSize(name string) (int64, error) {
+	sizer, ok := fs.Fs.(govfs.TreeSizer)
+	if !ok {
+		return 0, govfs.ErrNotSupported
+	}
+	return sizer.TreeSize(name)
+}
+
+// EnumerateDir serves the listing from the cache, or enumerates the source
+// and caches the result.
+func (fs *Fs) EnumerateDir(name string, fn func(info os.FileInfo) error) error {
+	infos, ok := fs.readDir(name)
+	if !ok {
+		enumerator, ok := fs.Fs.(govfs.DirEnumerator)
+		if !ok {
+			return govfs.ErrNotSupported
+		}
+		err := enumerator.EnumerateDir(name, func(info os.FileInfo) error {
+			infos = append(infos, info)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fs.storeDir(name, infos)
+	}
+
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *Fs) Usage(name string) (*govfs.Usage, error) {
+	provider, ok := fs.Fs.(govfs.UsageProvider)
+	if !ok {
+		return nil, govfs.ErrNotSupported
+	}
+	return provider.Usage(name)
+}
+
+func (fs *Fs) Hash(name, algo string, off, length int64) ([]byte, error) {
+	hasher, ok := fs.Fs.(govfs.Hasher)
+	if !ok {
+		return nil, govfs.ErrNotSupported
+	}
+	return hasher.Hash(name, algo, off, length)
+}
+
+func (fs *Fs) WriteFileAtomic(name string, r io.Reader, perm os.FileMode) error {
+	writer, ok := fs.Fs.(govfs.AtomicWriter)
+	if !ok {
+		return govfs.ErrNotSupported
+	}
+	defer fs.store.invalidate(key(name), false)
+	return writer.WriteFileAtomic(name, r, perm)
+}
+
+// Health reports the health of the source, if it can tell.
+func (fs *Fs) Health() error {
+	if checker, ok := fs.Fs.(interface{ Health() error }); ok {
+		return checker.Health()
+	}
+	return nil
+}
+
+// Close closes the source, if it can be closed.
+func (fs *Fs) Close() error {
+	if closer, ok := fs.Fs.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// File serves full directory listings from the cache and invalidates the
+// stat of files written through it.
+type File struct {
+	afero.File
+	fs       *Fs
+	name     string
+	writable bool
+	// listed is set once the whole directory was returned
+	listed bool
+}
+
+// Readdir serves reads of the whole directory from the cache.
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	if count > 0 {
+		return f.File.Readdir(count)
+	}
+	if f.listed {
+		return []os.FileInfo{}, nil
+	}
+
+	infos, ok := f.fs.readDir(f.name)
+	if ok {
+		infos = append([]os.FileInfo{}, infos...)
+	} else {
+		var err error
+		if infos, err = f.File.Readdir(count); err != nil {
+			return infos, err
+		}
+		f.fs.storeDir(f.name, infos)
+	}
+	f.listed = true
+	return infos, nil
+}
+
+func (f *File) Readdirnames(count int) ([]string, error) {
+	if count > 0 {
+		return f.File.Readdirnames(count)
+	}
+	infos, err := f.Readdir(count)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	defer f.invalidate()
+	return f.File.Write(p)
+}
+
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	defer f.invalidate()
+	return f.File.WriteAt(p, off)
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	defer f.invalidate()
+	return f.File.WriteString(s)
+}
+
+func (f *File) Truncate(size int64) error {
+	defer f.invalidate()
+	return f.File.Truncate(size)
+}
+
+func (f *File) Close() error {
+	defer f.invalidate()
+	return f.File.Close()
+}
+
+func (f *File) invalidate() {
+	if f.writable {
+		f.fs.store.invalidate(key(f.name), false)
+	}
+}
+
+var (
+	_ govfs.ContextFs     = (*Fs)(nil)
+	_ govfs.DirEnumerator = (*Fs)(nil)
+	_ afero.Symlinker     = (*Fs)(nil)
+)
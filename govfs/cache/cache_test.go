@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+// countingFs counts the metadata requests reaching the wrapped filesystem.
+type countingFs struct {
+	afero.Fs
+	stats, opens atomic.Int32
+}
+
+func (fs *countingFs) Stat(name string) (os.FileInfo, error) {
+	fs.stats.Add(1)
+	return fs.Fs.Stat(name)
+}
+
+func (fs *countingFs) Open(name string) (afero.File, error) {
+	fs.opens.Add(1)
+	return fs.Fs.Open(name)
+}
+
+func newTestCache(t *testing.T, ttl time.Duration) (*countingFs, *Fs) {
+	t.Helper()
+	source := &countingFs{Fs: afero.NewMemMapFs()}
+	for _, name := range []string{"/DCIM/1.jpg", "/DCIM/2.jpg"} {
+		if err := afero.WriteFile(source.Fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source, New(source, Options{TTL: ttl})
+}
+
+func TestCacheStat(t *testing.T) {
+	source, fs := newTestCache(t, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := fs.Stat("/DCIM/1.jpg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := source.stats.Load(); n != 1 {
+		t.Errorf("%d stats reached the source, want 1", n)
+	}
+
+	// a listing caches the stat of the entries
+	if _, err := afero.ReadDir(fs, "/DCIM"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := afero.ReadDir(fs, "/DCIM"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/DCIM/2.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if n := source.stats.Load(); n != 1 {
+		t.Errorf("%d stats reached the source after listing, want 1", n)
+	}
+
+	if _, err := govfs.WithContext(fs, Bypass(context.Background())).Stat("/DCIM/2.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if n := source.stats.Load(); n != 2 {
+		t.Errorf("bypassing the cache: %d stats reached the source, want 2", n)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	_, fs := newTestCache(t, time.Hour)
+	names := func() []string {
+		infos, err := afero.ReadDir(fs, "/DCIM")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		return names
+	}
+	names()
+
+	if err := afero.WriteFile(fs, "/DCIM/3.jpg", []byte("longer content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); len(got) != 3 {
+		t.Errorf("listing after a write: %v", got)
+	}
+	info, err := fs.Stat("/DCIM/3.jpg")
+	if err != nil || info.Size() != int64(len("longer content")) {
+		t.Errorf("stat after a write: %v %v", info, err)
+	}
+
+	if err = fs.Rename("/DCIM/3.jpg", "/DCIM/4.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.Stat("/DCIM/3.jpg"); !os.IsNotExist(err) {
+		t.Errorf("stat of a renamed file: %v", err)
+	}
+
+	if err = fs.RemoveAll("/DCIM"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.Stat("/DCIM/1.jpg"); !os.IsNotExist(err) {
+		t.Errorf("stat below a removed directory: %v", err)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	source, fs := newTestCache(t, time.Millisecond)
+
+	if _, err := fs.Stat("/DCIM/1.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := fs.Stat("/DCIM/1.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if n := source.stats.Load(); n != 2 {
+		t.Errorf("%d stats reached the source, want 2", n)
+	}
+}
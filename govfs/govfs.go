@@ -0,0 +1,25 @@
+// Package govfs holds the optional capabilities a filesystem backend can
+// offer on top of afero.Fs, and helpers that use them when available.
+package govfs
+
+import (
+	"context"
+
+	"github.com/spf13/afero"
+)
+
+// ContextFs is implemented by filesystems whose operations can be bound to
+// a context, so that cancelling it aborts the requests in flight.
+type ContextFs interface {
+	afero.Fs
+	WithContext(ctx context.Context) afero.Fs
+}
+
+// WithContext binds fs to ctx when it supports it and returns fs unchanged
+// otherwise.
+func WithContext(fs afero.Fs, ctx context.Context) afero.Fs { //nolint:revive
+	if cfs, ok := fs.(ContextFs); ok {
+		return cfs.WithContext(ctx)
+	}
+	return fs
+}
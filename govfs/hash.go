@@ -0,0 +1,10 @@
+package govfs
+
+// Hasher is implemented by filesystems that hash files where they are
+// stored, so that the content does not travel to be hashed.
+type Hasher interface {
+	// Hash returns the digest, computed with algo, of the length bytes of
+	// name starting at off. It returns ErrNotSupported when algo is not
+	// available.
+	Hash(name, algo string, off, length int64) ([]byte, error)
+}
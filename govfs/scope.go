@@ -0,0 +1,115 @@
+package govfs
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// ScopedFs restricts a filesystem to the directory base the way
+// afero.BasePathFs does, while keeping the capabilities of the wrapped
+// backend reachable through Source and RealPath.
+type ScopedFs struct {
+	*afero.BasePathFs
+	source afero.Fs
+	base   string
+}
+
+// NewScopedFs returns a view of source rooted at base.
+func NewScopedFs(source afero.Fs, base string) *ScopedFs {
+	return &ScopedFs{
+		BasePathFs: afero.NewBasePathFs(source, base).(*afero.BasePathFs),
+		source:     source,
+		base:       base,
+	}
+}
+
+// Source returns the wrapped filesystem. Paths on it must be translated
+// with RealPath first.
+func (s *ScopedFs) Source() afero.Fs {
+	return s.source
+}
+
+// Base returns the directory the view is rooted at.
+func (s *ScopedFs) Base() string {
+	return s.base
+}
+
+// WithContext binds the wrapped filesystem to ctx.
+func (s *ScopedFs) WithContext(ctx context.Context) afero.Fs {
+	return NewScopedFs(WithContext(s.source, ctx), s.base)
+}
+
+func (s *ScopedFs) RemoveTree(name string) error {
+	remover, ok := s.source.(TreeRemover)
+	if !ok {
+		return ErrNotSupported
+	}
+	realPath, err := s.RealPath(name)
+	if err != nil {
+		return err
+	}
+	return remover.RemoveTree(realPath)
+}
+
+func (s *ScopedFs) TreeSize(name string) (int64, error) {
+	sizer, ok := s.source.(TreeSizer)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	realPath, err := s.RealPath(name)
+	if err != nil {
+		return 0, err
+	}
+	return sizer.TreeSize(realPath)
+}
+
+func (s *ScopedFs) EnumerateDir(name string, fn func(info os.FileInfo) error) error {
+	enumerator, ok := s.source.(DirEnumerator)
+	if !ok {
+		return ErrNotSupported
+	}
+	realPath, err := s.RealPath(name)
+	if err != nil {
+		return err
+	}
+	return enumerator.EnumerateDir(realPath, fn)
+}
+
+func (s *ScopedFs) Usage(name string) (*Usage, error) {
+	provider, ok := s.source.(UsageProvider)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	realPath, err := s.RealPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Usage(realPath)
+}
+
+func (s *ScopedFs) Hash(name, algo string, off, length int64) ([]byte, error) {
+	hasher, ok := s.source.(Hasher)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	realPath, err := s.RealPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return hasher.Hash(realPath, algo, off, length)
+}
+
+func (s *ScopedFs) WriteFileAtomic(name string, r io.Reader, perm os.FileMode) error {
+	writer, ok := s.source.(AtomicWriter)
+	if !ok {
+		return ErrNotSupported
+	}
+	realPath, err := s.RealPath(name)
+	if err != nil {
+		return err
+	}
+	return writer.WriteFileAtomic(realPath, r, perm)
+}
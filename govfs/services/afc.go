@@ -2,16 +2,18 @@ package services
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -114,67 +116,92 @@ const (
 	Afc_Err_DirNotEmpty            = AfcErr(33)
 )
 
-func (errorCode AfcErr) Error() error {
-	switch errorCode {
-	case Afc_Err_Success:
-		return nil
-	case Afc_Err_UnknownError:
-		return errors.New("UnknownError")
-	case Afc_Err_OperationHeaderInvalid:
-		return errors.New("OperationHeaderInvalid")
-	case Afc_Err_NoResources:
-		return errors.New("NoResources")
-	case Afc_Err_ReadError:
-		return errors.New("ReadError")
-	case Afc_Err_WriteError:
-		return errors.New("WriteError")
-	case Afc_Err_UnknownPacketType:
-		return errors.New("UnknownPacketType")
-	case Afc_Err_InvalidArgument:
-		return errors.New("InvalidArgument")
-	case Afc_Err_ObjectNotFound:
-		return errors.New("ObjectNotFound")
-	case Afc_Err_ObjectIsDir:
-		return errors.New("ObjectIsDir")
-	case Afc_Err_PermDenied:
-		return errors.New("PermDenied")
-	case Afc_Err_ServiceNotConnected:
-		return errors.New("ServiceNotConnected")
-	case Afc_Err_OperationTimeout:
-		return errors.New("OperationTimeout")
-	case Afc_Err_TooMuchData:
-		return errors.New("TooMuchData")
-	case Afc_Err_EndOfData:
-		return errors.New("EndOfData")
-	case Afc_Err_OperationNotSupported:
-		return errors.New("OperationNotSupported")
-	case Afc_Err_ObjectExists:
-		return errors.New("ObjectExists")
-	case Afc_Err_ObjectBusy:
-		return errors.New("ObjectBusy")
-	case Afc_Err_NoSpaceLeft:
-		return errors.New("NoSpaceLeft")
-	case Afc_Err_OperationWouldBlock:
-		return errors.New("OperationWouldBlock")
-	case Afc_Err_IoError:
-		return errors.New("IoError")
-	case Afc_Err_OperationInterrupted:
-		return errors.New("OperationInterrupted")
-	case Afc_Err_OperationInProgress:
-		return errors.New("OperationInProgress")
-	case Afc_Err_InternalError:
-		return errors.New("InternalError")
-	case Afc_Err_MuxError:
-		return errors.New("MuxError")
-	case Afc_Err_NoMemory:
-		return errors.New("NoMemory")
-	case Afc_Err_NotEnoughData:
-		return errors.New("NotEnoughData")
-	case Afc_Err_DirNotEmpty:
-		return errors.New("DirNotEmpty")
-	default:
-		return nil
+var afcErrNames = map[AfcErr]string{
+	Afc_Err_Success:                "Success",
+	Afc_Err_UnknownError:           "UnknownError",
+	Afc_Err_OperationHeaderInvalid: "OperationHeaderInvalid",
+	Afc_Err_NoResources:            "NoResources",
+	Afc_Err_ReadError:              "ReadError",
+	Afc_Err_WriteError:             "WriteError",
+	Afc_Err_UnknownPacketType:      "UnknownPacketType",
+	Afc_Err_InvalidArgument:        "InvalidArgument",
+	Afc_Err_ObjectNotFound:         "ObjectNotFound",
+	Afc_Err_ObjectIsDir:            "ObjectIsDir",
+	Afc_Err_PermDenied:             "PermDenied",
+	Afc_Err_ServiceNotConnected:    "ServiceNotConnected",
+	Afc_Err_OperationTimeout:       "OperationTimeout",
+	Afc_Err_TooMuchData:            "TooMuchData",
+	Afc_Err_EndOfData:              "EndOfData",
+	Afc_Err_OperationNotSupported:  "OperationNotSupported",
+	Afc_Err_ObjectExists:           "ObjectExists",
+	Afc_Err_ObjectBusy:             "ObjectBusy",
+	Afc_Err_NoSpaceLeft:            "NoSpaceLeft",
+	Afc_Err_OperationWouldBlock:    "OperationWouldBlock",
+	Afc_Err_IoError:                "IoError",
+	Afc_Err_OperationInterrupted:   "OperationInterrupted",
+	Afc_Err_OperationInProgress:    "OperationInProgress",
+	Afc_Err_InternalError:          "InternalError",
+	Afc_Err_MuxError:               "MuxError",
+	Afc_Err_NoMemory:               "NoMemory",
+	Afc_Err_NotEnoughData:          "NotEnoughData",
+	Afc_Err_DirNotEmpty:            "DirNotEmpty",
+}
+
+// afcErrnos maps the statuses with a POSIX equivalent to it.
+var afcErrnos = map[AfcErr]syscall.Errno{
+	Afc_Err_ReadError:             syscall.EIO,
+	Afc_Err_WriteError:            syscall.EIO,
+	Afc_Err_InvalidArgument:       syscall.EINVAL,
+	Afc_Err_ObjectNotFound:        syscall.ENOENT,
+	Afc_Err_ObjectIsDir:           syscall.EISDIR,
+	Afc_Err_PermDenied:            syscall.EACCES,
+	Afc_Err_OperationTimeout:      syscall.ETIMEDOUT,
+	Afc_Err_OperationNotSupported: syscall.ENOTSUP,
+	Afc_Err_ObjectExists:          syscall.EEXIST,
+	Afc_Err_ObjectBusy:            syscall.EBUSY,
+	Afc_Err_NoSpaceLeft:           syscall.ENOSPC,
+	Afc_Err_OperationWouldBlock:   syscall.EAGAIN,
+	Afc_Err_IoError:               syscall.EIO,
+	Afc_Err_OperationInterrupted:  syscall.EINTR,
+	Afc_Err_OperationInProgress:   syscall.EINPROGRESS,
+	Afc_Err_NoMemory:              syscall.ENOMEM,
+	Afc_Err_DirNotEmpty:           syscall.ENOTEMPTY,
+}
+
+// Error returns the name of the status.
+func (errorCode AfcErr) Error() string {
+	if name, ok := afcErrNames[errorCode]; ok {
+		return name
 	}
+	return fmt.Sprintf("AfcErr(%d)", uint64(errorCode))
+}
+
+// Errno returns the POSIX equivalent of the status, or 0 when there is none.
+func (errorCode AfcErr) Errno() syscall.Errno {
+	return afcErrnos[errorCode]
+}
+
+// Is reports whether the status matches target, one of the fs errors such as
+// fs.ErrNotExist or a syscall.Errno such as syscall.ENOSPC.
+func (errorCode AfcErr) Is(target error) bool {
+	errno := errorCode.Errno()
+	if errno == 0 {
+		return false
+	}
+	if targetErrno, ok := target.(syscall.Errno); ok {
+		return errno == targetErrno
+	}
+	return errno.Is(target)
+}
+
+// pathError attaches the operation and the path of a request to the status
+// the device answered, as the os package does for system calls.
+func pathError(op, path string, err error) error {
+	var code AfcErr
+	if !errors.As(err, &code) {
+		return err
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
 }
 
 type AfcPacketHeader struct {
@@ -216,7 +243,10 @@ func (p *AfcPacket) PackTo(writer io.Writer) error {
 func (p *AfcPacket) Error() error {
 	if p.Header.Operation == Afc_operation_status {
 		errorCode := AfcErr(binary.LittleEndian.Uint64(p.HeaderPayload))
-		return errorCode.Error()
+		if errorCode == Afc_Err_Success {
+			return nil
+		}
+		return errorCode
 	}
 	return nil
 }
@@ -267,8 +297,11 @@ func (s *StatInfo) Size() int64 {
 }
 
 func (s *StatInfo) Mode() os.FileMode {
-	if s.stIfmt == "S_IFDIR" {
+	switch s.stIfmt {
+	case "S_IFDIR":
 		return os.ModeDir
+	case "S_IFLNK":
+		return os.ModeSymlink
 	}
 	return 0
 }
@@ -293,6 +326,12 @@ func (s *StatInfo) IsLink() bool {
 	return s.stIfmt == "S_IFLNK"
 }
 
+// LinkTarget returns the target of a symbolic link as stored on the device,
+// which may be relative to the directory of the link.
+func (s *StatInfo) LinkTarget() string {
+	return s.stLinktarget
+}
+
 func (s *StatInfo) SetName(name string) *StatInfo {
 	s.name = name
 	return s
@@ -318,92 +357,172 @@ func NewDirStatInfo(name string) *StatInfo {
 }
 
 type AfcService struct {
-	Conn          net.Conn
-	addr          string
-	packageNumber uint64
-	mutex         sync.Mutex
+	addr  string
+	opts  Options
+	conns []*afcConn
+	next  uint32
+
+	files      map[uint64]*fileRef
+	nextHandle uint64
+	filesMutex sync.Mutex
+
+	// unsupportedOps holds the operations the device rejected as unknown
+	unsupportedOps sync.Map
+}
+
+// Options tunes an AfcService.
+type Options struct {
+	// PoolSize is the number of connections dialed to addr. Zero means
+	// DefaultPoolSize.
+	PoolSize int
+	// ReconnectAttempts is how many times a broken connection is redialed
+	// before a request fails. Zero means DefaultReconnectAttempts.
+	ReconnectAttempts int
+	// ReconnectBackoff is the wait before the second redial attempt; it
+	// doubles on every further attempt. Zero means DefaultReconnectBackoff.
+	ReconnectBackoff time.Duration
+	// OpTimeout bounds a single request/reply round-trip on top of any
+	// deadline of the request context. Zero means DefaultOpTimeout.
+	OpTimeout time.Duration
+	// BlockSize is the largest chunk moved by a single read or write
+	// request, and the read-ahead of files. It is negotiated with the device
+	// on every connection. Zero means DefaultBlockSize.
+	BlockSize int
+	// Dialer opens the connections. Nil means a net.Dialer.
+	Dialer Dialer
+	// TLSConfig configures the connections to tls:// addresses. Nil means
+	// the system roots are trusted and no client certificate is sent.
+	TLSConfig *tls.Config
+	// Lazy defers dialing every connection of the pool to the first request
+	// that needs it, so that a service can be created while its server is
+	// unreachable. Requests fail with a ConnectionError until it is back.
+	Lazy bool
 }
 
+const (
+	// DefaultPoolSize is the number of connections used when
+	// Options.PoolSize is not set.
+	DefaultPoolSize = 4
+
+	DefaultReconnectAttempts = 5
+	DefaultReconnectBackoff  = 200 * time.Millisecond
+	DefaultOpTimeout         = time.Minute
+	DefaultBlockSize         = 1 << 20
+
+	// minBlockSize is used on connections whose device refused BlockSize
+	minBlockSize = 64 << 10
+
+	maxReconnectBackoff = 5 * time.Second
+	healthTimeout       = 5 * time.Second
+)
+
 func NewAfcService(addr string) (*AfcService, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	s := &AfcService{
-		addr: addr,
-		Conn: conn,
-	}
-	return s, err
+	return NewAfcServiceWithOptions(addr, Options{})
 }
 
-func (conn *AfcService) request(ops uint64, data, payload []byte) (*AfcPacket, error) {
-	header := AfcPacketHeader{
-		Magic:         Afc_magic,
-		Packet_num:    conn.packageNumber,
-		Operation:     ops,
-		This_length:   Afc_header_size + uint64(len(data)),
-		Entire_length: Afc_header_size + uint64(len(data)+len(payload)),
+func NewAfcServiceWithOptions(addr string, opts Options) (*AfcService, error) {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = DefaultPoolSize
+	}
+	if opts.ReconnectAttempts <= 0 {
+		opts.ReconnectAttempts = DefaultReconnectAttempts
+	}
+	if opts.ReconnectBackoff <= 0 {
+		opts.ReconnectBackoff = DefaultReconnectBackoff
+	}
+	if opts.OpTimeout <= 0 {
+		opts.OpTimeout = DefaultOpTimeout
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = DefaultBlockSize
 	}
 
-	packet := AfcPacket{
-		Header:        header,
-		HeaderPayload: data,
-		Payload:       payload,
+	s := &AfcService{
+		addr:  addr,
+		opts:  opts,
+		files: make(map[uint64]*fileRef),
+	}
+	for i := 0; i < opts.PoolSize; i++ {
+		if opts.Lazy {
+			// a broken connection is redialed by its first request
+			s.conns = append(s.conns, &afcConn{addr: addr, opts: &s.opts, sem: make(chan struct{}, 1), broken: true})
+			continue
+		}
+		c, err := dialAfcConn(addr, &s.opts)
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+		s.conns = append(s.conns, c)
 	}
+	return s, nil
+}
 
-	conn.packageNumber++
-	// 这里有两种写法
-	_, err := conn.Conn.Write(packet.Pack())
-	// err := packet.PackTo(conn.Conn)
+// request runs a single request on an idle connection of the pool. Read-only
+// requests that hit a dead connection are retried once after redialing.
+func (conn *AfcService) request(ctx context.Context, ops uint64, data, payload []byte) (*AfcPacket, error) {
+	c, err := conn.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
-	response, err := UnpackAfcPacket(conn.Conn)
-	if err != nil {
-		return nil, err
+	defer c.unlock()
+	wasBroken := c.broken
+	response, err := c.request(ctx, ops, data, payload)
+	var connErr *ConnectionError
+	if !wasBroken && ctx.Err() == nil && errors.As(err, &connErr) && idempotentOps[ops] {
+		response, err = c.request(ctx, ops, data, payload)
 	}
-
-	err = response.Error()
-	return &response, err
+	return response, err
 }
 
 func (conn *AfcService) RemovePath(path string) error {
+	return conn.RemovePathContext(context.Background(), path)
+}
+
+func (conn *AfcService) RemovePathContext(ctx context.Context, path string) error {
 	log.Debugf("Remove path %v", path)
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_remove_path, []byte(path), nil)
-	return err
+	_, err := conn.request(ctx, Afc_operation_remove_path, []byte(path), nil)
+	return pathError("remove", path, err)
 }
 
 func (conn *AfcService) RenamePath(from, to string) error {
+	return conn.RenamePathContext(context.Background(), from, to)
+}
+
+func (conn *AfcService) RenamePathContext(ctx context.Context, from, to string) error {
 	data := make([]byte, len(from)+1+len(to)+1)
 	copy(data, from)
 	copy(data[len(from)+1:], to)
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_rename_path, data, nil)
+	_, err := conn.request(ctx, Afc_operation_rename_path, data, nil)
+	var code AfcErr
+	if errors.As(err, &code) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
 	return err
 }
 
 func (conn *AfcService) MakeDir(path string) error {
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_make_dir, []byte(path), nil)
-	return err
+	return conn.MakeDirContext(context.Background(), path)
+}
+
+func (conn *AfcService) MakeDirContext(ctx context.Context, path string) error {
+	_, err := conn.request(ctx, Afc_operation_make_dir, []byte(path), nil)
+	return pathError("mkdir", path, err)
 }
 
 func (conn *AfcService) Stat(path string) (*StatInfo, error) {
-	conn.mutex.Lock()
-	response, err := conn.request(Afc_operation_file_info, []byte(path), nil)
+	return conn.StatContext(context.Background(), path)
+}
+
+func (conn *AfcService) StatContext(ctx context.Context, path string) (*StatInfo, error) {
+	response, err := conn.request(ctx, Afc_operation_file_info, []byte(path), nil)
 	if err != nil {
-		conn.mutex.Unlock()
-		return nil, fmt.Errorf("cannot stat '%v': %v", path, err)
+		return nil, pathError("stat", path, err)
 	}
-	conn.mutex.Unlock()
 
 	ret := bytes.Split(bytes.TrimSuffix(response.Payload, []byte{0}), []byte{0})
 	if len(ret)%2 != 0 {
-		log.Fatalf("invalid response: %v %% 2 != 0", len(ret))
+		return nil, fmt.Errorf("cannot stat '%v': invalid response with %d fields", path, len(ret))
 	}
 
 	statInfoMap := make(map[string]string)
@@ -412,9 +531,14 @@ func (conn *AfcService) Stat(path string) (*StatInfo, error) {
 		v := string(ret[i+1])
 		statInfoMap[k] = v
 	}
+	return newStatInfo(filepath.Base(path), statInfoMap), nil
+}
 
+// newStatInfo builds a StatInfo from the key/value pairs the device
+// returns for an entry.
+func newStatInfo(name string, statInfoMap map[string]string) *StatInfo {
 	var si StatInfo
-	si.name = filepath.Base(path)
+	si.name = name
 	si.stSize, _ = strconv.ParseInt(statInfoMap["st_size"], 10, 64)
 	si.stBlocks, _ = strconv.ParseInt(statInfoMap["st_blocks"], 10, 64)
 	si.stCtime, _ = strconv.ParseInt(statInfoMap["st_birthtime"], 10, 64)
@@ -422,19 +546,55 @@ func (conn *AfcService) Stat(path string) (*StatInfo, error) {
 	si.stNlink = statInfoMap["st_nlink"]
 	si.stIfmt = statInfoMap["st_ifmt"]
 	si.stLinktarget = statInfoMap["st_linktarget"]
-	return &si, nil
+	return &si
+}
+
+// DeviceInfo is the storage information returned by GetDeviceInfo.
+type DeviceInfo struct {
+	Model      string
+	TotalBytes uint64
+	FreeBytes  uint64
+	BlockSize  uint64
+}
+
+func (conn *AfcService) GetDeviceInfo() (*DeviceInfo, error) {
+	return conn.GetDeviceInfoContext(context.Background())
+}
+
+func (conn *AfcService) GetDeviceInfoContext(ctx context.Context) (*DeviceInfo, error) {
+	response, err := conn.request(ctx, Afc_operation_get_devinfo, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get device info: %w", err)
+	}
+
+	ret := bytes.Split(bytes.TrimSuffix(response.Payload, []byte{0}), []byte{0})
+	if len(ret)%2 != 0 {
+		return nil, fmt.Errorf("cannot get device info: invalid response with %d fields", len(ret))
+	}
+	infoMap := make(map[string]string)
+	for i := 0; i < len(ret); i = i + 2 {
+		infoMap[string(ret[i])] = string(ret[i+1])
+	}
+
+	var info DeviceInfo
+	info.Model = infoMap["Model"]
+	info.TotalBytes, _ = strconv.ParseUint(infoMap["FSTotalBytes"], 10, 64)
+	info.FreeBytes, _ = strconv.ParseUint(infoMap["FSFreeBytes"], 10, 64)
+	info.BlockSize, _ = strconv.ParseUint(infoMap["FSBlockSize"], 10, 64)
+	return &info, nil
 }
 
 func (conn *AfcService) ReadDir(path string) ([]string, error) {
+	return conn.ReadDirContext(context.Background(), path)
+}
+
+func (conn *AfcService) ReadDirContext(ctx context.Context, path string) ([]string, error) {
 	//log.Debugf("ReadDir path:%v", path)
-	conn.mutex.Lock()
-	response, err := conn.request(Afc_operation_read_dir, []byte(path), nil)
+	response, err := conn.request(ctx, Afc_operation_read_dir, []byte(path), nil)
 	if err != nil {
-		conn.mutex.Unlock()
 		log.Infof("ReadDir error:%v", err)
-		return nil, err
+		return nil, pathError("readdir", path, err)
 	}
-	conn.mutex.Unlock()
 
 	ret := bytes.Split(bytes.TrimSuffix(response.Payload, []byte{0}), []byte{0})
 	var fileList []string
@@ -448,106 +608,158 @@ func (conn *AfcService) ReadDir(path string) ([]string, error) {
 	return fileList, nil
 }
 
+// OpenFile opens path on one of the pooled connections and returns a handle
+// pinned to it. Every later operation on the handle runs on that connection.
 func (conn *AfcService) OpenFile(path string, mode uint64) (uint64, error) {
+	return conn.OpenFileContext(context.Background(), path, mode)
+}
+
+func (conn *AfcService) OpenFileContext(ctx context.Context, path string, mode uint64) (uint64, error) {
 	log.Debugf("OpenFile path:%v", path)
 	data := make([]byte, 8+len(path)+1)
 	binary.LittleEndian.PutUint64(data, mode)
 	copy(data[8:], path)
-	conn.mutex.Lock()
-	response, err := conn.request(Afc_operation_file_open, data, make([]byte, 0))
+
+	c, err := conn.acquire(ctx)
 	if err != nil {
-		conn.mutex.Unlock()
-		log.Errorf("OpenFile path:%v err:%v", path, err)
 		return 0, err
 	}
-	conn.mutex.Unlock()
+	response, err := c.request(ctx, Afc_operation_file_open, data, make([]byte, 0))
+	c.unlock()
+	if err != nil {
+		log.Errorf("OpenFile path:%v err:%v", path, err)
+		return 0, pathError("open", path, err)
+	}
 
 	fd := binary.LittleEndian.Uint64(response.HeaderPayload)
 	if fd == 0 {
 		return 0, fmt.Errorf("file descriptor should not be zero")
 	}
 
-	return fd, nil
+	return conn.pin(c, fd), nil
 }
 
 func (conn *AfcService) ReadFile(fd uint64, p []byte) (n int, err error) {
+	return conn.ReadFileContext(context.Background(), fd, p)
+}
+
+// ReadFileContext reads at most one block into p. It only returns io.EOF
+// once the device has no more data, a short read is not the end of the file.
+func (conn *AfcService) ReadFileContext(ctx context.Context, fd uint64, p []byte) (n int, err error) {
 	log.Debugf("ReadFile inbuf pd:%v, read len:%v", fd, len(p))
-	defer log.Info("ReadFile end")
+	c, dfd, err := conn.lockFile(ctx, fd)
+	if err != nil {
+		return 0, err
+	}
+	size := len(p)
+	if size > c.blockSize {
+		size = c.blockSize
+	}
 	data := make([]byte, 16)
-	binary.LittleEndian.PutUint64(data, fd)
-	binary.LittleEndian.PutUint64(data[8:], uint64(len(p)))
+	binary.LittleEndian.PutUint64(data, dfd)
+	binary.LittleEndian.PutUint64(data[8:], uint64(size))
 
-	conn.mutex.Lock()
-	response, err := conn.request(Afc_operation_file_read, data, nil)
+	response, err := c.request(ctx, Afc_operation_file_read, data, nil)
+	c.unlock()
 	if err != nil {
-		conn.mutex.Unlock()
 		return 0, err
 	}
-	conn.mutex.Unlock()
 
-	log.Debugf("inbuf len:%v, read len:%v", len(p), len(response.Payload))
 	n = len(response.Payload)
-	if n > len(p) {
-		log.Fatalf("inbuf len:%v, read len:%v", len(p), len(response.Payload))
+	if n > size {
+		return 0, fmt.Errorf("afc: device returned %d bytes for a %d byte read", n, size)
 	}
 	if n == 0 {
-		return n, io.EOF
-	}
-
-	if n < len(p) {
-		err = io.EOF
+		return 0, io.EOF
 	}
-	copy(p, response.Payload)
-	return
+	return copy(p, response.Payload), nil
 }
 
 func (conn *AfcService) WriteFile(fd uint64, p []byte) (n int, err error) {
+	return conn.WriteFileContext(context.Background(), fd, p)
+}
+
+// WriteFileContext writes p in chunks of at most one block.
+func (conn *AfcService) WriteFileContext(ctx context.Context, fd uint64, p []byte) (n int, err error) {
+	c, dfd, err := conn.lockFile(ctx, fd)
+	if err != nil {
+		return 0, err
+	}
+	defer c.unlock()
 	data := make([]byte, 8)
-	binary.LittleEndian.PutUint64(data, fd)
+	binary.LittleEndian.PutUint64(data, dfd)
 
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err = conn.request(Afc_operation_file_write, data, p)
-	return len(p), err
+	for n < len(p) {
+		end := n + c.blockSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if _, err = c.request(ctx, Afc_operation_file_write, data, p[n:end]); err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return n, nil
 }
 
 func (conn *AfcService) CloseFile(fd uint64) error {
+	return conn.CloseFileContext(context.Background(), fd)
+}
+
+func (conn *AfcService) CloseFileContext(ctx context.Context, fd uint64) error {
+	c, dfd, err := conn.lockFile(ctx, fd)
+	if err == ErrStaleHandle {
+		// the device already dropped the descriptor with the connection
+		conn.unpin(fd)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
+	defer conn.unpin(fd)
 	data := make([]byte, 8)
-	binary.LittleEndian.PutUint64(data, fd)
+	binary.LittleEndian.PutUint64(data, dfd)
 
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_file_close, data, nil)
+	_, err = c.request(ctx, Afc_operation_file_close, data, nil)
 	return err
 }
 
 func (conn *AfcService) LockFile(fd uint64) error {
+	return conn.LockFileContext(context.Background(), fd)
+}
+
+func (conn *AfcService) LockFileContext(ctx context.Context, fd uint64) error {
+	c, dfd, err := conn.lockFile(ctx, fd)
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
 	data := make([]byte, 8)
-	binary.LittleEndian.PutUint64(data, fd)
+	binary.LittleEndian.PutUint64(data, dfd)
 
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_file_close, data, nil)
+	_, err = c.request(ctx, Afc_operation_file_close, data, nil)
 	return err
 }
 
 // SeekFile whence is SEEK_SET, SEEK_CUR, or SEEK_END.
 func (conn *AfcService) SeekFile(fd uint64, offset int64, whence int) (int64, error) {
-	data := make([]byte, 24)
-	binary.LittleEndian.PutUint64(data, fd)
-	binary.LittleEndian.PutUint64(data[8:], uint64(whence))
-	binary.LittleEndian.PutUint64(data[16:], uint64(offset))
-
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_file_seek, data, nil)
+	return conn.SeekFileContext(context.Background(), fd, offset, whence)
+}
+
+func (conn *AfcService) SeekFileContext(ctx context.Context, fd uint64, offset int64, whence int) (int64, error) {
+	c, dfd, err := conn.lockFile(ctx, fd)
 	if err != nil {
 		return 0, err
 	}
+	defer c.unlock()
+	if err = c.seek(ctx, dfd, offset, whence); err != nil {
+		return 0, err
+	}
 
 	data2 := make([]byte, 8)
-	binary.LittleEndian.PutUint64(data2, fd)
-	response, err := conn.request(Afc_operation_file_tell, data2, nil)
+	binary.LittleEndian.PutUint64(data2, dfd)
+	response, err := c.request(ctx, Afc_operation_file_tell, data2, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -557,12 +769,19 @@ func (conn *AfcService) SeekFile(fd uint64, offset int64, whence int) (int64, er
 }
 
 func (conn *AfcService) TellFile(fd uint64) (uint64, error) {
+	return conn.TellFileContext(context.Background(), fd)
+}
+
+func (conn *AfcService) TellFileContext(ctx context.Context, fd uint64) (uint64, error) {
+	c, dfd, err := conn.lockFile(ctx, fd)
+	if err != nil {
+		return 0, err
+	}
+	defer c.unlock()
 	data := make([]byte, 8)
-	binary.LittleEndian.PutUint64(data, fd)
+	binary.LittleEndian.PutUint64(data, dfd)
 
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	response, err := conn.request(Afc_operation_file_tell, data, nil)
+	response, err := c.request(ctx, Afc_operation_file_tell, data, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -572,57 +791,89 @@ func (conn *AfcService) TellFile(fd uint64) (uint64, error) {
 }
 
 func (conn *AfcService) TruncateFile(fd uint64, size int64) error {
+	return conn.TruncateFileContext(context.Background(), fd, size)
+}
+
+func (conn *AfcService) TruncateFileContext(ctx context.Context, fd uint64, size int64) error {
+	c, dfd, err := conn.lockFile(ctx, fd)
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
 	data := make([]byte, 16)
-	binary.LittleEndian.PutUint64(data, fd)
+	binary.LittleEndian.PutUint64(data, dfd)
 	binary.LittleEndian.PutUint64(data[8:], uint64(size))
 
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_file_set_size, data, nil)
+	_, err = c.request(ctx, Afc_operation_file_set_size, data, nil)
 	return err
 }
 
 func (conn *AfcService) Truncate(path string, size uint64) error {
+	return conn.TruncateContext(context.Background(), path, size)
+}
+
+func (conn *AfcService) TruncateContext(ctx context.Context, path string, size uint64) error {
 	data := make([]byte, 8+len(path))
 	binary.LittleEndian.PutUint64(data, size)
 	copy(data[8:], path)
 
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_TRUNCATE, data, nil)
-	return err
+	_, err := conn.request(ctx, Afc_operation_TRUNCATE, data, nil)
+	return pathError("truncate", path, err)
 }
 
 func (conn *AfcService) MakeLink(link LinkType, target, linkname string) error {
+	return conn.MakeLinkContext(context.Background(), link, target, linkname)
+}
+
+func (conn *AfcService) MakeLinkContext(ctx context.Context, link LinkType, target, linkname string) error {
 	data := make([]byte, 8+len(target)+1+len(linkname)+1)
 	binary.LittleEndian.PutUint64(data, uint64(link))
 	copy(data[8:], target)
 	copy(data[8+len(target)+1:], linkname)
 
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_make_link, data, nil)
+	_, err := conn.request(ctx, Afc_operation_make_link, data, nil)
 	return err
 }
 
 func (conn *AfcService) SetFileTime(path string, t time.Time) error {
+	return conn.SetFileTimeContext(context.Background(), path, t)
+}
+
+func (conn *AfcService) SetFileTimeContext(ctx context.Context, path string, t time.Time) error {
 	data := make([]byte, 8+len(path)+1)
 	binary.LittleEndian.PutUint64(data, uint64(t.UnixNano()))
 	copy(data[8:], path)
 
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_set_file_time, data, nil)
-	return err
+	_, err := conn.request(ctx, Afc_operation_set_file_time, data, nil)
+	return pathError("chtimes", path, err)
 }
 
 func (conn *AfcService) RemovePathAndContents(path string) error {
-	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
-	_, err := conn.request(Afc_operation_remove_path, []byte(path), nil)
-	return err
+	return conn.RemovePathAndContentsContext(context.Background(), path)
+}
+
+// RemovePathAndContentsContext removes path and everything below it in a
+// single request. It returns an error wrapping ErrNotSupported on devices
+// without AFC_OP_REMOVE_PATH_AND_CONTENTS.
+func (conn *AfcService) RemovePathAndContentsContext(ctx context.Context, path string) error {
+	_, err := conn.optionalRequest(ctx, AFC_OP_REMOVE_PATH_AND_CONTENTS, []byte(path), nil)
+	return pathError("removeall", path, err)
 }
 
+// Close closes the connections of the pool, once their running request is
+// over. The requests made afterwards fail with ErrServiceClosed.
 func (conn *AfcService) Close() error {
-	return conn.Conn.Close()
+	var err error
+	for _, c := range conn.conns {
+		_ = c.lock(context.Background())
+		c.closed = true
+		if !c.broken {
+			c.broken = true
+			if cerr := c.Conn.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		c.unlock()
+	}
+	return err
 }
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+func (conn *AfcService) WriteFileAtomic(path string, data []byte) error {
+	return conn.WriteFileAtomicContext(context.Background(), path, data)
+}
+
+// WriteFileAtomicContext replaces the content of path with data in a single
+// request; the device writes a temporary file and renames it. It returns an
+// error wrapping govfs.ErrNotSupported on devices without
+// Afc_operation_write_file_atom.
+func (conn *AfcService) WriteFileAtomicContext(ctx context.Context, path string, data []byte) error {
+	_, err := conn.optionalRequest(ctx, Afc_operation_write_file_atom, append([]byte(path), 0), data)
+	return pathError("write", path, err)
+}
+
+// WriteFileAtomic sends contents of up to a block with
+// Afc_operation_write_file_atom. Larger contents, and every content on
+// devices without it, go through govfs.ReplaceFile.
+func (fs *Fsync) WriteFileAtomic(name string, r io.Reader, perm os.FileMode) error {
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, r, int64(fs.opts.BlockSize)+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n <= int64(fs.opts.BlockSize) && fs.AfcService.supports(Afc_operation_write_file_atom) {
+		err = fs.AfcService.WriteFileAtomicContext(fs.context(), name, buf.Bytes())
+		if !errors.Is(err, govfs.ErrNotSupported) {
+			return err
+		}
+	}
+	return govfs.ReplaceFile(fs, name, io.MultiReader(buf, r), perm)
+}
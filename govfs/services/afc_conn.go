@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrStaleHandle is returned for operations on a file handle whose
+// connection broke after the file was opened. The device dropped the
+// descriptor together with the connection, so the file must be reopened.
+var ErrStaleHandle = errors.New("afc: stale file handle")
+
+// ErrServiceClosed is returned for the requests made on an AfcService once
+// it is closed.
+var ErrServiceClosed = errors.New("afc: service closed")
+
+// ConnectionError reports a failure of the transport to the AFC server, as
+// opposed to an error status returned by the device.
+type ConnectionError struct {
+	Addr string
+	Err  error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("afc connection to %s: %v", e.Addr, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// afcConn is a single AFC connection. Requests on it are strictly
+// serialized: one packet is written and its reply read while holding the
+// connection lock. The lock is a channel so that waiting for it can be
+// abandoned when the request context is done.
+//
+// When the transport fails the connection is closed and marked broken; the
+// next request redials addr with backoff. generation is bumped on every
+// successful redial so handles opened before can be recognized as stale.
+type afcConn struct {
+	net.Conn
+	addr          string
+	opts          *Options
+	packageNumber uint64
+	generation    uint64
+	broken        bool
+	// closed is set when the service is closed, so that the connection is
+	// never redialed
+	closed bool
+	sem    chan struct{}
+	// blockSize is the transfer size the device accepted
+	blockSize int
+}
+
+func dialAfcConn(addr string, opts *Options) (*afcConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.OpTimeout)
+	defer cancel()
+	conn, err := opts.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &afcConn{Conn: conn, addr: addr, opts: opts, sem: make(chan struct{}, 1)}
+	if err = c.configure(context.Background()); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// configure asks the device to use Options.BlockSize for its file system
+// and socket buffers. Devices that refuse keep their defaults, and requests
+// on the connection are then limited to minBlockSize. The caller must hold
+// the connection lock.
+func (c *afcConn) configure(ctx context.Context) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(c.opts.BlockSize))
+
+	c.blockSize = c.opts.BlockSize
+	for _, op := range []uint64{Afc_operation_set_fs_bs, Afc_operation_set_socket_bs} {
+		_, err := c.request(ctx, op, data, nil)
+		var connErr *ConnectionError
+		if errors.As(err, &connErr) {
+			return err
+		}
+		if err != nil && c.blockSize > minBlockSize {
+			log.Debugf("afc connection to %v keeps the default block size: %v", c.addr, err)
+			c.blockSize = minBlockSize
+		}
+	}
+	return nil
+}
+
+func (c *afcConn) lock(ctx context.Context) error {
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *afcConn) tryLock() bool {
+	select {
+	case c.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *afcConn) unlock() {
+	<-c.sem
+}
+
+// fail closes the underlying connection after a transport error. The caller
+// must hold the connection lock.
+func (c *afcConn) fail(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	if !c.broken {
+		log.Warnf("afc connection to %v broken: %v", c.addr, err)
+		_ = c.Conn.Close()
+		c.broken = true
+	}
+	return &ConnectionError{Addr: c.addr, Err: err}
+}
+
+// reconnect redials addr, waiting between attempts with an exponential
+// backoff. The caller must hold the connection lock.
+func (c *afcConn) reconnect(ctx context.Context) error {
+	backoff := c.opts.ReconnectBackoff
+	var err error
+	for attempt := 0; attempt < c.opts.ReconnectAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return &ConnectionError{Addr: c.addr, Err: ctx.Err()}
+			}
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+		}
+
+		var conn net.Conn
+		conn, err = c.opts.dial(ctx, c.addr)
+		if err == nil {
+			log.Infof("afc connection to %v restored", c.addr)
+			c.Conn = conn
+			c.packageNumber = 0
+			c.generation++
+			c.broken = false
+			return c.configure(ctx)
+		}
+	}
+	return &ConnectionError{Addr: c.addr, Err: err}
+}
+
+// request sends one packet and waits for the reply. The caller must hold
+// the connection lock.
+//
+// The round-trip is bounded by Options.OpTimeout and by the deadline of ctx;
+// cancelling ctx aborts it. An aborted round-trip leaves the stream in an
+// unknown state, so the connection is dropped and redialed on next use.
+func (c *afcConn) request(ctx context.Context, ops uint64, data, payload []byte) (*AfcPacket, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.closed {
+		return nil, ErrServiceClosed
+	}
+	if c.broken {
+		if err := c.reconnect(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(c.opts.OpTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = c.Conn.SetDeadline(deadline)
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func(conn net.Conn) {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			// unblock the pending read or write right away
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}(c.Conn)
+	defer func(conn net.Conn) {
+		close(done)
+		<-exited
+		_ = conn.SetDeadline(time.Time{})
+	}(c.Conn)
+
+	header := AfcPacketHeader{
+		Magic:         Afc_magic,
+		Packet_num:    c.packageNumber,
+		Operation:     ops,
+		This_length:   Afc_header_size + uint64(len(data)),
+		Entire_length: Afc_header_size + uint64(len(data)+len(payload)),
+	}
+
+	packet := AfcPacket{
+		Header:        header,
+		HeaderPayload: data,
+		Payload:       payload,
+	}
+
+	c.packageNumber++
+	// 这里有两种写法
+	_, err := c.Conn.Write(packet.Pack())
+	// err := packet.PackTo(c.Conn)
+	if err != nil {
+		return nil, c.fail(ctx, err)
+	}
+	response, err := UnpackAfcPacket(c.Conn)
+	if err != nil {
+		return nil, c.fail(ctx, err)
+	}
+
+	err = response.Error()
+	return &response, err
+}
+
+// fileRef ties a handle given out by AfcService.OpenFile to the connection
+// the file was opened on and the descriptor the device returned.
+type fileRef struct {
+	conn       *afcConn
+	fd         uint64
+	generation uint64
+}
+
+// acquire locks and returns an idle connection of the pool, or waits for one
+// if all of them are busy. The caller must unlock it.
+func (conn *AfcService) acquire(ctx context.Context) (*afcConn, error) {
+	n := uint32(len(conn.conns))
+	start := atomic.AddUint32(&conn.next, 1)
+	for i := uint32(0); i < n; i++ {
+		c := conn.conns[(start+i)%n]
+		if c.tryLock() {
+			return c, nil
+		}
+	}
+
+	c := conn.conns[start%n]
+	if err := c.lock(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// pin registers fd, opened on c, and returns the handle callers use for it.
+// The caller must hold the lock of c.
+func (conn *AfcService) pin(c *afcConn, fd uint64) uint64 {
+	conn.filesMutex.Lock()
+	defer conn.filesMutex.Unlock()
+	conn.nextHandle++
+	conn.files[conn.nextHandle] = &fileRef{conn: c, fd: fd, generation: c.generation}
+	return conn.nextHandle
+}
+
+func (conn *AfcService) unpin(handle uint64) {
+	conn.filesMutex.Lock()
+	defer conn.filesMutex.Unlock()
+	delete(conn.files, handle)
+}
+
+// lockFile locks the connection handle is pinned to and returns it together
+// with the device file descriptor. The caller must unlock the connection.
+func (conn *AfcService) lockFile(ctx context.Context, handle uint64) (*afcConn, uint64, error) {
+	conn.filesMutex.Lock()
+	ref, ok := conn.files[handle]
+	conn.filesMutex.Unlock()
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid file handle %d", handle)
+	}
+
+	if err := ref.conn.lock(ctx); err != nil {
+		return nil, 0, err
+	}
+	if ref.conn.closed {
+		ref.conn.unlock()
+		return nil, 0, ErrServiceClosed
+	}
+	if ref.conn.broken || ref.conn.generation != ref.generation {
+		ref.conn.unlock()
+		return nil, 0, ErrStaleHandle
+	}
+	return ref.conn, ref.fd, nil
+}
+
+// idempotentOps may safely be sent again after the connection was
+// re-established.
+var idempotentOps = map[uint64]bool{
+	Afc_operation_read_dir:    true,
+	Afc_operation_file_info:   true,
+	Afc_operation_get_devinfo: true,
+}
+
+// Health checks that the AFC server can be reached, redialing broken
+// connections. Errors reported by the device itself do not count.
+func (conn *AfcService) Health() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+	_, err := conn.request(ctx, Afc_operation_file_info, []byte("/"), nil)
+	var connErr *ConnectionError
+	if errors.As(err, &connErr) || errors.Is(err, ErrServiceClosed) {
+		return err
+	}
+	return nil
+}
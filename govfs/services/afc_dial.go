@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Dialer opens the transport to an AFC server; *net.Dialer is one. It is
+// given the network and address ParseAddr returns, and TLS is layered on
+// top of the connection it returns for tls:// addresses.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialerFunc adapts a function to a Dialer. Tests can return one end of a
+// net.Pipe from it.
+type DialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f DialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// ParseAddr returns the network and address to dial for the address of an
+// AFC server, and whether the connection uses TLS. The address is either
+// host:port, tcp://host:port, tls://host:port or unix:///path/to/socket.
+func ParseAddr(addr string) (network, address string, useTLS bool, err error) {
+	if !strings.Contains(addr, "://") {
+		return "tcp", addr, false, nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", false, err
+	}
+	switch u.Scheme {
+	case "tcp", "tls":
+		if u.Host == "" || u.Path != "" {
+			return "", "", false, fmt.Errorf("%s: expected %s://host:port", addr, u.Scheme)
+		}
+		return "tcp", u.Host, u.Scheme == "tls", nil
+	case "unix":
+		if u.Host+u.Path == "" {
+			return "", "", false, fmt.Errorf("%s: expected unix:///path/to/socket", addr)
+		}
+		return "unix", u.Host + u.Path, false, nil
+	default:
+		return "", "", false, fmt.Errorf("%s: unknown scheme %q", addr, u.Scheme)
+	}
+}
+
+// dial opens a connection to addr with Options.Dialer, or a net.Dialer when
+// it is not set.
+func (o *Options) dial(ctx context.Context, addr string) (net.Conn, error) {
+	network, address, useTLS, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := o.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil || !useTLS {
+		return conn, err
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.TLSConfig != nil {
+		config = o.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,156 @@
+package services_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/govfs/services/afctest"
+)
+
+func TestParseAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr, network, address string
+		useTLS, fails          bool
+	}{
+		{addr: "127.0.0.1:5001", network: "tcp", address: "127.0.0.1:5001"},
+		{addr: "tcp://relay:5001", network: "tcp", address: "relay:5001"},
+		{addr: "tls://relay:5001", network: "tcp", address: "relay:5001", useTLS: true},
+		{addr: "unix:///var/run/afc.sock", network: "unix", address: "/var/run/afc.sock"},
+		{addr: "tcp://relay:5001/path", fails: true},
+		{addr: "unix://", fails: true},
+		{addr: "usbmux://device", fails: true},
+	} {
+		network, address, useTLS, err := services.ParseAddr(tc.addr)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("%s: parsed as %s %s", tc.addr, network, address)
+			}
+			continue
+		}
+		if err != nil || network != tc.network || address != tc.address || useTLS != tc.useTLS {
+			t.Errorf("%s: got %s %s %v %v", tc.addr, network, address, useTLS, err)
+		}
+	}
+}
+
+// checkTransport writes and reads back a file through a service dialing
+// addr.
+func checkTransport(t *testing.T, srv *afctest.Server, addr string, opts services.Options) {
+	t.Helper()
+	opts.ReconnectBackoff = time.Millisecond
+	opts.OpTimeout = 5 * time.Second
+	afc, err := services.NewFsyncWithOptions(addr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer afc.Close()
+
+	if err = afero.WriteFile(afc, "/f.txt", []byte("over the transport"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := afero.ReadFile(srv.Fs, "/f.txt")
+	if err != nil || string(data) != "over the transport" {
+		t.Errorf("file on the server: %q %v", data, err)
+	}
+
+	// a redial goes through the same transport
+	srv.CloseClientConnections()
+	if _, err = afc.Stat("/f.txt"); err != nil {
+		t.Errorf("stat after a disconnect: %v", err)
+	}
+}
+
+func TestAfcDialPipe(t *testing.T) {
+	srv := afctest.NewServer()
+	t.Cleanup(srv.Close)
+
+	dialer := services.DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go srv.ServeConn(server)
+		return client, nil
+	})
+	checkTransport(t, srv, "pipe", services.Options{Dialer: dialer})
+}
+
+func TestAfcDialUnix(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "afc.sock")
+	l, err := net.Listen("unix", name)
+	if err != nil {
+		t.Skipf("unix sockets are not available: %v", err)
+	}
+	srv := afctest.NewServerWithListener(l)
+	t.Cleanup(srv.Close)
+
+	checkTransport(t, srv, "unix://"+name, services.Options{})
+}
+
+// newTestCert returns a self-signed certificate for 127.0.0.1, usable by
+// both ends of a connection.
+func newTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "afctest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestAfcDialTLS(t *testing.T) {
+	cert, pool := newTestCert(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := afctest.NewServerWithListener(tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}))
+	t.Cleanup(srv.Close)
+	addr := "tls://" + srv.Addr()
+
+	checkTransport(t, srv, addr, services.Options{TLSConfig: &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}})
+
+	if _, err = services.NewFsyncWithOptions(addr, services.Options{PoolSize: 1}); err == nil {
+		t.Error("connected to a server whose certificate is not trusted")
+	}
+}
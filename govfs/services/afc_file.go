@@ -1,13 +1,17 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"io"
 	"os"
 	"path"
-	"strings"
 	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
 )
 
 type File struct {
@@ -15,6 +19,17 @@ type File struct {
 	pfd     uint64
 	absPath string
 	isdir   bool
+	ctx     context.Context
+
+	// readAhead holds data read from the device but not consumed yet; the
+	// device position is past it. readBuf backs it and is reused.
+	readAhead []byte
+	readBuf   []byte
+
+	// dirInfos and dirNames hold the entries not returned yet by Readdir
+	// and Readdirnames
+	dirInfos []os.FileInfo
+	dirNames []string
 }
 
 func NewFile(conn *AfcService, pfd uint64, absPath string, isdir bool) *File {
@@ -23,8 +38,12 @@ func NewFile(conn *AfcService, pfd uint64, absPath string, isdir bool) *File {
 		pfd:     pfd,
 		absPath: absPath,
 		isdir:   isdir,
+		ctx:     context.Background(),
 	}
 }
+
+// Close releases the descriptor even if the context of the file is done,
+// so an aborted request does not leak it on the device.
 func (f *File) Close() (err error) {
 	if !f.isdir {
 		return f.conn.CloseFile(f.pfd)
@@ -32,62 +51,147 @@ func (f *File) Close() (err error) {
 	return nil
 }
 
+// Read serves sequential reads from a read-ahead buffer of one block, so
+// that small reads do not each cost a round-trip to the device.
 func (f *File) Read(p []byte) (n int, err error) {
-	return f.conn.ReadFile(f.pfd, p)
+	if len(f.readAhead) == 0 {
+		if len(p) >= f.conn.opts.BlockSize {
+			return f.conn.ReadFileContext(f.ctx, f.pfd, p)
+		}
+		if f.readBuf == nil {
+			f.readBuf = make([]byte, f.conn.opts.BlockSize)
+		}
+		n, err = f.conn.ReadFileContext(f.ctx, f.pfd, f.readBuf)
+		if n == 0 {
+			return 0, err
+		}
+		f.readAhead = f.readBuf[:n]
+	}
+
+	n = copy(p, f.readAhead)
+	f.readAhead = f.readAhead[n:]
+	return n, nil
+}
+
+// dropReadAhead discards the read-ahead buffer and moves the device position
+// back to where the caller expects it.
+func (f *File) dropReadAhead() error {
+	if len(f.readAhead) == 0 {
+		return nil
+	}
+	back := int64(len(f.readAhead))
+	f.readAhead = nil
+	_, err := f.conn.SeekFileContext(f.ctx, f.pfd, -back, io.SeekCurrent)
+	return err
 }
 
+// ReadAt does not use or move the read position of f.
 func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
-	return -1, syscall.EPFNOSUPPORT //syscall.EAFNOSUPPORT
+	return f.conn.ReadFileAtContext(f.ctx, f.pfd, p, off)
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
-	return f.conn.SeekFile(f.pfd, offset, whence)
+	if whence == io.SeekCurrent {
+		offset -= int64(len(f.readAhead))
+	}
+	f.readAhead = nil
+	return f.conn.SeekFileContext(f.ctx, f.pfd, offset, whence)
 }
 
 func (f *File) Write(p []byte) (n int, err error) {
-	return f.conn.WriteFile(f.pfd, p)
+	if err = f.dropReadAhead(); err != nil {
+		return 0, err
+	}
+	return f.conn.WriteFileContext(f.ctx, f.pfd, p)
 }
 
 func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
-	return 0, syscall.EPFNOSUPPORT
+	// the read-ahead may cover the range being overwritten
+	if err = f.dropReadAhead(); err != nil {
+		return 0, err
+	}
+	return f.conn.WriteFileAtContext(f.ctx, f.pfd, p, off)
 }
 
 func (f *File) Name() string {
 	return f.absPath
 }
 
-// Readdir must return nil
+// Readdir lists the directory with the stat of every entry, in one
+// enumeration when the device supports it. Like os.File, a count > 0 returns
+// at most count entries per call and io.EOF at the end of the directory.
 func (f *File) Readdir(count int) (fi []os.FileInfo, err error) {
-	if count > 0 {
-		log.Fatalln("not support count > 0")
+	if f.dirInfos == nil {
+		if f.dirInfos, err = f.readDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	if count > 0 && len(f.dirInfos) == 0 {
+		return nil, io.EOF
+	}
+	if count <= 0 || count > len(f.dirInfos) {
+		count = len(f.dirInfos)
+	}
+	fi, f.dirInfos = f.dirInfos[:count], f.dirInfos[count:]
+	return fi, nil
+}
+
+func (f *File) readDir() ([]os.FileInfo, error) {
+	infos := []os.FileInfo{}
+	err := f.conn.EnumerateDirContext(f.ctx, f.absPath, func(info *StatInfo) error {
+		infos = append(infos, info)
+		return nil
+	})
+	if !errors.Is(err, govfs.ErrNotSupported) {
+		return infos, err
 	}
 
-	files, _ := f.conn.ReadDir(f.absPath)
+	files, err := f.conn.ReadDirContext(f.ctx, f.absPath)
+	if err != nil {
+		return nil, err
+	}
 	for _, entry := range files {
-		fileInfo, err := f.conn.Stat(path.Join(f.absPath, entry))
-		if err != nil {
-			if strings.Contains(err.Error(), Afc_Err_PermDenied.Error().Error()) || strings.Contains(err.Error(), Afc_Err_OperationNotSupported.Error().Error()) {
-				log.Errorf("Readdir: %v", err)
-				fileInfo = new(StatInfo).SetName(entry).SetTime(time.Now(), time.Now())
-			}
+		fileInfo, err := f.conn.StatContext(f.ctx, path.Join(f.absPath, entry))
+		switch {
+		case err == nil:
+		case errors.Is(err, Afc_Err_PermDenied) || errors.Is(err, Afc_Err_OperationNotSupported):
+			log.Errorf("Readdir: %v", err)
+			fileInfo = new(StatInfo).SetName(entry).SetTime(time.Now(), time.Now())
+		default:
+			// the entry went away since the listing
+			log.Errorf("Readdir: %v", err)
+			continue
 		}
-		fi = append(fi, fileInfo)
+		infos = append(infos, fileInfo)
 	}
-	return
+	return infos, nil
 }
 
-// Readdirnames must return nil
+// Readdirnames returns the names of the entries, with the same count
+// semantics as Readdir.
 func (f *File) Readdirnames(count int) (names []string, err error) {
-	if count > 0 {
-		log.Fatalln("not support count > 0")
+	if f.dirNames == nil {
+		files, err := f.conn.ReadDirContext(f.ctx, f.absPath)
+		if err != nil {
+			return nil, err
+		}
+		f.dirNames = append([]string{}, files...)
 	}
-	files, err := f.conn.ReadDir(f.absPath)
-	return files, nil
+
+	if count > 0 && len(f.dirNames) == 0 {
+		return nil, io.EOF
+	}
+	if count <= 0 || count > len(f.dirNames) {
+		count = len(f.dirNames)
+	}
+	names, f.dirNames = f.dirNames[:count], f.dirNames[count:]
+	return names, nil
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
 	// FIXME: may be out of date
-	return f.conn.Stat(f.absPath)
+	return f.conn.StatContext(f.ctx, f.absPath)
 }
 
 func (f *File) Sync() error {
@@ -95,7 +199,10 @@ func (f *File) Sync() error {
 }
 
 func (f *File) Truncate(size int64) error {
-	return f.conn.TruncateFile(f.pfd, size)
+	if err := f.dropReadAhead(); err != nil {
+		return err
+	}
+	return f.conn.TruncateFileContext(f.ctx, f.pfd, size)
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
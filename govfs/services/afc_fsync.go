@@ -1,7 +1,8 @@
 package services
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +14,8 @@ import (
 	"git.woa.com/CloudTesting/UDT/ioskit/ioskit/utils"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
 )
 
 type FsType int
@@ -29,29 +32,49 @@ type Fsync struct {
 	*AfcService
 	FsType   FsType
 	BundleId string //only used for house_arrest
+	ctx      context.Context
 }
 
 func NewFsync(addr string) (*Fsync, error) {
-	afc, err := NewAfcService(addr)
+	return NewFsyncWithOptions(addr, Options{})
+}
+
+func NewFsyncWithOptions(addr string, opts Options) (*Fsync, error) {
+	afc, err := NewAfcServiceWithOptions(addr, opts)
 	if err != nil {
 		return nil, err
 	}
-	return &Fsync{afc, AfcRootFs, ""}, nil
+	return &Fsync{AfcService: afc, FsType: AfcRootFs}, nil
+}
+
+// WithContext returns a view of fs whose requests, including those on files
+// opened through it, are bound to ctx.
+func (fs *Fsync) WithContext(ctx context.Context) afero.Fs {
+	bound := *fs
+	bound.ctx = ctx
+	return &bound
+}
+
+func (fs *Fsync) context() context.Context {
+	if fs.ctx == nil {
+		return context.Background()
+	}
+	return fs.ctx
 }
 
 /*
 func NewFsyncFromConn() *Fsync {
-	return &Fsync{&AfcService{deviceConn: devConn}, AfcAnyFs, ""}
+	return &Fsync{AfcService: &AfcService{deviceConn: devConn}, FsType: AfcAnyFs}
 }
 */
 
 func (fs *Fsync) SendFile(b []byte, path string) error {
-	fd, err := fs.AfcService.OpenFile(path, Afc_Mode_WRONLY)
+	fd, err := fs.AfcService.OpenFileContext(fs.context(), path, Afc_Mode_WRONLY)
 	if err != nil {
 		return err
 	}
-	defer fs.CloseFile(fd)
-	_, err = fs.AfcService.WriteFile(fd, b)
+	defer fs.AfcService.CloseFile(fd)
+	_, err = fs.AfcService.WriteFileContext(fs.context(), fd, b)
 	if err != nil {
 		return err
 	}
@@ -61,7 +84,7 @@ func (fs *Fsync) SendFile(b []byte, path string) error {
 // ListFiles returns all files in the given directory, matching the pattern.
 // Example: ListFiles(".", "*") returns all files and dirs in the current path the afc connection is in
 func (fs *Fsync) ListFiles(cwd string, matchPattern string) ([]string, error) {
-	files, err := fs.ReadDir(cwd)
+	files, err := fs.AfcService.ReadDirContext(fs.context(), cwd)
 	if err != nil {
 		return nil, err
 	}
@@ -100,7 +123,7 @@ func (fs *Fsync) TreeView(dpath string, prefix string, treePoint bool) error {
 	}
 
 	fmt.Printf("%s %s/\n", tPrefix, filepath.Base(dpath))
-	fileList, err := fs.ReadDir(dpath)
+	fileList, err := fs.AfcService.ReadDirContext(fs.context(), dpath)
 	if err != nil {
 		return err
 	}
@@ -124,19 +147,22 @@ func (fs *Fsync) TreeView(dpath string, prefix string, treePoint bool) error {
 }
 
 func (fs *Fsync) PullFile(srcPath, dstPath string) error {
-	fileInfo, err := fs.AfcService.Stat(srcPath)
+	return fs.PullFileWithHandler(srcPath, dstPath, nil)
+}
+
+// PullFileWithHandler copies the device file srcPath to the local file
+// dstPath, calling handler with the size of every chunk received.
+func (fs *Fsync) PullFileWithHandler(srcPath, dstPath string, handler func(size uint64, status string)) error {
+	fileInfo, srcPath, err := fs.follow(srcPath)
 	if err != nil {
 		return err
 	}
 
-	if fileInfo.IsLink() {
-		srcPath = fileInfo.stLinktarget
-	}
-	fd, err := fs.AfcService.OpenFile(srcPath, Afc_Mode_RDONLY)
+	fd, err := fs.AfcService.OpenFileContext(fs.context(), srcPath, Afc_Mode_RDONLY)
 	if err != nil {
 		return err
 	}
-	defer fs.CloseFile(fd)
+	defer fs.AfcService.CloseFile(fd)
 
 	f, err := os.Create(dstPath)
 	if err != nil {
@@ -145,28 +171,41 @@ func (fs *Fsync) PullFile(srcPath, dstPath string) error {
 	defer f.Close()
 
 	leftSize := fileInfo.stSize
-	maxReadSize := 64 * 1024
-	data := make([]byte, 16)
-	binary.LittleEndian.PutUint64(data, fd)
-	binary.LittleEndian.PutUint64(data[8:], uint64(maxReadSize))
+	chunk := make([]byte, fs.opts.BlockSize)
 	for leftSize > 0 {
-		response, err := fs.request(Afc_operation_file_read, data, nil)
-		if err != nil {
+		n, err := fs.AfcService.ReadFileContext(fs.context(), fd, chunk)
+		if n > 0 {
+			leftSize -= int64(n)
+			if _, werr := f.Write(chunk[:n]); werr != nil {
+				return werr
+			}
+			if handler != nil {
+				handler(uint64(n), "Pulling")
+			}
+		}
+		if err != nil && err != io.EOF {
 			return err
 		}
-		leftSize -= int64(len(response.Payload))
-		f.Write(response.Payload)
+		if n == 0 {
+			break
+		}
 	}
 	return nil
 }
 
 func (fs *Fsync) Pull(srcPath, dstPath string) error {
+	return fs.PullWithHandler(srcPath, dstPath, nil)
+}
+
+// PullWithHandler copies srcPath and everything below it to dstPath, with
+// the handler of PullFileWithHandler.
+func (fs *Fsync) PullWithHandler(srcPath, dstPath string, handler func(size uint64, status string)) error {
 	fileInfo, err := fs.Stat(srcPath)
 	if err != nil {
 		return err
 	}
 	if !fileInfo.IsDir() {
-		return fs.PullFile(srcPath, dstPath)
+		return fs.PullFileWithHandler(srcPath, dstPath, handler)
 	}
 	ret, _ := utils.PathExists(dstPath)
 	if !ret {
@@ -175,14 +214,14 @@ func (fs *Fsync) Pull(srcPath, dstPath string) error {
 			return err
 		}
 	}
-	fileList, err := fs.ReadDir(srcPath)
+	fileList, err := fs.AfcService.ReadDirContext(fs.context(), srcPath)
 	if err != nil {
 		return err
 	}
 	for _, v := range fileList {
 		sp := path.Join(srcPath, v)
 		dp := path.Join(dstPath, v)
-		err = fs.Pull(sp, dp)
+		err = fs.PullWithHandler(sp, dp, handler)
 		if err != nil {
 			return err
 		}
@@ -219,11 +258,11 @@ func (fs *Fsync) PushWithHandler(srcPath, dstPath string, handler func(size uint
 	}
 	fileSize := info.Size()
 
-	fd, err := fs.AfcService.OpenFile(dstPath, Afc_Mode_WR)
+	fd, err := fs.AfcService.OpenFileContext(fs.context(), dstPath, Afc_Mode_WR)
 	if err != nil {
 		return err
 	}
-	defer fs.CloseFile(fd)
+	defer fs.AfcService.CloseFile(fd)
 
 	// NOTE: optimize memory cost
 	var maxWriteSize int
@@ -245,9 +284,7 @@ func (fs *Fsync) PushWithHandler(srcPath, dstPath string, handler func(size uint
 			handler(uint64(n), "Pushing")
 		}
 
-		data := make([]byte, 8)
-		binary.LittleEndian.PutUint64(data, fd)
-		_, err = fs.request(Afc_operation_file_write, data, chunk[0:n])
+		_, err = fs.AfcService.WriteFileContext(fs.context(), fd, chunk[0:n])
 		if err != nil {
 			return err
 		}
@@ -258,21 +295,21 @@ func (fs *Fsync) PushWithHandler(srcPath, dstPath string, handler func(size uint
 func (fs *Fsync) Name() string { return "iosfs" }
 
 func (fs *Fsync) Create(name string) (afero.File, error) {
-	fd, err := fs.AfcService.OpenFile(name, Afc_Mode_WR) // O_RDWR | O_CREAT | O_TRUNC
+	fd, err := fs.AfcService.OpenFileContext(fs.context(), name, Afc_Mode_WR) // O_RDWR | O_CREAT | O_TRUNC
 	if err != nil {
-		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+		return nil, err
 	}
-	return &File{pfd: fd, absPath: name, conn: fs.AfcService}, nil
+	return &File{pfd: fd, absPath: name, conn: fs.AfcService, ctx: fs.context()}, nil
 }
 
 func (fs *Fsync) Mkdir(name string, perm os.FileMode) error {
-	return fs.MakeDir(name)
+	return fs.AfcService.MakeDirContext(fs.context(), name)
 }
 
 func (fs *Fsync) MkdirAll(path string, perm os.FileMode) error {
-	info, err := fs.AfcService.Stat(path)
+	info, err := fs.AfcService.StatContext(fs.context(), path)
 	if err != nil {
-		return fs.MakeDir(path)
+		return fs.AfcService.MakeDirContext(fs.context(), path)
 	}
 
 	if info.IsDir() {
@@ -287,10 +324,10 @@ func (fs *Fsync) Open(name string) (afero.File, error) {
 
 // OpenFile see https://github.com/libimobiledevice/ifuse/blob/master/src/ifuse.c#L177
 func (fs *Fsync) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
-	info, err := fs.AfcService.Stat(name)
+	info, err := fs.AfcService.StatContext(fs.context(), name)
 	if err == nil {
 		if info.IsDir() {
-			return &File{absPath: name, conn: fs.AfcService, isdir: true}, nil
+			return &File{absPath: name, conn: fs.AfcService, isdir: true, ctx: fs.context()}, nil
 		}
 	}
 
@@ -322,77 +359,160 @@ func (fs *Fsync) OpenFile(name string, flag int, perm os.FileMode) (afero.File,
 		return nil, fmt.Errorf("invalid flag")
 	}
 
-	fd, err := fs.AfcService.OpenFile(name, afcFlags)
+	fd, err := fs.AfcService.OpenFileContext(fs.context(), name, afcFlags)
 	if err != nil {
 		return nil, err
 	}
 
-	return &File{pfd: fd, absPath: name, conn: fs.AfcService, isdir: false}, nil
+	return &File{pfd: fd, absPath: name, conn: fs.AfcService, isdir: false, ctx: fs.context()}, nil
 }
 
 func (fs *Fsync) Remove(name string) error {
-	return fs.RemovePath(name)
+	return fs.AfcService.RemovePathContext(fs.context(), name)
 }
 
 func (fs *Fsync) RemoveAll(path string) error {
-	return fs.RemovePathAndContents(path)
+	return fs.RmTree(path)
 }
 
 func (fs *Fsync) Rename(oldname, newname string) error {
-	return fs.RenamePath(oldname, newname)
+	return fs.AfcService.RenamePathContext(fs.context(), oldname, newname)
 }
 
+// Stat follows symbolic links, use LstatIfPossible to stat a link itself.
 func (fs *Fsync) Stat(name string) (os.FileInfo, error) {
-	return fs.AfcService.Stat(name)
+	info, _, err := fs.follow(name)
+	if err != nil {
+		return nil, err
+	}
+	return info.SetName(path.Base(name)), nil
 }
 
+// maxLinkHops bounds the resolution of chained symbolic links, like
+// MAXSYMLINKS on Darwin.
+const maxLinkHops = 32
+
+// follow resolves the symbolic links at name and returns the stat of the
+// final target together with its path.
+func (fs *Fsync) follow(name string) (*StatInfo, string, error) {
+	for i := 0; i < maxLinkHops; i++ {
+		info, err := fs.AfcService.StatContext(fs.context(), name)
+		if err != nil {
+			return nil, name, err
+		}
+		if !info.IsLink() {
+			return info, name, nil
+		}
+		target := info.LinkTarget()
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(name), target)
+		}
+		name = target
+	}
+	return nil, name, &os.PathError{Op: "stat", Path: name, Err: syscall.ELOOP}
+}
+
+func (fs *Fsync) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	info, err := fs.AfcService.StatContext(fs.context(), name)
+	if err != nil {
+		return nil, true, err
+	}
+	return info, true, nil
+}
+
+func (fs *Fsync) SymlinkIfPossible(oldname, newname string) error {
+	err := fs.AfcService.MakeLinkContext(fs.context(), AFC_SYMLINK, oldname, newname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+func (fs *Fsync) ReadlinkIfPossible(name string) (string, error) {
+	info, err := fs.AfcService.StatContext(fs.context(), name)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsLink() {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return info.LinkTarget(), nil
+}
+
+// Usage reports the storage of the device, which holds every path.
+func (fs *Fsync) Usage(name string) (*govfs.Usage, error) {
+	info, err := fs.AfcService.GetDeviceInfoContext(fs.context())
+	if err != nil {
+		return nil, err
+	}
+	return &govfs.Usage{
+		Total:     info.TotalBytes,
+		Free:      info.FreeBytes,
+		BlockSize: info.BlockSize,
+		Model:     info.Model,
+	}, nil
+}
+
+// Chmod is a no-op, AFC does not expose file permissions.
 func (fs *Fsync) Chmod(name string, mode os.FileMode) error {
 	return nil
 }
 
+// Chown is a no-op, AFC does not expose file ownership.
 func (fs *Fsync) Chown(name string, uid, gid int) error {
 	return nil
 }
 
+// Chtimes sets the modification time, AFC does not store access times.
 func (fs *Fsync) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	return nil
+	return fs.AfcService.SetFileTimeContext(fs.context(), name, mtime)
 }
 
+// RmTree removes path and everything below it. Devices that cannot do it in
+// one request get the tree removed entry by entry.
 func (fs *Fsync) RmTree(path string) error {
-	info, err := fs.AfcService.Stat(path)
+	err := fs.RemoveTree(path)
+	if !errors.Is(err, govfs.ErrNotSupported) {
+		return err
+	}
+	return fs.rmTree(path)
+}
+
+func (fs *Fsync) rmTree(path string) error {
+	info, err := fs.AfcService.StatContext(fs.context(), path)
 	if err != nil {
 		return err
 	}
 	if info.IsDir() {
-		files, err := fs.AfcService.ReadDir(path)
+		files, err := fs.AfcService.ReadDirContext(fs.context(), path)
 		if err != nil {
 			return err
 		}
 		for _, f := range files {
 			filePath := path + "/" + f
-			info, err = fs.AfcService.Stat(filePath)
+			info, err = fs.AfcService.StatContext(fs.context(), filePath)
 			if err != nil {
 				log.Errorf("stat %v error: %v", f, err)
 				continue
 			}
 			if info.IsDir() {
-				err = fs.RmTree(filePath)
+				err = fs.rmTree(filePath)
 				if err != nil {
 					return err
 				}
 			} else {
-				err = fs.AfcService.RemovePath(filePath)
+				err = fs.AfcService.RemovePathContext(fs.context(), filePath)
 				if err != nil {
 					return err
 				}
 			}
 		}
-		err = fs.AfcService.RemovePath(path)
+		err = fs.AfcService.RemovePathContext(fs.context(), path)
 		if err != nil {
 			return err
 		}
 	} else {
-		err = fs.AfcService.RemovePath(path)
+		err = fs.AfcService.RemovePathContext(fs.context(), path)
 		if err != nil {
 			return err
 		}
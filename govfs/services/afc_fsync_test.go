@@ -1,51 +1,377 @@
 package services_test
 
 import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
-	"git.woa.com/frosthuang/ga-filebrowser/govfs/services"
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/govfs/services/afctest"
 )
 
-func TestAfcSyncTree(t *testing.T) {
-	afc, err := services.NewFsync("127.0.0.1:5001")
+func newTestFsync(t *testing.T) (*afctest.Server, *services.Fsync) {
+	t.Helper()
+	srv := afctest.NewServer()
+	t.Cleanup(srv.Close)
+
+	afc, err := services.NewFsyncWithOptions(srv.Addr(), services.Options{
+		ReconnectBackoff: time.Millisecond,
+		OpTimeout:        5 * time.Second,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	t.Cleanup(func() { _ = afc.Close() })
+	return srv, afc
+}
 
-	err = afc.TreeView("/Users/frosthuang/Work/code/ga-filebrowser/govfs", "", false)
+func TestAfcSyncTree(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	writeTestFile(t, srv.Fs, "/govfs/services/afc.go", "package services")
+	writeTestFile(t, srv.Fs, "/govfs/govfs.go", "package govfs")
+
+	err := afc.TreeView("/govfs", "", false)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestAfcSyncPush(t *testing.T) {
-	afc, err := services.NewFsync("127.0.0.1:5001")
-	if err != nil {
+	srv, afc := newTestFsync(t)
+	if err := srv.Fs.MkdirAll("/upload", 0755); err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
 
-	err = afc.PushWithHandler("/Users/frosthuang/Downloads/goland-2023.2.1-aarch64.dmg", "/Users/frosthuang/Work/code/ga-filebrowser", func(size uint64, status string) {
-		fmt.Printf("size:%d status:%s\n", size, status)
+	content := make([]byte, 300*1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	src := filepath.Join(t.TempDir(), "largefile")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var pushed uint64
+	err := afc.PushWithHandler(src, "/upload", func(size uint64, status string) {
+		pushed += size
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushed != uint64(len(content)) {
+		t.Fatalf("handler saw %d bytes, want %d", pushed, len(content))
+	}
 
+	got, err := afero.ReadFile(srv.Fs, "/upload/largefile")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if string(got) != string(content) {
+		t.Fatal("pushed content differs")
+	}
 }
 
 func TestAfcSyncPull(t *testing.T) {
-	afc, err := services.NewFsync("127.0.0.1:5001")
+	srv, afc := newTestFsync(t)
+	content := make([]byte, 200*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	if err := afero.WriteFile(srv.Fs, "/dir/sub/largefile", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, srv.Fs, "/dir/small", "small")
+
+	dst := filepath.Join(t.TempDir(), "dir")
+	var pulled uint64
+	if err := afc.PullWithHandler("/dir", dst, func(size uint64, status string) { pulled += size }); err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(len(content) + len("small")); pulled != want {
+		t.Errorf("handler was given %d bytes, want %d", pulled, want)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "sub", "largefile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Fatal("pulled content differs")
+	}
+	got, err = os.ReadFile(filepath.Join(dst, "small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "small" {
+		t.Fatalf("pulled content = %q", got)
+	}
+}
+
+func TestAfcSyncSymlink(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	writeTestFile(t, srv.Fs, "/DCIM/photo.jpg", "jpeg")
+
+	if err := afc.SymlinkIfPossible("DCIM/photo.jpg", "/latest.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := afc.SymlinkIfPossible("/latest.jpg", "/alias.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := afc.SymlinkIfPossible("/DCIM", "/latest.jpg"); err == nil {
+		t.Error("expected an error when the link already exists")
+	}
+
+	info, lstat, err := afc.LstatIfPossible("/alias.jpg")
+	if err != nil || !lstat {
+		t.Fatal(lstat, err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat mode = %v, want a symlink", info.Mode())
+	}
+
+	info, err = afc.Stat("/alias.jpg")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	if info.Name() != "alias.jpg" || info.Size() != 4 || info.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("stat = %s %d %v, want the target", info.Name(), info.Size(), info.Mode())
+	}
+
+	target, err := afc.ReadlinkIfPossible("/latest.jpg")
+	if err != nil || target != "DCIM/photo.jpg" {
+		t.Errorf("readlink = %q %v", target, err)
+	}
+	if _, err = afc.ReadlinkIfPossible("/DCIM/photo.jpg"); err == nil {
+		t.Error("expected an error reading a regular file as a link")
+	}
+
+	data, err := afero.ReadFile(afc, "/alias.jpg")
+	if err != nil || string(data) != "jpeg" {
+		t.Errorf("read through the link = %q %v", data, err)
+	}
+
+	if err = afc.SymlinkIfPossible("/loop-b", "/loop-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = afc.SymlinkIfPossible("/loop-a", "/loop-b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = afc.Stat("/loop-a"); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("stat of a link loop: got %v", err)
+	}
+}
 
-	err = afc.Pull("/Users/frosthuang/Downloads/goland-2023.2.1-aarch64.dmg", "/Users/frosthuang/Work/code/ga-filebrowser/largefile")
+func TestAfcSyncChtimes(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	writeTestFile(t, srv.Fs, "/testfile", "x")
 
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := afc.Chtimes("/testfile", time.Now(), mtime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := afc.Stat("/testfile")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime = %v, want %v", info.ModTime(), mtime)
+	}
+}
+
+func TestAfcSyncTreeOps(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	for i := 0; i < 100; i++ {
+		writeTestFile(t, srv.Fs, fmt.Sprintf("/cache/%03d", i), "0123456789")
+	}
+	writeTestFile(t, srv.Fs, "/cache/sub/file", "01234")
+
+	size, err := afc.TreeSize("/cache")
+	if err != nil || size != 1005 {
+		t.Errorf("TreeSize = %d %v, want 1005", size, err)
+	}
+
+	var entries int
+	err = afc.EnumerateDir("/cache", func(info os.FileInfo) error {
+		entries++
+		if info.Name() == "sub" && !info.IsDir() {
+			t.Errorf("%s is not reported as a directory", info.Name())
+		}
+		return nil
+	})
+	if err != nil || entries != 101 {
+		t.Errorf("EnumerateDir: %d entries, %v", entries, err)
+	}
+	if n := srv.Requests(services.Afc_operation_file_info); n != 0 {
+		t.Errorf("enumerating sent %d stat requests", n)
+	}
+
+	f, err := afc.Open("/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paged int
+	for {
+		infos, err := f.Readdir(30)
+		paged += len(infos)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+	if paged != 101 {
+		t.Errorf("Readdir(30) returned %d entries in total", paged)
+	}
+
+	if err = afc.RemoveAll("/cache"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := afero.Exists(srv.Fs, "/cache"); ok {
+		t.Error("directory still exists")
+	}
+	if n := srv.Requests(services.Afc_operation_remove_path); n != 0 {
+		t.Errorf("recursive delete sent %d remove requests", n)
+	}
+
+	// the device error of a failed creation is kept
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_file_open, Path: "/cache/new", Err: services.Afc_Err_PermDenied})
+	if _, err = afc.Create("/cache/new"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Create with a denied open: %v", err)
+	}
+}
+
+func TestAfcSyncTreeOpsUnsupported(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	writeTestFile(t, srv.Fs, "/cache/a", "0123456789")
+	writeTestFile(t, srv.Fs, "/cache/sub/b", "01234")
+	for _, op := range []uint64{services.AFC_OP_GET_SIZE_OF_PATH_CONTENTS, services.AFC_OP_REMOVE_PATH_AND_CONTENTS, services.AFC_OP_DIR_OPEN} {
+		srv.Inject(afctest.Fault{Op: op, Err: services.Afc_Err_UnknownPacketType})
+	}
+
+	if _, err := afc.TreeSize("/cache"); !errors.Is(err, govfs.ErrNotSupported) {
+		t.Errorf("TreeSize on an old device: got %v", err)
+	}
+	if size, err := govfs.TreeSize(afc, "/cache"); err != nil || size != 15 {
+		t.Errorf("govfs.TreeSize = %d %v, want 15", size, err)
+	}
+
+	infos, err := govfs.ReadDir(afc, "/cache")
+	if err != nil || len(infos) != 2 {
+		t.Errorf("govfs.ReadDir = %v %v", infos, err)
+	}
+
+	if err = afc.RemoveAll("/cache"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := afero.Exists(srv.Fs, "/cache"); ok {
+		t.Error("directory still exists")
+	}
+	// rejected operations are not sent again
+	if n := srv.Requests(services.AFC_OP_DIR_OPEN); n != 1 {
+		t.Errorf("DIR_OPEN was sent %d times", n)
+	}
+}
+
+func TestAfcSyncTreeOpsRefused(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	writeTestFile(t, srv.Fs, "/locked/a", "0123456789")
+	writeTestFile(t, srv.Fs, "/cache/b", "01234")
+	srv.Inject(afctest.Fault{Op: services.AFC_OP_REMOVE_PATH_AND_CONTENTS, Path: "/locked", Err: services.Afc_Err_OperationNotSupported})
+
+	// a refusal for one path falls back without disabling the operation
+	if err := afc.RemoveAll("/locked"); err != nil {
+		t.Fatal(err)
+	}
+	if err := afc.RemoveAll("/cache"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/locked", "/cache"} {
+		if ok, _ := afero.Exists(srv.Fs, name); ok {
+			t.Errorf("%s still exists", name)
+		}
+	}
+	if n := srv.Requests(services.AFC_OP_REMOVE_PATH_AND_CONTENTS); n != 2 {
+		t.Errorf("REMOVE_PATH_AND_CONTENTS was sent %d times, want 2", n)
+	}
+}
+
+func TestAfcSyncUsage(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	srv.Model = "iPad"
+	srv.TotalBytes = 1 << 20
+	writeTestFile(t, srv.Fs, "/DCIM/1.jpg", "0123456789")
+
+	usage, err := afc.Usage("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Model != "iPad" || usage.Total != 1<<20 || usage.Used() != 10 || usage.BlockSize == 0 {
+		t.Errorf("Usage = %+v", usage)
+	}
+}
+
+func TestAfcSyncHash(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	writeTestFile(t, srv.Fs, "/DCIM/1.jpg", "0123456789")
+
+	sum, err := afc.Hash("/DCIM/1.jpg", services.DeviceHashAlgo, 2, 5)
+	if want := sha1.Sum([]byte("23456")); err != nil || !bytes.Equal(sum, want[:]) {
+		t.Errorf("Hash = %x %v, want %x", sum, err, want)
+	}
+	if _, err = afc.Hash("/DCIM/1.jpg", "md5", 0, 10); !errors.Is(err, govfs.ErrNotSupported) {
+		t.Errorf("md5 Hash: got %v", err)
+	}
+
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_get_file_Hash_range, Err: services.Afc_Err_UnknownPacketType})
+	if _, err = afc.Hash("/DCIM/1.jpg", services.DeviceHashAlgo, 0, 10); !errors.Is(err, govfs.ErrNotSupported) {
+		t.Errorf("Hash on an old device: got %v", err)
+	}
+}
+
+func TestAfcSyncWriteFileAtomic(t *testing.T) {
+	srv, afc := newTestFsync(t)
+	writeTestFile(t, srv.Fs, "/notes.txt", "old")
+
+	if err := govfs.WriteFile(afc, "/notes.txt", strings.NewReader("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(srv.Fs, "/notes.txt"); string(data) != "new" {
+		t.Errorf("content after an atomic write: %q", data)
+	}
+	if n := srv.Requests(services.Afc_operation_write_file_atom); n != 1 {
+		t.Errorf("WriteFileAtomic was sent %d times", n)
+	}
+
+	// larger than a block, the content is written to a file renamed over the target
+	large := strings.Repeat("x", services.DefaultBlockSize+1)
+	if err := govfs.WriteFile(afc, "/notes.txt", strings.NewReader(large), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(srv.Fs, "/notes.txt"); string(data) != large {
+		t.Errorf("content after a large write: %d bytes", len(data))
+	}
+
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_write_file_atom, Err: services.Afc_Err_UnknownPacketType})
+	if err := govfs.WriteFile(afc, "/notes.txt", strings.NewReader("older device"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := afero.ReadFile(srv.Fs, "/notes.txt"); string(data) != "older device" {
+		t.Errorf("content after a write on an old device: %q", data)
+	}
+	if infos, _ := afero.ReadDir(srv.Fs, "/"); len(infos) != 1 {
+		t.Errorf("temporary files were left: %d entries", len(infos))
+	}
 }
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/binary"
+	"fmt"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+// DeviceHashAlgo is the algorithm of the hashes computed by the device.
+const DeviceHashAlgo = "sha1"
+
+func (conn *AfcService) GetFileHashWithRange(path string, start, end uint64) ([]byte, error) {
+	return conn.GetFileHashWithRangeContext(context.Background(), path, start, end)
+}
+
+// GetFileHashWithRangeContext returns the SHA-1 of the bytes [start, end)
+// of path, computed by the device. It returns an error wrapping
+// govfs.ErrNotSupported on devices without Afc_operation_get_file_Hash_range.
+func (conn *AfcService) GetFileHashWithRangeContext(ctx context.Context, path string, start, end uint64) ([]byte, error) {
+	data := make([]byte, 16+len(path)+1)
+	binary.LittleEndian.PutUint64(data, start)
+	binary.LittleEndian.PutUint64(data[8:], end)
+	copy(data[16:], path)
+
+	response, err := conn.optionalRequest(ctx, Afc_operation_get_file_Hash_range, data, nil)
+	if err != nil {
+		return nil, pathError("hash", path, err)
+	}
+	if len(response.Payload) != sha1.Size {
+		return nil, fmt.Errorf("afc: invalid file hash response of %d bytes", len(response.Payload))
+	}
+	return response.Payload, nil
+}
+
+// Hash hashes name on the device, which only knows DeviceHashAlgo.
+func (fs *Fsync) Hash(name, algo string, off, length int64) ([]byte, error) {
+	if algo != DeviceHashAlgo {
+		return nil, fmt.Errorf("%w: %s hashes", govfs.ErrNotSupported, algo)
+	}
+	if off < 0 || length < 0 {
+		return nil, fmt.Errorf("afc: invalid hash range %d+%d", off, length)
+	}
+	return fs.AfcService.GetFileHashWithRangeContext(fs.context(), name, uint64(off), uint64(off+length))
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func (conn *AfcService) ReadFileAt(fd uint64, p []byte, off int64) (n int, err error) {
+	return conn.ReadFileAtContext(context.Background(), fd, p, off)
+}
+
+// ReadFileAtContext reads len(p) bytes at offset off without moving the
+// file position, with io.ReaderAt semantics. Devices without
+// AFC_OP_FILE_READ_OFFSET (before iOS 7) are served by seeking, reading and
+// seeking back while holding the connection, so no other request on the
+// handle can interleave.
+func (conn *AfcService) ReadFileAtContext(ctx context.Context, fd uint64, p []byte, off int64) (n int, err error) {
+	c, dfd, err := conn.lockFile(ctx, fd)
+	if err != nil {
+		return 0, err
+	}
+	defer c.unlock()
+
+	for n < len(p) {
+		size := len(p) - n
+		if size > c.blockSize {
+			size = c.blockSize
+		}
+		m, err := conn.readAt(ctx, c, dfd, p[n:n+size], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (conn *AfcService) readAt(ctx context.Context, c *afcConn, dfd uint64, p []byte, off int64) (int, error) {
+	if conn.supports(AFC_OP_FILE_READ_OFFSET) {
+		data := make([]byte, 24)
+		binary.LittleEndian.PutUint64(data, dfd)
+		binary.LittleEndian.PutUint64(data[8:], uint64(off))
+		binary.LittleEndian.PutUint64(data[16:], uint64(len(p)))
+
+		response, err := c.request(ctx, AFC_OP_FILE_READ_OFFSET, data, nil)
+		if !isUnsupported(err) {
+			if err != nil {
+				return 0, err
+			}
+			if len(response.Payload) > len(p) {
+				return 0, fmt.Errorf("afc: device returned %d bytes for a %d byte read", len(response.Payload), len(p))
+			}
+			return copy(p, response.Payload), nil
+		}
+		conn.markUnsupported(AFC_OP_FILE_READ_OFFSET, err)
+	}
+
+	var n int
+	err := c.atOffset(ctx, dfd, off, func() error {
+		data := make([]byte, 16)
+		binary.LittleEndian.PutUint64(data, dfd)
+		binary.LittleEndian.PutUint64(data[8:], uint64(len(p)))
+		response, err := c.request(ctx, Afc_operation_file_read, data, nil)
+		if err != nil {
+			return err
+		}
+		if len(response.Payload) > len(p) {
+			return fmt.Errorf("afc: device returned %d bytes for a %d byte read", len(response.Payload), len(p))
+		}
+		n = copy(p, response.Payload)
+		return nil
+	})
+	return n, err
+}
+
+func (conn *AfcService) WriteFileAt(fd uint64, p []byte, off int64) (n int, err error) {
+	return conn.WriteFileAtContext(context.Background(), fd, p, off)
+}
+
+// WriteFileAtContext writes p at offset off without moving the file
+// position, falling back to seek and write like ReadFileAtContext.
+func (conn *AfcService) WriteFileAtContext(ctx context.Context, fd uint64, p []byte, off int64) (n int, err error) {
+	c, dfd, err := conn.lockFile(ctx, fd)
+	if err != nil {
+		return 0, err
+	}
+	defer c.unlock()
+
+	for n < len(p) {
+		end := n + c.blockSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err = conn.writeAt(ctx, c, dfd, p[n:end], off+int64(n)); err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return n, nil
+}
+
+func (conn *AfcService) writeAt(ctx context.Context, c *afcConn, dfd uint64, p []byte, off int64) error {
+	if conn.supports(AFC_OP_FILE_WRITE_OFFSET) {
+		data := make([]byte, 16)
+		binary.LittleEndian.PutUint64(data, dfd)
+		binary.LittleEndian.PutUint64(data[8:], uint64(off))
+
+		_, err := c.request(ctx, AFC_OP_FILE_WRITE_OFFSET, data, p)
+		if !isUnsupported(err) {
+			return err
+		}
+		conn.markUnsupported(AFC_OP_FILE_WRITE_OFFSET, err)
+	}
+
+	return c.atOffset(ctx, dfd, off, func() error {
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, dfd)
+		_, err := c.request(ctx, Afc_operation_file_write, data, p)
+		return err
+	})
+}
+
+// atOffset runs fn with the position of dfd moved to off, and restores the
+// position afterwards. The caller must hold the connection lock.
+func (c *afcConn) atOffset(ctx context.Context, dfd uint64, off int64, fn func() error) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, dfd)
+	response, err := c.request(ctx, Afc_operation_file_tell, data, nil)
+	if err != nil {
+		return err
+	}
+	pos := binary.LittleEndian.Uint64(response.HeaderPayload)
+
+	if err = c.seek(ctx, dfd, off, io.SeekStart); err != nil {
+		return err
+	}
+	err = fn()
+	if serr := c.seek(ctx, dfd, int64(pos), io.SeekStart); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// seek moves the position of dfd. The caller must hold the connection lock.
+func (c *afcConn) seek(ctx context.Context, dfd uint64, offset int64, whence int) error {
+	data := make([]byte, 24)
+	binary.LittleEndian.PutUint64(data, dfd)
+	binary.LittleEndian.PutUint64(data[8:], uint64(whence))
+	binary.LittleEndian.PutUint64(data[16:], uint64(offset))
+	_, err := c.request(ctx, Afc_operation_file_seek, data, nil)
+	return err
+}
@@ -1,272 +1,552 @@
 package services_test
 
 import (
-	"fmt"
+	"errors"
 	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"syscall"
 	"testing"
 	"time"
 
-	"git.woa.com/frosthuang/ga-filebrowser/govfs/services"
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/govfs/services/afctest"
 )
 
-func TestAfcService(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
+func newTestService(t *testing.T) (*afctest.Server, *services.AfcService) {
+	t.Helper()
+	srv := afctest.NewServer()
+	t.Cleanup(srv.Close)
+
+	afc, err := services.NewAfcServiceWithOptions(srv.Addr(), services.Options{
+		PoolSize:         2,
+		ReconnectBackoff: time.Millisecond,
+		OpTimeout:        5 * time.Second,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	t.Cleanup(func() { _ = afc.Close() })
+	return srv, afc
+}
 
-	list, err := afc.ReadDir("./")
-	if err != nil {
+func writeTestFile(t *testing.T, fs afero.Fs, name, content string) {
+	t.Helper()
+	if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
 		t.Fatal(err)
 	}
+}
 
-	fmt.Println("command line ls:")
-	for _, l := range list {
-		fmt.Println(l)
+func TestAfcService(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testdir/a", "a")
+	writeTestFile(t, srv.Fs, "/testdir/b", "b")
+
+	list, err := afc.ReadDir("/testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(list)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(list, want) {
+		t.Fatalf("ReadDir = %v, want %v", list, want)
 	}
 }
 
 func TestAfcServiceStat(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testdir/testfile", "hello")
+
+	stat, err := afc.Stat("/testdir/")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	if !stat.IsDir() || stat.Name() != "testdir" {
+		t.Fatalf("unexpected dir stat %+v", stat)
+	}
 
-	stat, err := afc.Stat("/Users/frosthuang/Work/code/ga-filebrowser/testdir/")
+	stat, err = afc.Stat("/testdir/testfile")
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	fmt.Printf("%+v\n", stat)
+	if stat.IsDir() || stat.Size() != 5 {
+		t.Fatalf("unexpected file stat %+v", stat)
+	}
 }
 
 func TestAfcServiceRm(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
-	if err != nil {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testdir/testfile", "hello")
+
+	if err := afc.RemovePath("/testdir"); err == nil {
+		t.Fatal("removing a non-empty directory should fail")
+	}
+	if err := afc.RemovePath("/testdir/testfile"); err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	if err := afc.RemovePath("/testdir"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := afero.Exists(srv.Fs, "/testdir"); exists {
+		t.Fatal("testdir still exists")
+	}
+}
 
-	err = afc.RemovePath("/Users/frosthuang/Work/code/ga-filebrowser/testdir")
-	if err != nil {
+func TestAfcServiceRename(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testdir/testfile1", "hello")
+
+	if err := afc.RenamePath("/testdir/testfile1", "/testdir/testfile2"); err != nil {
 		t.Fatal(err)
 	}
 
-	list, err := afc.ReadDir("/Users/frosthuang/Work/code/ga-filebrowser/")
+	list, err := afc.ReadDir("/testdir")
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	fmt.Println("command line ls:")
-	for _, l := range list {
-		fmt.Println(l)
+	if want := []string{"testfile2"}; !reflect.DeepEqual(list, want) {
+		t.Fatalf("ReadDir = %v, want %v", list, want)
 	}
 }
 
-func TestAfcServiceRename(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
-	if err != nil {
+func TestAfcServiceMakedir(t *testing.T) {
+	srv, afc := newTestService(t)
+
+	if err := afc.MakeDir("/testdir"); err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	if ok, _ := afero.DirExists(srv.Fs, "/testdir"); !ok {
+		t.Fatal("testdir was not created")
+	}
+}
 
-	err = afc.RenamePath("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile1", "testfile2")
+// Openfile and Closefile
+func TestAfcServiceOpenfile(t *testing.T) {
+	srv, afc := newTestService(t)
+
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_WR)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	list, err := afc.ReadDir("/Users/frosthuang/Work/code/ga-filebrowser/testdir")
-	if err != nil {
+	if err = afc.CloseFile(fd); err != nil {
 		t.Fatal(err)
 	}
+	if ok, _ := afero.Exists(srv.Fs, "/testfile"); !ok {
+		t.Fatal("testfile was not created")
+	}
 
-	fmt.Println("command line ls:")
-	for _, l := range list {
-		fmt.Println(l)
+	if _, err = afc.OpenFile("/missing", services.Afc_Mode_RDONLY); err == nil {
+		t.Fatal("opening a missing file should fail")
 	}
 }
 
-func TestAfcServiceMakedir(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
+func TestAfcServiceRead(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello world")
+
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_RDONLY)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	defer afc.CloseFile(fd)
 
-	err = afc.MakeDir("/Users/frosthuang/Work/code/ga-filebrowser/testdir")
-	if err != nil {
+	p := make([]byte, 32)
+	n, err := afc.ReadFile(fd, p)
+	if err != nil && err != io.EOF {
 		t.Fatal(err)
 	}
+	if got := string(p[:n]); got != "hello world" {
+		t.Fatalf("ReadFile = %q", got)
+	}
+}
+
+func TestAfcServiceWrite(t *testing.T) {
+	srv, afc := newTestService(t)
 
-	list, err := afc.ReadDir("/Users/frosthuang/Work/code/ga-filebrowser/")
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_WR)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if _, err = afc.WriteFile(fd, []byte("123")); err != nil {
+		t.Fatal(err)
+	}
+	if err = afc.CloseFile(fd); err != nil {
+		t.Fatal(err)
+	}
 
-	fmt.Println("command line ls:")
-	for _, l := range list {
-		fmt.Println(l)
+	data, err := afero.ReadFile(srv.Fs, "/testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "123" {
+		t.Fatalf("file content = %q", data)
 	}
 }
 
-// Openfile and Closefile
-func TestAfcServiceOpenfile(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
+// Seekfile and Tellfile
+func TestAfcServiceSeek(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello")
+
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_RDONLY)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	defer afc.CloseFile(fd)
 
-	fd, err := afc.OpenFile("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile", services.Afc_Mode_WR)
+	offset, err := afc.SeekFile(fd, 1, io.SeekStart)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if offset != 1 {
+		t.Fatalf("SeekFile = %d, want 1", offset)
+	}
 
-	err = afc.CloseFile(fd)
+	pos, err := afc.TellFile(fd)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	fmt.Printf("fd: %d\n", fd)
+	if pos != 1 {
+		t.Fatalf("TellFile = %d, want 1", pos)
+	}
 }
 
-func TestAfcServiceRead(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
+func TestAfcServiceTruncate(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello world")
+
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_RW)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	defer afc.CloseFile(fd)
 
-	fd, err := afc.OpenFile("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile", services.Afc_Mode_RDONLY)
+	if err = afc.TruncateFile(fd, 5); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := afc.Stat("/testfile")
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Printf("fd: %d\n", fd)
+	if stat.Size() != 5 {
+		t.Fatalf("size after TruncateFile = %d, want 5", stat.Size())
+	}
 
-	p := make([]byte, 32)
-	n, err := afc.ReadFile(fd, p)
+	if err = afc.Truncate("/testfile", 3); err != nil {
+		t.Fatal(err)
+	}
+	stat, err = afc.Stat("/testfile")
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Printf("out bytes num=%d\n", n)
-	for _, l := range p {
-		fmt.Printf("%c", rune(l))
+	if stat.Size() != 3 {
+		t.Fatalf("size after Truncate = %d, want 3", stat.Size())
 	}
+}
+
+func TestAfcServiceSetFileTime(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello")
 
-	err = afc.CloseFile(fd)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := afc.SetFileTime("/testfile", mtime); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := afc.Stat("/testfile")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !stat.ModTime().Equal(mtime) {
+		t.Fatalf("ModTime = %v, want %v", stat.ModTime(), mtime)
+	}
+}
+
+func TestAfcServiceInjectedErrors(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello")
+
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_file_info, Path: "/testfile", Err: services.Afc_Err_ObjectNotFound, Times: 1})
+	if _, err := afc.Stat("/testfile"); err == nil {
+		t.Fatal("injected ObjectNotFound was not reported")
+	}
+	if _, err := afc.Stat("/testfile"); err != nil {
+		t.Fatalf("fault should only fire once: %v", err)
+	}
 
-	fmt.Printf("fd: %d\n", fd)
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_remove_path, Err: services.Afc_Err_PermDenied})
+	if err := afc.RemovePath("/testfile"); err == nil {
+		t.Fatal("injected PermDenied was not reported")
+	}
+	srv.Reset()
+	if err := afc.RemovePath("/testfile"); err != nil {
+		t.Fatal(err)
+	}
 }
 
-func TestAfcServiceWrite(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
+func TestAfcServiceErrors(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/full/file", "hello")
+
+	_, err := afc.Stat("/missing")
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || pathErr.Op != "stat" || pathErr.Path != "/missing" {
+		t.Errorf("stat of a missing file: %#v", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) || !errors.Is(err, services.Afc_Err_ObjectNotFound) {
+		t.Errorf("stat of a missing file: %v is not ErrNotExist", err)
+	}
+
+	if err = afc.RemovePath("/full"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("remove of a non-empty directory: got %v", err)
+	}
+
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_file_open, Err: services.Afc_Err_NoSpaceLeft})
+	if _, err = afc.OpenFile("/full/new", services.Afc_Mode_WRONLY); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("open on a full device: got %v", err)
+	}
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_file_info, Err: services.Afc_Err_PermDenied})
+	if _, err = afc.Stat("/full/file"); !errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat without permission: got %v", err)
+	}
+}
+
+func TestAfcServiceShortRead(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello world")
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_file_read, ShortRead: 4})
+
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_RDONLY)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer afc.Close()
+	defer afc.CloseFile(fd)
 
-	fd, err := afc.OpenFile("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile", services.Afc_Mode_WR)
-	if err != nil {
+	p := make([]byte, 32)
+	n, err := afc.ReadFile(fd, p)
+	if err != nil && err != io.EOF {
 		t.Fatal(err)
 	}
-	fmt.Printf("fd: %d\n", fd)
+	if got := string(p[:n]); got != "hell" {
+		t.Fatalf("short ReadFile = %q", got)
+	}
+}
+
+func TestAfcServiceReconnect(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello")
 
-	p := []byte{'1', '2', '3'}
-	_, err = afc.WriteFile(fd, p)
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_RDONLY)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = afc.CloseFile(fd)
-	if err != nil {
+	srv.CloseClientConnections()
+
+	// idempotent requests are retried on a fresh connection
+	if _, err = afc.Stat("/testfile"); err != nil {
+		t.Fatal(err)
+	}
+	if err = afc.Health(); err != nil {
 		t.Fatal(err)
 	}
 
-	fmt.Printf("fd: %d\n", fd)
+	// descriptors do not survive the connection they were opened on
+	for i := 0; i < 2; i++ {
+		_, err = afc.ReadFile(fd, make([]byte, 8))
+		if err == nil {
+			t.Fatal("read on a dropped descriptor should fail")
+		}
+	}
+	if !errors.Is(err, services.ErrStaleHandle) {
+		t.Fatalf("got %v, want %v", err, services.ErrStaleHandle)
+	}
+	if err = afc.CloseFile(fd); err != nil {
+		t.Fatal(err)
+	}
 }
 
-// Seekfile and Tellfile
-func TestAfcServiceSeek(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
-	if err != nil {
-		t.Fatal(err)
+func TestAfcServiceDisconnect(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello")
+
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_remove_path, Disconnect: true, Times: 1})
+	err := afc.RemovePath("/testfile")
+	var connErr *services.ConnectionError
+	if !errors.As(err, &connErr) {
+		t.Fatalf("got %v, want a connection error", err)
 	}
-	defer afc.Close()
 
-	fd, err := afc.OpenFile("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile", services.Afc_Mode_WR)
-	if err != nil {
+	if err = afc.RemovePath("/testfile"); err != nil {
 		t.Fatal(err)
 	}
-	fmt.Printf("fd: %d\n", fd)
+}
 
-	off_set, err := afc.SeekFile(fd, 1, io.SeekStart)
+func TestAfcServiceClose(t *testing.T) {
+	srv, afc := newTestService(t)
+	writeTestFile(t, srv.Fs, "/testfile", "hello")
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_RDONLY)
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Printf("off_set: %d\n", off_set)
 
-	err = afc.CloseFile(fd)
-	if err != nil {
+	// requests running while the service is closed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			_, _ = afc.Stat("/testfile")
+		}
+	}()
+	if err = afc.Close(); err != nil {
 		t.Fatal(err)
 	}
+	<-done
 
-	fmt.Printf("fd: %d\n", fd)
+	// a closed service never redials
+	requests := srv.Requests(services.Afc_operation_file_info)
+	if _, err = afc.Stat("/testfile"); !errors.Is(err, services.ErrServiceClosed) {
+		t.Errorf("stat: got %v, want %v", err, services.ErrServiceClosed)
+	}
+	if _, err = afc.ReadFile(fd, make([]byte, 8)); !errors.Is(err, services.ErrServiceClosed) {
+		t.Errorf("read: got %v, want %v", err, services.ErrServiceClosed)
+	}
+	if err = afc.Health(); err == nil {
+		t.Error("a closed service is healthy")
+	}
+	if got := srv.Requests(services.Afc_operation_file_info); got != requests {
+		t.Errorf("%d requests sent once closed", got-requests)
+	}
 }
 
-func TestAfcServiceTruncate(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
+func TestAfcServiceChunkedTransfer(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	afc, err := services.NewFsyncWithOptions(srv.Addr(), services.Options{PoolSize: 1, BlockSize: 1024})
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer afc.Close()
 
-	fd, err := afc.OpenFile("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile", services.Afc_Mode_RW)
+	content := make([]byte, 10*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	f, err := afc.Create("/testfile")
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Printf("fd: %d\n", fd)
+	if n, err := f.Write(content); err != nil || n != len(content) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Requests(services.Afc_operation_file_write); got != 10 {
+		t.Errorf("%d write requests, want 10", got)
+	}
 
-	stat, _ := afc.Stat("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile")
-	fmt.Printf("old_size=%d\n", stat.Size())
-	err = afc.TruncateFile(fd, 5)
+	f, err = afc.Open("/testfile")
 	if err != nil {
 		t.Fatal(err)
 	}
-	stat, _ = afc.Stat("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile")
-	fmt.Printf("new_size=%d\n", stat.Size())
-
-	_ = afc.Truncate("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile", 3)
-	stat, _ = afc.Stat("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile")
-	fmt.Printf("new_new_size=%d\n", stat.Size())
-
-	err = afc.CloseFile(fd)
+	defer f.Close()
+	got, err := io.ReadAll(io.LimitReader(f, 4096))
 	if err != nil {
 		t.Fatal(err)
 	}
+	// io.ReadAll reads in small chunks, served from the read-ahead
+	if reads := srv.Requests(services.Afc_operation_file_read); reads != 4 {
+		t.Errorf("%d read requests, want 4", reads)
+	}
 
-	fmt.Printf("fd: %d\n", fd)
+	// seeking relative to the position accounts for the read-ahead
+	if _, err = f.Seek(-96, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got[:4096-96], rest...)
+	if string(got) != string(content) {
+		t.Fatal("content read back differs")
+	}
 }
 
-func TestAfcServiceSetFileTime(t *testing.T) {
-	afc, err := services.NewAfcService("127.0.0.1:5001")
+func TestAfcServiceBlockSizeRefused(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_set_socket_bs, Err: services.Afc_Err_OperationNotSupported})
+
+	afc, err := services.NewAfcServiceWithOptions(srv.Addr(), services.Options{PoolSize: 1, BlockSize: 1 << 20})
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer afc.Close()
 
-	new_time := time.Now()
-
-	stat, _ := afc.Stat("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile")
-	fmt.Printf("old_time=%s\n", stat.ModTime().Format("2006-01-02 15:04:05"))
-	err = afc.SetFileTime("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile", new_time)
+	fd, err := afc.OpenFile("/testfile", services.Afc_Mode_WR)
 	if err != nil {
 		t.Fatal(err)
 	}
-	stat, _ = afc.Stat("/Users/frosthuang/Work/code/ga-filebrowser/testdir/testfile")
-	fmt.Printf("new_time=%s\n", stat.ModTime().Format("2006-01-02 15:04:05"))
+	defer afc.CloseFile(fd)
+	if _, err = afc.WriteFile(fd, make([]byte, 256<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Requests(services.Afc_operation_file_write); got != 4 {
+		t.Errorf("%d write requests, want 4 of the fallback size", got)
+	}
+}
+
+func TestAfcFileReadWriteAt(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		srv := afctest.NewServer()
+		if legacy {
+			// devices before iOS 7 do not know the offset operations
+			srv.Inject(afctest.Fault{Op: services.AFC_OP_FILE_READ_OFFSET, Err: services.Afc_Err_UnknownPacketType})
+			srv.Inject(afctest.Fault{Op: services.AFC_OP_FILE_WRITE_OFFSET, Err: services.Afc_Err_UnknownPacketType})
+		}
+		writeTestFile(t, srv.Fs, "/testfile", "0123456789")
+
+		afc, err := services.NewFsyncWithOptions(srv.Addr(), services.Options{PoolSize: 1, BlockSize: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := afc.OpenFile("/testfile", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Seek(2, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		p := make([]byte, 6)
+		if n, err := f.ReadAt(p, 3); err != nil || string(p[:n]) != "345678" {
+			t.Errorf("legacy=%v: ReadAt = %q, %v", legacy, p[:n], err)
+		}
+		if n, err := f.ReadAt(p, 7); err != io.EOF || string(p[:n]) != "789" {
+			t.Errorf("legacy=%v: ReadAt at the end = %q, %v", legacy, p[:n], err)
+		}
+		if _, err = f.WriteAt([]byte("abcdef"), 1); err != nil {
+			t.Errorf("legacy=%v: WriteAt: %v", legacy, err)
+		}
+
+		// positional I/O leaves the file position alone
+		p = make([]byte, 2)
+		if _, err = io.ReadFull(f, p); err != nil || string(p) != "bc" {
+			t.Errorf("legacy=%v: Read after ReadAt = %q, %v", legacy, p, err)
+		}
+
+		_ = f.Close()
+		_ = afc.Close()
+		srv.Close()
+
+		if data, _ := afero.ReadFile(srv.Fs, "/testfile"); string(data) != "0abcdef789" {
+			t.Errorf("legacy=%v: content = %q", legacy, data)
+		}
+	}
 }
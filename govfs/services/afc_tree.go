@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+// isUnsupported reports whether the device rejected a request because it
+// does not know the operation.
+func isUnsupported(err error) bool {
+	return errors.Is(err, Afc_Err_UnknownPacketType) || errors.Is(err, Afc_Err_OperationNotSupported)
+}
+
+// supports reports whether op may be sent, that is the device did not
+// reject it before.
+func (conn *AfcService) supports(op uint64) bool {
+	_, rejected := conn.unsupportedOps.Load(op)
+	return !rejected
+}
+
+// markUnsupported records that the device rejected op with err, for op not
+// to be sent again when the device does not know it at all. Other
+// rejections may be specific to the path or file of the request.
+func (conn *AfcService) markUnsupported(op uint64, err error) {
+	if errors.Is(err, Afc_Err_UnknownPacketType) {
+		conn.unsupportedOps.Store(op, true)
+	}
+}
+
+func errNotSupported(op uint64) error {
+	return fmt.Errorf("%w: afc operation %#x", govfs.ErrNotSupported, op)
+}
+
+// optionalRequest runs a request for an operation older devices may not
+// know, failing with govfs.ErrNotSupported when the device rejects it. Once
+// the device reported it does not know op, it fails without a round-trip.
+func (conn *AfcService) optionalRequest(ctx context.Context, op uint64, data, payload []byte) (*AfcPacket, error) {
+	if !conn.supports(op) {
+		return nil, errNotSupported(op)
+	}
+	response, err := conn.request(ctx, op, data, payload)
+	if isUnsupported(err) {
+		conn.markUnsupported(op, err)
+		return nil, errNotSupported(op)
+	}
+	return response, err
+}
+
+func (conn *AfcService) GetSizeOfPathContents(path string) (int64, error) {
+	return conn.GetSizeOfPathContentsContext(context.Background(), path)
+}
+
+// GetSizeOfPathContentsContext returns the total size of the files below
+// path, computed by the device.
+func (conn *AfcService) GetSizeOfPathContentsContext(ctx context.Context, path string) (int64, error) {
+	response, err := conn.optionalRequest(ctx, AFC_OP_GET_SIZE_OF_PATH_CONTENTS, []byte(path), nil)
+	if err != nil {
+		return 0, pathError("du", path, err)
+	}
+	if len(response.Payload) < 8 {
+		return 0, fmt.Errorf("afc: invalid size of path contents response of %d bytes", len(response.Payload))
+	}
+	return int64(binary.LittleEndian.Uint64(response.Payload)), nil
+}
+
+func (conn *AfcService) OpenDir(path string) (uint64, error) {
+	return conn.OpenDirContext(context.Background(), path)
+}
+
+// OpenDirContext starts the enumeration of the directory path and returns a
+// handle pinned to the connection it was opened on, like OpenFileContext.
+func (conn *AfcService) OpenDirContext(ctx context.Context, path string) (uint64, error) {
+	if !conn.supports(AFC_OP_DIR_OPEN) {
+		return 0, errNotSupported(AFC_OP_DIR_OPEN)
+	}
+
+	c, err := conn.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	response, err := c.request(ctx, AFC_OP_DIR_OPEN, []byte(path), nil)
+	c.unlock()
+	if isUnsupported(err) {
+		conn.markUnsupported(AFC_OP_DIR_OPEN, err)
+		return 0, errNotSupported(AFC_OP_DIR_OPEN)
+	}
+	if err != nil {
+		return 0, pathError("opendir", path, err)
+	}
+	if len(response.HeaderPayload) < 8 {
+		return 0, fmt.Errorf("afc: invalid directory open response")
+	}
+
+	return conn.pin(c, binary.LittleEndian.Uint64(response.HeaderPayload)), nil
+}
+
+func (conn *AfcService) ReadDirEntries(dir uint64) ([]*StatInfo, error) {
+	return conn.ReadDirEntriesContext(context.Background(), dir)
+}
+
+// ReadDirEntriesContext returns the next batch of entries of an enumeration
+// opened with OpenDirContext, without "." and "..". It returns io.EOF once
+// the directory is exhausted.
+//
+// Every entry is sent as its name followed by the key/value pairs of its
+// stat, as for GetFileInfo, and an empty key.
+func (conn *AfcService) ReadDirEntriesContext(ctx context.Context, dir uint64) ([]*StatInfo, error) {
+	c, ddir, err := conn.lockFile(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, ddir)
+	response, err := c.request(ctx, AFC_OP_DIR_READ, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Payload) == 0 {
+		return nil, io.EOF
+	}
+
+	var (
+		infos  []*StatInfo
+		fields = bytes.Split(bytes.TrimSuffix(response.Payload, []byte{0}), []byte{0})
+	)
+	for i := 0; i < len(fields); {
+		name := string(fields[i])
+		statInfoMap := map[string]string{}
+		for i++; i < len(fields) && len(fields[i]) > 0; i += 2 {
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("afc: truncated directory entry %q", name)
+			}
+			statInfoMap[string(fields[i])] = string(fields[i+1])
+		}
+		i++ // the empty key closing the entry
+
+		if name != "." && name != ".." {
+			infos = append(infos, newStatInfo(name, statInfoMap))
+		}
+	}
+	return infos, nil
+}
+
+func (conn *AfcService) CloseDir(dir uint64) error {
+	return conn.CloseDirContext(context.Background(), dir)
+}
+
+func (conn *AfcService) CloseDirContext(ctx context.Context, dir uint64) error {
+	c, ddir, err := conn.lockFile(ctx, dir)
+	if err == ErrStaleHandle {
+		conn.unpin(dir)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
+	defer conn.unpin(dir)
+
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, ddir)
+	_, err = c.request(ctx, AFC_OP_DIR_CLOSE, data, nil)
+	return err
+}
+
+// RemoveTree removes name and everything below it with
+// AFC_OP_REMOVE_PATH_AND_CONTENTS.
+func (fs *Fsync) RemoveTree(name string) error {
+	return fs.AfcService.RemovePathAndContentsContext(fs.context(), name)
+}
+
+// TreeSize returns the size of the files below name with
+// AFC_OP_GET_SIZE_OF_PATH_CONTENTS.
+func (fs *Fsync) TreeSize(name string) (int64, error) {
+	return fs.AfcService.GetSizeOfPathContentsContext(fs.context(), name)
+}
+
+// EnumerateDirContext calls fn with the entries of the directory path,
+// reading them in batches from a single enumeration.
+func (conn *AfcService) EnumerateDirContext(ctx context.Context, path string, fn func(info *StatInfo) error) error {
+	dir, err := conn.OpenDirContext(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer conn.CloseDir(dir)
+
+	for {
+		infos, err := conn.ReadDirEntriesContext(ctx, dir)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if err = fn(info); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// EnumerateDir lists name with AFC_OP_DIR_OPEN, which returns the stat of
+// the entries along with their names.
+func (fs *Fsync) EnumerateDir(name string, fn func(info os.FileInfo) error) error {
+	return fs.AfcService.EnumerateDirContext(fs.context(), name, func(info *StatInfo) error {
+		return fn(info)
+	})
+}
@@ -0,0 +1,850 @@
+// Package afctest provides an in-process AFC server for tests. It speaks the
+// wire protocol of services.AfcPacket over a local TCP listener and serves
+// an afero.MemMapFs, so services.AfcService and everything built on top of
+// it can be exercised without a device.
+package afctest
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+)
+
+// Fault is a failure injected into the server. A request matching Op and
+// Path fails in the way described by Err, ShortRead or Disconnect instead of
+// being served.
+type Fault struct {
+	// Op is the operation to match; zero matches every operation.
+	Op uint64
+	// Path is the path to match; empty matches every path. Operations on
+	// file descriptors are matched by the path the file was opened with.
+	Path string
+	// Err is returned as the status of the request.
+	Err services.AfcErr
+	// ShortRead, when positive, caps the data returned by file reads.
+	ShortRead int
+	// Disconnect drops the connection without answering.
+	Disconnect bool
+	// Times is how many requests the fault applies to; zero means all.
+	Times int
+}
+
+// Server is an AFC server backed by an afero.Fs.
+type Server struct {
+	// Fs holds the files served. It may be populated directly by tests.
+	Fs afero.Fs
+	// Model and TotalBytes are reported by GetDeviceInfo; the free space is
+	// what the files in Fs leave of TotalBytes.
+	Model      string
+	TotalBytes uint64
+
+	listener net.Listener
+	mu       sync.Mutex
+	faults   []*Fault
+	requests map[uint64]int
+	// links maps the path of symbolic links to their target; a link is an
+	// empty file in Fs
+	links map[string]string
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer starts a server on a random local port backed by a new
+// afero.MemMapFs. It must be closed with Close.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("afctest: failed to listen: " + err.Error())
+	}
+	return NewServerWithListener(l)
+}
+
+// NewServerWithListener starts a server accepting the connections of l,
+// which it closes with Close.
+func NewServerWithListener(l net.Listener) *Server {
+	s := &Server{
+		Fs:         afero.NewMemMapFs(),
+		Model:      "iPhone",
+		TotalBytes: 64 << 30,
+		listener:   l,
+		requests:   map[uint64]int{},
+		links:      map[string]string{},
+		conns:      map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr is the address to dial to reach the server.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Inject adds a fault. Faults are matched in the order they were injected.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// Reset removes every injected fault.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns how many requests for op the server received.
+func (s *Server) Requests(op uint64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[op]
+}
+
+// CloseClientConnections drops every open connection, as a device being
+// unplugged would, while the server keeps accepting new ones.
+func (s *Server) CloseClientConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+// Close stops the server and drops every connection.
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.CloseClientConnections()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(c)
+		}()
+	}
+}
+
+// ServeConn serves c until it is closed, for clients that reach the server
+// without dialing it, such as through one end of a net.Pipe.
+func (s *Server) ServeConn(c net.Conn) {
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	s.handle(c)
+
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	_ = c.Close()
+}
+
+// fault counts a request and returns the first fault matching op and p,
+// consuming one of its uses.
+func (s *Server) fault(op uint64, p string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[op]++
+	for i, f := range s.faults {
+		if f.Op != 0 && f.Op != op {
+			continue
+		}
+		if f.Path != "" && f.Path != p {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// openFile is a descriptor handed out to a client.
+type openFile struct {
+	afero.File
+	path string
+}
+
+// openDir is a directory enumeration handed out to a client.
+type openDir struct {
+	path string
+	// names holds the entries not read yet
+	names []string
+}
+
+// session is the state of one client connection.
+type session struct {
+	s      *Server
+	conn   net.Conn
+	files  map[uint64]*openFile
+	dirs   map[uint64]*openDir
+	nextFd uint64
+}
+
+func (s *Server) handle(c net.Conn) {
+	sess := &session{s: s, conn: c, files: map[uint64]*openFile{}, dirs: map[uint64]*openDir{}}
+	defer func() {
+		for _, f := range sess.files {
+			_ = f.Close()
+		}
+	}()
+
+	for {
+		req, err := services.UnpackAfcPacket(c)
+		if err != nil {
+			return
+		}
+		if !sess.dispatch(&req) {
+			return
+		}
+	}
+}
+
+// reply is the answer to a request: either a status or an operation with
+// its header payload and payload.
+type reply struct {
+	op            uint64
+	headerPayload []byte
+	payload       []byte
+}
+
+func status(code services.AfcErr) reply {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(code))
+	return reply{op: services.Afc_operation_status, headerPayload: data}
+}
+
+// dispatch serves one request and reports whether the connection should be
+// kept open.
+func (sess *session) dispatch(req *services.AfcPacket) bool {
+	op := req.Header.Operation
+	p := sess.requestPath(req)
+
+	var rep reply
+	if f := sess.s.fault(op, p); f != nil {
+		switch {
+		case f.Disconnect:
+			return false
+		case f.ShortRead > 0 && op == services.Afc_operation_file_read:
+			rep = sess.read(req, f.ShortRead)
+		default:
+			rep = status(f.Err)
+		}
+	} else {
+		rep = sess.serve(req)
+	}
+
+	resp := services.AfcPacket{
+		Header: services.AfcPacketHeader{
+			Magic:         services.Afc_magic,
+			Entire_length: services.Afc_header_size + uint64(len(rep.headerPayload)+len(rep.payload)),
+			This_length:   services.Afc_header_size + uint64(len(rep.headerPayload)),
+			Packet_num:    req.Header.Packet_num,
+			Operation:     rep.op,
+		},
+		HeaderPayload: rep.headerPayload,
+		Payload:       rep.payload,
+	}
+	_, err := sess.conn.Write(resp.Pack())
+	return err == nil
+}
+
+// requestPath returns the path a request operates on, used to match faults.
+func (sess *session) requestPath(req *services.AfcPacket) string {
+	switch req.Header.Operation {
+	case services.Afc_operation_make_link:
+		if len(req.HeaderPayload) < 8 {
+			return ""
+		}
+		names := bytes.SplitN(req.HeaderPayload[8:], []byte{0}, 3) //nolint:gomnd
+		if len(names) < 2 {
+			return ""
+		}
+		return cleanPath(string(names[1]))
+	case services.Afc_operation_file_open, services.Afc_operation_set_file_time, services.Afc_operation_TRUNCATE:
+		if len(req.HeaderPayload) < 8 {
+			return ""
+		}
+		return cleanPath(cString(req.HeaderPayload[8:]))
+	case services.Afc_operation_get_file_Hash_range:
+		if len(req.HeaderPayload) < 16 { //nolint:gomnd
+			return ""
+		}
+		return cleanPath(cString(req.HeaderPayload[16:]))
+	case services.Afc_operation_read_dir, services.Afc_operation_file_info, services.Afc_operation_make_dir,
+		services.Afc_operation_remove_path, services.Afc_operation_rename_path,
+		services.AFC_OP_REMOVE_PATH_AND_CONTENTS, services.AFC_OP_GET_SIZE_OF_PATH_CONTENTS,
+		services.AFC_OP_DIR_OPEN, services.Afc_operation_write_file_atom:
+		return cleanPath(cString(req.HeaderPayload))
+	}
+
+	if len(req.HeaderPayload) >= 8 {
+		fd := binary.LittleEndian.Uint64(req.HeaderPayload)
+		if f, ok := sess.files[fd]; ok {
+			return f.path
+		}
+		if d, ok := sess.dirs[fd]; ok {
+			return d.path
+		}
+	}
+	return ""
+}
+
+//nolint:gocyclo
+func (sess *session) serve(req *services.AfcPacket) reply {
+	fs := sess.s.Fs
+	hp := req.HeaderPayload
+
+	switch req.Header.Operation {
+	case services.Afc_operation_read_dir:
+		return sess.readDir(cleanPath(cString(hp)))
+	case services.Afc_operation_file_info:
+		return sess.stat(cleanPath(cString(hp)))
+	case services.Afc_operation_make_dir:
+		return status(toAfcErr(fs.MkdirAll(cleanPath(cString(hp)), 0755))) //nolint:gomnd
+	case services.Afc_operation_remove_path:
+		return sess.remove(cleanPath(cString(hp)))
+	case services.AFC_OP_REMOVE_PATH_AND_CONTENTS:
+		p := cleanPath(cString(hp))
+		if err := fs.RemoveAll(p); err != nil {
+			return status(toAfcErr(err))
+		}
+		sess.s.dropLinks(p)
+		return status(services.Afc_Err_Success)
+	case services.Afc_operation_rename_path:
+		names := bytes.SplitN(hp, []byte{0}, 3) //nolint:gomnd
+		if len(names) < 2 {
+			return status(services.Afc_Err_InvalidArgument)
+		}
+		from, to := cleanPath(string(names[0])), cleanPath(string(names[1]))
+		if err := fs.Rename(from, to); err != nil {
+			return status(toAfcErr(err))
+		}
+		sess.s.mu.Lock()
+		if target, ok := sess.s.links[from]; ok {
+			delete(sess.s.links, from)
+			sess.s.links[to] = target
+		}
+		sess.s.mu.Unlock()
+		return status(services.Afc_Err_Success)
+	case services.Afc_operation_make_link:
+		return sess.makeLink(hp)
+	case services.AFC_OP_GET_SIZE_OF_PATH_CONTENTS:
+		return sess.sizeOfContents(cleanPath(cString(hp)))
+	case services.AFC_OP_DIR_OPEN:
+		return sess.openDir(cleanPath(cString(hp)))
+	case services.Afc_operation_get_file_Hash_range:
+		return sess.hashRange(hp)
+	case services.Afc_operation_write_file_atom:
+		p := sess.s.resolve(cleanPath(cString(hp)))
+		if info, err := fs.Stat(p); err == nil && info.IsDir() {
+			return status(services.Afc_Err_ObjectIsDir)
+		}
+		return status(toAfcErr(afero.WriteFile(fs, p, req.Payload, 0644))) //nolint:gomnd
+	case services.AFC_OP_DIR_READ, services.AFC_OP_DIR_CLOSE:
+		return sess.dirOp(req)
+	case services.Afc_operation_TRUNCATE:
+		if len(hp) < 8 {
+			return status(services.Afc_Err_InvalidArgument)
+		}
+		size := binary.LittleEndian.Uint64(hp)
+		f, err := fs.OpenFile(cleanPath(cString(hp[8:])), os.O_WRONLY, 0)
+		if err != nil {
+			return status(toAfcErr(err))
+		}
+		defer f.Close()
+		return status(toAfcErr(f.Truncate(int64(size))))
+	case services.Afc_operation_set_file_time:
+		if len(hp) < 8 {
+			return status(services.Afc_Err_InvalidArgument)
+		}
+		mtime := timeFromNanos(binary.LittleEndian.Uint64(hp))
+		return status(toAfcErr(fs.Chtimes(cleanPath(cString(hp[8:])), mtime, mtime)))
+	case services.Afc_operation_get_devinfo:
+		return sess.deviceInfo()
+	case services.Afc_operation_set_fs_bs, services.Afc_operation_set_socket_bs:
+		return status(services.Afc_Err_Success)
+	case services.Afc_operation_file_open:
+		return sess.open(req)
+	case services.Afc_operation_file_read:
+		return sess.read(req, 0)
+	}
+
+	// the remaining operations work on a descriptor
+	if len(hp) < 8 {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+	fd := binary.LittleEndian.Uint64(hp)
+	f, ok := sess.files[fd]
+	if !ok {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+
+	switch req.Header.Operation {
+	case services.Afc_operation_file_write:
+		_, err := f.Write(req.Payload)
+		return status(toAfcErr(err))
+	case services.Afc_operation_file_seek:
+		if len(hp) < 24 {
+			return status(services.Afc_Err_InvalidArgument)
+		}
+		whence := int(binary.LittleEndian.Uint64(hp[8:]))
+		offset := int64(binary.LittleEndian.Uint64(hp[16:]))
+		_, err := f.Seek(offset, whence)
+		return status(toAfcErr(err))
+	case services.Afc_operation_file_tell:
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return status(toAfcErr(err))
+		}
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(pos))
+		return reply{op: services.Afc_operation_file_tell_result, headerPayload: data}
+	case services.Afc_operation_file_set_size:
+		if len(hp) < 16 {
+			return status(services.Afc_Err_InvalidArgument)
+		}
+		return status(toAfcErr(f.Truncate(int64(binary.LittleEndian.Uint64(hp[8:])))))
+	case services.AFC_OP_FILE_READ_OFFSET:
+		if len(hp) < 24 {
+			return status(services.Afc_Err_InvalidArgument)
+		}
+		buf := make([]byte, binary.LittleEndian.Uint64(hp[16:]))
+		n, err := f.ReadAt(buf, int64(binary.LittleEndian.Uint64(hp[8:])))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return status(toAfcErr(err))
+		}
+		return reply{op: services.Afc_operation_data, payload: buf[:n]}
+	case services.AFC_OP_FILE_WRITE_OFFSET:
+		if len(hp) < 16 {
+			return status(services.Afc_Err_InvalidArgument)
+		}
+		// afero's in-memory files move the position on WriteAt, a device
+		// does not
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return status(toAfcErr(err))
+		}
+		if _, err = f.WriteAt(req.Payload, int64(binary.LittleEndian.Uint64(hp[8:]))); err != nil {
+			return status(toAfcErr(err))
+		}
+		_, err = f.Seek(pos, io.SeekStart)
+		return status(toAfcErr(err))
+	case services.Afc_operation_file_close:
+		delete(sess.files, fd)
+		return status(toAfcErr(f.Close()))
+	default:
+		return status(services.Afc_Err_UnknownPacketType)
+	}
+}
+
+func (sess *session) readDir(p string) reply {
+	names, err := afero.ReadDir(sess.s.Fs, p)
+	if err != nil {
+		return status(toAfcErr(err))
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(".\x00..\x00")
+	for _, info := range names {
+		buf.WriteString(info.Name())
+		buf.WriteByte(0)
+	}
+	return reply{op: services.Afc_operation_data, payload: buf.Bytes()}
+}
+
+func (sess *session) stat(p string) reply {
+	fields, err := sess.statFields(p)
+	if err != nil {
+		return status(toAfcErr(err))
+	}
+
+	buf := &bytes.Buffer{}
+	for _, f := range fields {
+		buf.WriteString(f)
+		buf.WriteByte(0)
+	}
+	return reply{op: services.Afc_operation_data, payload: buf.Bytes()}
+}
+
+// statFields returns the key/value pairs describing p.
+func (sess *session) statFields(p string) ([]string, error) {
+	info, err := sess.s.Fs.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	ifmt := "S_IFREG"
+	if info.IsDir() {
+		ifmt = "S_IFDIR"
+	}
+	target, isLink := sess.s.link(p)
+	if isLink {
+		ifmt = "S_IFLNK"
+		size = int64(len(target))
+	}
+	mtime := strconv.FormatInt(info.ModTime().UnixNano(), 10)
+	fields := []string{
+		"st_size", strconv.FormatInt(size, 10),
+		"st_blocks", strconv.FormatInt((size+511)/512, 10), //nolint:gomnd
+		"st_nlink", "1",
+		"st_ifmt", ifmt,
+		"st_mtime", mtime,
+		"st_birthtime", mtime,
+	}
+	if isLink {
+		fields = append(fields, "st_linktarget", target)
+	}
+	return fields, nil
+}
+
+func (sess *session) deviceInfo() reply {
+	var used uint64
+	_ = afero.Walk(sess.s.Fs, "/", func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			used += uint64(info.Size())
+		}
+		return nil
+	})
+
+	free := uint64(0)
+	if used < sess.s.TotalBytes {
+		free = sess.s.TotalBytes - used
+	}
+	fields := []string{
+		"Model", sess.s.Model,
+		"FSTotalBytes", strconv.FormatUint(sess.s.TotalBytes, 10),
+		"FSFreeBytes", strconv.FormatUint(free, 10),
+		"FSBlockSize", "4096",
+	}
+
+	buf := &bytes.Buffer{}
+	for _, f := range fields {
+		buf.WriteString(f)
+		buf.WriteByte(0)
+	}
+	return reply{op: services.Afc_operation_data, payload: buf.Bytes()}
+}
+
+func (sess *session) sizeOfContents(p string) reply {
+	var size int64
+	err := afero.Walk(sess.s.Fs, p, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return status(toAfcErr(err))
+	}
+
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(size))
+	return reply{op: services.Afc_operation_data, payload: data}
+}
+
+// hashRange serves the SHA-1 of a range of a file. The range is clamped to
+// the size of the file.
+func (sess *session) hashRange(hp []byte) reply {
+	if len(hp) < 16 { //nolint:gomnd
+		return status(services.Afc_Err_InvalidArgument)
+	}
+	start, end := binary.LittleEndian.Uint64(hp), binary.LittleEndian.Uint64(hp[8:])
+	p := sess.s.resolve(cleanPath(cString(hp[16:])))
+
+	info, err := sess.s.Fs.Stat(p)
+	if err != nil {
+		return status(toAfcErr(err))
+	}
+	if info.IsDir() {
+		return status(services.Afc_Err_ObjectIsDir)
+	}
+	if end > uint64(info.Size()) {
+		end = uint64(info.Size())
+	}
+	if start > end {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+
+	f, err := sess.s.Fs.Open(p)
+	if err != nil {
+		return status(toAfcErr(err))
+	}
+	defer f.Close()
+
+	h := sha1.New() //nolint:gosec
+	if _, err = io.Copy(h, io.NewSectionReader(f, int64(start), int64(end-start))); err != nil {
+		return status(toAfcErr(err))
+	}
+	return reply{op: services.Afc_operation_data, payload: h.Sum(nil)}
+}
+
+// dirBatchSize is the number of entries returned by one directory read.
+const dirBatchSize = 64
+
+func (sess *session) openDir(p string) reply {
+	infos, err := afero.ReadDir(sess.s.Fs, p)
+	if err != nil {
+		return status(toAfcErr(err))
+	}
+	d := &openDir{path: p, names: []string{".", ".."}}
+	for _, info := range infos {
+		d.names = append(d.names, info.Name())
+	}
+
+	sess.nextFd++
+	sess.dirs[sess.nextFd] = d
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, sess.nextFd)
+	return reply{op: services.AFC_OP_DIR_OPEN_RESULT, headerPayload: data}
+}
+
+func (sess *session) dirOp(req *services.AfcPacket) reply {
+	if len(req.HeaderPayload) < 8 {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+	fd := binary.LittleEndian.Uint64(req.HeaderPayload)
+	d, ok := sess.dirs[fd]
+	if !ok {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+	if req.Header.Operation == services.AFC_OP_DIR_CLOSE {
+		delete(sess.dirs, fd)
+		return status(services.Afc_Err_Success)
+	}
+
+	buf := &bytes.Buffer{}
+	for n := 0; n < dirBatchSize && len(d.names) > 0; n++ {
+		name := d.names[0]
+		d.names = d.names[1:]
+		fields, err := sess.statFields(path.Join(d.path, name))
+		if err != nil {
+			// removed since the directory was opened
+			continue
+		}
+		for _, f := range append([]string{name}, fields...) {
+			buf.WriteString(f)
+			buf.WriteByte(0)
+		}
+		buf.WriteByte(0)
+	}
+	return reply{op: services.Afc_operation_data, payload: buf.Bytes()}
+}
+
+func (sess *session) remove(p string) reply {
+	info, err := sess.s.Fs.Stat(p)
+	if err != nil {
+		return status(toAfcErr(err))
+	}
+	if info.IsDir() {
+		names, err := afero.ReadDir(sess.s.Fs, p)
+		if err != nil {
+			return status(toAfcErr(err))
+		}
+		if len(names) > 0 {
+			return status(services.Afc_Err_DirNotEmpty)
+		}
+	}
+	if err = sess.s.Fs.Remove(p); err != nil {
+		return status(toAfcErr(err))
+	}
+	sess.s.dropLinks(p)
+	return status(services.Afc_Err_Success)
+}
+
+// makeLink creates a symbolic link, or a hard link as a copy of its target.
+func (sess *session) makeLink(hp []byte) reply {
+	if len(hp) < 8 {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+	names := bytes.SplitN(hp[8:], []byte{0}, 3) //nolint:gomnd
+	if len(names) < 2 {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+	target, name := string(names[0]), cleanPath(string(names[1]))
+	if _, err := sess.s.Fs.Stat(name); err == nil {
+		return status(services.Afc_Err_ObjectExists)
+	}
+
+	switch services.LinkType(binary.LittleEndian.Uint64(hp)) {
+	case services.AFC_SYMLINK:
+		if err := afero.WriteFile(sess.s.Fs, name, nil, 0644); err != nil { //nolint:gomnd
+			return status(toAfcErr(err))
+		}
+		sess.s.mu.Lock()
+		sess.s.links[name] = target
+		sess.s.mu.Unlock()
+		return status(services.Afc_Err_Success)
+	case services.AFC_HARDLINK:
+		data, err := afero.ReadFile(sess.s.Fs, sess.s.resolve(cleanPath(target)))
+		if err != nil {
+			return status(toAfcErr(err))
+		}
+		return status(toAfcErr(afero.WriteFile(sess.s.Fs, name, data, 0644))) //nolint:gomnd
+	default:
+		return status(services.Afc_Err_InvalidArgument)
+	}
+}
+
+func (sess *session) open(req *services.AfcPacket) reply {
+	hp := req.HeaderPayload
+	if len(hp) < 8 {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+	p := sess.s.resolve(cleanPath(cString(hp[8:])))
+
+	var flag int
+	switch binary.LittleEndian.Uint64(hp) {
+	case services.Afc_Mode_RDONLY:
+		flag = os.O_RDONLY
+	case services.Afc_Mode_RW:
+		flag = os.O_RDWR | os.O_CREATE
+	case services.Afc_Mode_WRONLY:
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case services.Afc_Mode_WR:
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	case services.Afc_Mode_APPEND:
+		flag = os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	case services.Afc_Mode_RDAPPEND:
+		flag = os.O_RDWR | os.O_APPEND | os.O_CREATE
+	default:
+		return status(services.Afc_Err_InvalidArgument)
+	}
+
+	if info, err := sess.s.Fs.Stat(p); err == nil && info.IsDir() {
+		return status(services.Afc_Err_ObjectIsDir)
+	}
+	f, err := sess.s.Fs.OpenFile(p, flag, 0644) //nolint:gomnd
+	if err != nil {
+		return status(toAfcErr(err))
+	}
+
+	sess.nextFd++
+	sess.files[sess.nextFd] = &openFile{File: f, path: p}
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, sess.nextFd)
+	return reply{op: services.Afc_operation_file_open_result, headerPayload: data}
+}
+
+// read serves a file read, returning at most limit bytes when limit is
+// positive.
+func (sess *session) read(req *services.AfcPacket, limit int) reply {
+	hp := req.HeaderPayload
+	if len(hp) < 16 {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+	f, ok := sess.files[binary.LittleEndian.Uint64(hp)]
+	if !ok {
+		return status(services.Afc_Err_InvalidArgument)
+	}
+
+	size := int(binary.LittleEndian.Uint64(hp[8:]))
+	if limit > 0 && limit < size {
+		size = limit
+	}
+	buf := make([]byte, size)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return status(toAfcErr(err))
+	}
+	return reply{op: services.Afc_operation_data, payload: buf[:n]}
+}
+
+func (s *Server) link(p string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target, ok := s.links[p]
+	return target, ok
+}
+
+// dropLinks forgets the links at or below p.
+func (s *Server) dropLinks(p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.links {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			delete(s.links, name)
+		}
+	}
+}
+
+// maxLinkHops bounds the resolution of chained symbolic links.
+const maxLinkHops = 8
+
+// resolve follows the symbolic link at p, if any.
+func (s *Server) resolve(p string) string {
+	for i := 0; i < maxLinkHops; i++ {
+		target, ok := s.link(p)
+		if !ok {
+			break
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = cleanPath(target)
+	}
+	return p
+}
+
+// toAfcErr maps a filesystem error to the status a device would return.
+func toAfcErr(err error) services.AfcErr {
+	switch {
+	case err == nil:
+		return services.Afc_Err_Success
+	case os.IsNotExist(err):
+		return services.Afc_Err_ObjectNotFound
+	case os.IsExist(err):
+		return services.Afc_Err_ObjectExists
+	case os.IsPermission(err):
+		return services.Afc_Err_PermDenied
+	default:
+		return services.Afc_Err_UnknownError
+	}
+}
+
+func timeFromNanos(ns uint64) time.Time {
+	return time.Unix(0, int64(ns))
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func cleanPath(p string) string {
+	return path.Join("/", p)
+}
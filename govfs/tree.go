@@ -0,0 +1,135 @@
+package govfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/afero"
+)
+
+// ErrNotSupported is returned by the methods of a capability when the
+// backend, or the device behind it, cannot serve the request. The helpers
+// of this package then fall back to plain afero.Fs operations.
+var ErrNotSupported = errors.New("operation not supported by the filesystem")
+
+// TreeRemover is implemented by filesystems that delete a directory and
+// everything below it in a single request.
+type TreeRemover interface {
+	RemoveTree(name string) error
+}
+
+// TreeSizer is implemented by filesystems that compute the size of the
+// files below a directory themselves.
+type TreeSizer interface {
+	TreeSize(name string) (int64, error)
+}
+
+// DirEnumerator is implemented by filesystems that list a directory
+// together with the stat of every entry, so that no request is needed per
+// entry. fn is called for each entry as it arrives, in no particular order;
+// an error returned by fn stops the enumeration and is returned.
+type DirEnumerator interface {
+	EnumerateDir(name string, fn func(info os.FileInfo) error) error
+}
+
+// RemoveAll removes name and everything below it, in one request when fs is
+// a TreeRemover.
+func RemoveAll(fs afero.Fs, name string) error {
+	if remover, ok := fs.(TreeRemover); ok {
+		err := remover.RemoveTree(name)
+		if !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return fs.RemoveAll(name)
+}
+
+// TreeSize returns the total size of the files below name. It walks the
+// tree when fs is not a TreeSizer.
+func TreeSize(fs afero.Fs, name string) (int64, error) {
+	if sizer, ok := fs.(TreeSizer); ok {
+		size, err := sizer.TreeSize(name)
+		if !errors.Is(err, ErrNotSupported) {
+			return size, err
+		}
+	}
+
+	var size int64
+	err := Walk(fs, name, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// ReadDir returns the entries of the directory name sorted by name, using
+// the DirEnumerator of fs when available.
+func ReadDir(fs afero.Fs, name string) ([]os.FileInfo, error) {
+	if enumerator, ok := fs.(DirEnumerator); ok {
+		var infos []os.FileInfo
+		err := enumerator.EnumerateDir(name, func(info os.FileInfo) error {
+			infos = append(infos, info)
+			return nil
+		})
+		if !errors.Is(err, ErrNotSupported) {
+			sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+			return infos, err
+		}
+	}
+	return afero.ReadDir(fs, name)
+}
+
+// Walk walks the tree rooted at root like afero.Walk, but takes the stat of
+// the entries from the directory listings instead of issuing one Lstat per
+// entry. Symbolic links are reported, not followed.
+func Walk(fs afero.Fs, root string, fn filepath.WalkFunc) error {
+	info, err := lstatIfPossible(fs, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(fs, root, info, fn)
+	}
+	if errors.Is(err, filepath.SkipDir) || errors.Is(err, filepath.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func walk(fs afero.Fs, name string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(name, info, nil)
+	}
+
+	if err := fn(name, info, nil); err != nil {
+		return err
+	}
+	infos, err := ReadDir(fs, name)
+	if err != nil {
+		// report the directory a second time, with the error reading it
+		return fn(name, info, err)
+	}
+
+	for _, child := range infos {
+		err = walk(fs, path.Join(name, child.Name()), child, fn)
+		if err != nil && (!child.IsDir() || !errors.Is(err, filepath.SkipDir)) {
+			return err
+		}
+	}
+	return nil
+}
+
+func lstatIfPossible(fs afero.Fs, name string) (os.FileInfo, error) {
+	if lstater, ok := fs.(afero.Lstater); ok {
+		info, _, err := lstater.LstatIfPossible(name)
+		return info, err
+	}
+	return fs.Stat(name)
+}
@@ -0,0 +1,48 @@
+package govfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestWalk(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, name := range []string{"/a/1", "/a/skip/2", "/a/sub/3", "/b"} {
+		if err := afero.WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walkFn := func(visited *[]string) filepath.WalkFunc {
+		return func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Name() == "skip" {
+				return filepath.SkipDir
+			}
+			*visited = append(*visited, filepath.ToSlash(name))
+			return nil
+		}
+	}
+
+	var got, want []string
+	if err := Walk(fs, "/", walkFn(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.Walk(fs, "/", walkFn(&want)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk visited %v, afero.Walk %v", got, want)
+	}
+
+	size, err := TreeSize(fs, "/a")
+	if err != nil || size != int64(len("/a/1/a/skip/2/a/sub/3")) {
+		t.Errorf("TreeSize = %d %v", size, err)
+	}
+}
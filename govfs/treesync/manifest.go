@@ -0,0 +1,140 @@
+package treesync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+const manifestVersion = 1
+
+// manifestHeader is the first line of a manifest. Each following line
+// records the index of an action done.
+type manifestHeader struct {
+	Version  int           `json:"version"`
+	Mode     Mode          `json:"mode"`
+	Checksum bool          `json:"checksum"`
+	Window   time.Duration `json:"window"`
+	Exclude  []string      `json:"exclude"`
+	Actions  []Action      `json:"actions"`
+}
+
+type manifestDone struct {
+	Done int `json:"done"`
+}
+
+func newManifestHeader(opts *Options, actions []Action) *manifestHeader {
+	exclude := opts.Exclude
+	if exclude == nil {
+		exclude = []string{}
+	}
+	return &manifestHeader{
+		Version:  manifestVersion,
+		Mode:     opts.Mode,
+		Checksum: opts.Checksum,
+		Window:   opts.Window,
+		Exclude:  exclude,
+		Actions:  actions,
+	}
+}
+
+// readManifest returns the actions of the manifest name with those done
+// marked so. It returns nil when there is no manifest, when it was written
+// with other options or when all its actions are done.
+func readManifest(name string, opts *Options) ([]Action, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	var header manifestHeader
+	if err != nil || json.Unmarshal(line, &header) != nil {
+		// a manifest that cannot be read is one to start over
+		return nil, nil //nolint:nilerr
+	}
+	want := newManifestHeader(opts, header.Actions)
+	if !reflect.DeepEqual(&header, want) {
+		return nil, nil
+	}
+
+	actions := header.Actions
+	for {
+		line, err = r.ReadBytes('\n')
+		var done manifestDone
+		// lines cut short by a run killed while writing them are skipped
+		if json.Unmarshal(line, &done) == nil && done.Done >= 0 && done.Done < len(actions) {
+			actions[done.Done].Done = true
+		}
+		if err != nil {
+			break
+		}
+	}
+	for _, action := range actions {
+		if !action.Done {
+			return actions, nil
+		}
+	}
+	return nil, nil
+}
+
+// manifest records the progress of a run.
+type manifest struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func createManifest(name string, opts *Options, actions []Action) (*manifest, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil { //nolint:gomnd
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600) //nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{f: f, enc: json.NewEncoder(f)}
+	if err = m.enc.Encode(newManifestHeader(opts, actions)); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func appendManifest(name string) (*manifest, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600) //nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+	// end a line the previous run may have cut short
+	if _, err = io.WriteString(f, "\n"); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &manifest{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (m *manifest) done(i int) error {
+	return m.enc.Encode(manifestDone{Done: i})
+}
+
+func (m *manifest) remove() error {
+	_ = m.f.Close()
+	return os.Remove(m.f.Name())
+}
+
+func (m *manifest) Close() error {
+	return m.f.Close()
+}
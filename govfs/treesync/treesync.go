@@ -0,0 +1,414 @@
+// Package treesync makes a directory tree match another one, transferring
+// only the files that differ. The trees are afero filesystems, so the same
+// engine syncs a local directory to a device and back.
+package treesync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+// Mode selects what a sync is allowed to change on the destination.
+type Mode string
+
+const (
+	// ModeUpdate copies the files missing on the destination or older
+	// there, and never deletes anything.
+	ModeUpdate Mode = "update"
+	// ModeMirror makes the destination identical to the source, deleting
+	// the files the source does not have.
+	ModeMirror Mode = "mirror"
+)
+
+// Options configure a sync.
+type Options struct {
+	Mode Mode
+	// Checksum compares the content of the files having the same size,
+	// instead of their modification time.
+	Checksum bool
+	// Window is the largest difference between two modification times
+	// that are still considered equal.
+	Window time.Duration
+	// Exclude holds glob patterns, as accepted by path.Match, of the files
+	// and directories left alone on both sides. A pattern with a slash is
+	// matched against the path relative to the root, the others against
+	// the base name.
+	Exclude []string
+	// Manifest is the local file recording the progress of the sync, so
+	// that a run interrupted can be resumed. No manifest is kept when it
+	// is empty.
+	Manifest string
+	// Handler, when set, is called with the size of every chunk copied.
+	Handler func(size uint64, status string)
+}
+
+func (o *Options) validate() error {
+	switch o.Mode {
+	case "":
+		o.Mode = ModeUpdate
+	case ModeUpdate, ModeMirror:
+	default:
+		return fmt.Errorf("unknown sync mode %q", o.Mode)
+	}
+	for _, pattern := range o.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("exclude %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (o *Options) excluded(rel string) bool {
+	for _, pattern := range o.Exclude {
+		name := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			pattern, name = strings.TrimPrefix(pattern, "/"), rel
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Op is the kind of an Action.
+type Op string
+
+const (
+	OpDelete Op = "delete"
+	OpMkdir  Op = "mkdir"
+	OpCopy   Op = "copy"
+)
+
+// Action is one change to the destination. Path is relative to the roots.
+type Action struct {
+	Op   Op     `json:"op"`
+	Path string `json:"path"`
+	Size int64  `json:"size,omitempty"`
+	Done bool   `json:"done,omitempty"`
+}
+
+// Plan is the work needed to bring the destination in line with the
+// source. Deletions come first, then directories before their contents.
+type Plan struct {
+	Actions []Action
+	// Resumed is set when the plan was read back from the manifest of an
+	// interrupted run.
+	Resumed bool
+
+	src, dst afero.Fs
+	opts     Options
+}
+
+// NewPlan compares the trees rooted at src and dst. When opts.Manifest
+// holds the plan of an interrupted run made with the same options, that
+// plan is returned instead, with the actions already done marked so.
+func NewPlan(ctx context.Context, src, dst afero.Fs, opts Options) (*Plan, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	plan := &Plan{src: src, dst: dst, opts: opts}
+
+	if opts.Manifest != "" {
+		actions, err := readManifest(opts.Manifest, &opts)
+		if err != nil {
+			return nil, err
+		}
+		if actions != nil {
+			plan.Actions, plan.Resumed = actions, true
+			return plan, nil
+		}
+	}
+
+	src, dst = govfs.WithContext(src, ctx), govfs.WithContext(dst, ctx)
+	srcTree, err := scan(src, &opts, false)
+	if err != nil {
+		return nil, err
+	}
+	dstTree, err := scan(dst, &opts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var copies []Action
+	var conflict string
+	for _, rel := range srcTree.names {
+		if conflict != "" && strings.HasPrefix(rel, conflict+"/") {
+			continue
+		}
+		s, d := srcTree.infos[rel], dstTree.infos[rel]
+		switch {
+		case d != nil && s.IsDir() != d.IsDir():
+			if opts.Mode != ModeMirror {
+				conflict = rel
+				continue
+			}
+			// replaced, once deleted with the files the source lacks
+			d = nil
+		case d != nil && !s.IsDir():
+			same, err := same(src, dst, rel, s, d, &opts)
+			if err != nil {
+				return nil, err
+			}
+			if same || (opts.Mode == ModeUpdate && d.ModTime().After(s.ModTime().Add(opts.Window))) {
+				continue
+			}
+		}
+
+		switch {
+		case s.IsDir() && d == nil:
+			copies = append(copies, Action{Op: OpMkdir, Path: rel})
+		case !s.IsDir():
+			copies = append(copies, Action{Op: OpCopy, Path: rel, Size: s.Size()})
+		}
+	}
+
+	if opts.Mode == ModeMirror {
+		var deleted string
+		for _, rel := range dstTree.names {
+			if deleted != "" && strings.HasPrefix(rel, deleted+"/") {
+				continue
+			}
+			if s, ok := srcTree.infos[rel]; !ok || s.IsDir() != dstTree.infos[rel].IsDir() {
+				plan.Actions = append(plan.Actions, Action{Op: OpDelete, Path: rel})
+				deleted = rel
+			}
+		}
+	}
+	plan.Actions = append(plan.Actions, copies...)
+	return plan, nil
+}
+
+// same tells whether the files rel of both trees have the same content.
+func same(src, dst afero.Fs, rel string, s, d os.FileInfo, opts *Options) (bool, error) {
+	if s.Size() != d.Size() {
+		return false, nil
+	}
+	if !opts.Checksum {
+		diff := s.ModTime().Sub(d.ModTime())
+		return diff <= opts.Window && diff >= -opts.Window, nil
+	}
+	srcSum, err := checksum(src, "/"+rel, s.Size())
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := checksum(dst, "/"+rel, d.Size())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcSum, dstSum), nil
+}
+
+// Pending returns the actions not done yet.
+func (p *Plan) Pending() []Action {
+	var pending []Action
+	for _, action := range p.Actions {
+		if !action.Done {
+			pending = append(pending, action)
+		}
+	}
+	return pending
+}
+
+// Size returns the number of bytes the pending actions copy.
+func (p *Plan) Size() int64 {
+	var size int64
+	for _, action := range p.Pending() {
+		size += action.Size
+	}
+	return size
+}
+
+// Run carries out the pending actions, recording each one in the manifest
+// once done. The manifest is removed when the plan is complete.
+func (p *Plan) Run(ctx context.Context) error {
+	src, dst := govfs.WithContext(p.src, ctx), govfs.WithContext(p.dst, ctx)
+
+	var m *manifest
+	if p.opts.Manifest != "" {
+		var err error
+		if p.Resumed {
+			m, err = appendManifest(p.opts.Manifest)
+		} else {
+			m, err = createManifest(p.opts.Manifest, &p.opts, p.Actions)
+		}
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+	}
+
+	if len(p.Pending()) > 0 {
+		if err := dst.MkdirAll("/", 0755); err != nil { //nolint:gomnd
+			return err
+		}
+	}
+	for i := range p.Actions {
+		action := &p.Actions[i]
+		if action.Done {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.do(src, dst, action); err != nil {
+			return fmt.Errorf("%s %s: %w", action.Op, action.Path, err)
+		}
+		action.Done = true
+		if m != nil {
+			if err := m.done(i); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m != nil {
+		return m.remove()
+	}
+	return nil
+}
+
+func (p *Plan) do(src, dst afero.Fs, action *Action) error {
+	name := "/" + action.Path
+	switch action.Op {
+	case OpDelete:
+		err := govfs.RemoveAll(dst, name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	case OpMkdir:
+		return dst.MkdirAll(name, 0755) //nolint:gomnd
+	case OpCopy:
+		return p.copy(src, dst, name)
+	default:
+		return fmt.Errorf("unknown action %q", action.Op)
+	}
+}
+
+// copy copies the file name and its permissions, as they are now on the
+// source, without exposing partial content on the destination. A file gone
+// from the source since the plan was made is skipped.
+func (p *Plan) copy(src, dst afero.Fs, name string) error {
+	f, err := src.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = f
+	if p.opts.Handler != nil {
+		r = &progressReader{r: f, handler: p.opts.Handler}
+	}
+	perm := info.Mode().Perm()
+	if err = govfs.WriteFile(dst, name, r, perm); err != nil {
+		return err
+	}
+	// a replaced file keeps its own mode
+	if err = dst.Chmod(name, perm); err != nil {
+		return err
+	}
+	return dst.Chtimes(name, info.ModTime(), info.ModTime())
+}
+
+// Sync brings the tree rooted at dst in line with the one rooted at src.
+func Sync(ctx context.Context, src, dst afero.Fs, opts Options) (*Plan, error) {
+	plan, err := NewPlan(ctx, src, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	return plan, plan.Run(ctx)
+}
+
+// tree is the result of a scan.
+type tree struct {
+	// names are the paths relative to the root, parents before children.
+	names []string
+	infos map[string]os.FileInfo
+}
+
+// scan lists the files and directories below the root of fs, leaving out
+// the excluded ones and the symbolic links. A missing root is empty when
+// missingOK is set.
+func scan(fs afero.Fs, opts *Options, missingOK bool) (*tree, error) {
+	t := &tree{infos: map[string]os.FileInfo{}}
+	err := govfs.Walk(fs, "/", func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			if name == "/" && missingOK && errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		rel := strings.TrimPrefix(name, "/")
+		if rel == "" {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if opts.excluded(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		t.names = append(t.names, rel)
+		t.infos[rel] = info
+		return nil
+	})
+	return t, err
+}
+
+// checksum returns the SHA-1 of name, computed by fs when it can.
+func checksum(fs afero.Fs, name string, size int64) ([]byte, error) {
+	if hasher, ok := fs.(govfs.Hasher); ok {
+		sum, err := hasher.Hash(name, "sha1", 0, size)
+		if !errors.Is(err, govfs.ErrNotSupported) {
+			return sum, err
+		}
+	}
+
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha1.New() //nolint:gosec
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+type progressReader struct {
+	r       io.Reader
+	handler func(size uint64, status string)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.handler(uint64(n), "Syncing")
+	}
+	return n, err
+}
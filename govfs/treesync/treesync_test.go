@@ -0,0 +1,222 @@
+package treesync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var modTime = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func writeFiles(t *testing.T, fs afero.Fs, files map[string]string, mtime time.Time) {
+	t.Helper()
+	for name, content := range files {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFiles(t *testing.T, fs afero.Fs) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := afero.Walk(fs, "/", func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := afero.ReadFile(fs, name)
+		files[filepath.ToSlash(name)] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func actionPaths(actions []Action) []string {
+	paths := []string{}
+	for _, action := range actions {
+		paths = append(paths, string(action.Op)+" "+action.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestSyncMirror(t *testing.T) {
+	src, dst := afero.NewMemMapFs(), afero.NewMemMapFs()
+	writeFiles(t, src, map[string]string{"/a/1": "1", "/a/2": "two", "/b": "b", "/build.log": "src"}, modTime)
+	writeFiles(t, dst, map[string]string{"/a/2": "stale", "/b/c": "c", "/old/x": "x", "/build.log": "dst"}, modTime)
+
+	opts := Options{Mode: ModeMirror, Exclude: []string{"*.log"}}
+	plan, err := Sync(context.Background(), src, dst, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"copy a/1", "copy a/2", "copy b", "delete b", "delete old"}
+	if got := actionPaths(plan.Actions); !reflect.DeepEqual(got, want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+	if plan.Actions[0].Op != OpDelete || plan.Actions[1].Op != OpDelete {
+		t.Errorf("deletions do not come first: %v", plan.Actions)
+	}
+
+	wantFiles := map[string]string{"/a/1": "1", "/a/2": "two", "/b": "b", "/build.log": "dst"}
+	if got := readFiles(t, dst); !reflect.DeepEqual(got, wantFiles) {
+		t.Errorf("destination = %v, want %v", got, wantFiles)
+	}
+	info, err := dst.Stat("/a/1")
+	if err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("copy did not keep the modification time: %v %v", info, err)
+	}
+
+	plan, err = NewPlan(context.Background(), src, dst, opts)
+	if err != nil || len(plan.Actions) != 0 {
+		t.Errorf("second plan = %v %v, want nothing to do", plan.Actions, err)
+	}
+}
+
+func TestSyncUpdate(t *testing.T) {
+	src, dst := afero.NewMemMapFs(), afero.NewMemMapFs()
+	writeFiles(t, src, map[string]string{"/newer": "src", "/older": "src", "/dir/f": "f"}, modTime)
+	writeFiles(t, dst, map[string]string{"/older": "device", "/extra": "extra"}, modTime.Add(time.Hour))
+	writeFiles(t, dst, map[string]string{"/newer": "old"}, modTime.Add(-time.Hour))
+
+	if _, err := Sync(context.Background(), src, dst, Options{Mode: ModeUpdate}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"/newer": "src", "/older": "device", "/extra": "extra", "/dir/f": "f"}
+	if got := readFiles(t, dst); !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
+
+func TestSyncPermissions(t *testing.T) {
+	src, dst := afero.NewMemMapFs(), afero.NewMemMapFs()
+	writeFiles(t, src, map[string]string{"/run.sh": "new", "/tool": "tool"}, modTime)
+	writeFiles(t, dst, map[string]string{"/run.sh": "old"}, modTime.Add(-time.Hour))
+	for name, mode := range map[string]os.FileMode{"/run.sh": 0755, "/tool": 0700} {
+		if err := src.Chmod(name, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Sync(context.Background(), src, dst, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{"/run.sh": 0755, "/tool": 0700} {
+		info, err := dst.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("mode of %s = %v, want %v", name, info.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestSyncChecksum(t *testing.T) {
+	src, dst := afero.NewMemMapFs(), afero.NewMemMapFs()
+	writeFiles(t, src, map[string]string{"/f": "abc", "/same": "xyz"}, modTime)
+	writeFiles(t, dst, map[string]string{"/f": "abd", "/same": "xyz"}, modTime)
+
+	plan, err := NewPlan(context.Background(), src, dst, Options{})
+	if err != nil || len(plan.Actions) != 0 {
+		t.Errorf("plan by size and time = %v %v, want nothing to do", plan.Actions, err)
+	}
+	plan, err = NewPlan(context.Background(), src, dst, Options{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := actionPaths(plan.Actions), []string{"copy f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("plan by checksum = %v, want %v", got, want)
+	}
+}
+
+// failingFs fails to open one file.
+type failingFs struct {
+	afero.Fs
+	fail  string
+	opens []string
+}
+
+func (fs *failingFs) Open(name string) (afero.File, error) {
+	if name == fs.fail {
+		return nil, errors.New("connection lost")
+	}
+	fs.opens = append(fs.opens, name)
+	return fs.Fs.Open(name)
+}
+
+func TestSyncResume(t *testing.T) {
+	mem, dst := afero.NewMemMapFs(), afero.NewMemMapFs()
+	writeFiles(t, mem, map[string]string{"/1": "1", "/2": "2", "/3": "3"}, modTime)
+	src := &failingFs{Fs: mem, fail: "/2"}
+	opts := Options{Manifest: filepath.Join(t.TempDir(), "sync.json")}
+
+	if _, err := Sync(context.Background(), src, dst, opts); err == nil {
+		t.Fatal("sync did not fail")
+	}
+	if _, err := os.Stat(opts.Manifest); err != nil {
+		t.Fatalf("manifest of the interrupted run: %v", err)
+	}
+
+	// a file changed meanwhile is left to the next full comparison
+	writeFiles(t, mem, map[string]string{"/1": "changed"}, modTime.Add(time.Hour))
+	src.fail, src.opens = "", nil
+	plan, err := NewPlan(context.Background(), src, dst, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Resumed {
+		t.Error("plan was not resumed")
+	}
+	if got, want := actionPaths(plan.Pending()), []string{"copy 2", "copy 3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pending = %v, want %v", got, want)
+	}
+	if err = plan.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/2", "/3"}; !reflect.DeepEqual(src.opens, want) {
+		t.Errorf("resumed run opened %v, want %v", src.opens, want)
+	}
+	if _, err = os.Stat(opts.Manifest); !os.IsNotExist(err) {
+		t.Errorf("manifest of a complete run: %v", err)
+	}
+
+	// other options do not resume the manifest
+	if _, err = Sync(context.Background(), &failingFs{Fs: mem, fail: "/1"}, dst, opts); err == nil {
+		t.Fatal("sync did not fail")
+	}
+	opts.Checksum = true
+	plan, err = NewPlan(context.Background(), src, dst, opts)
+	if err != nil || plan.Resumed {
+		t.Errorf("plan with other options: resumed %v, %v", plan.Resumed, err)
+	}
+}
+
+func TestSyncMissingRoot(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	writeFiles(t, mem, map[string]string{"/src/f": "f"}, modTime)
+	src, dst := afero.NewBasePathFs(mem, "/src"), afero.NewBasePathFs(mem, "/dst")
+
+	if _, err := Sync(context.Background(), dst, src, Options{Mode: ModeMirror}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("sync from a missing root: %v", err)
+	}
+	if _, err := Sync(context.Background(), src, dst, Options{Mode: ModeMirror}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFiles(t, dst), map[string]string{"/f": "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package govfs
+
+import (
+	"context"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/spf13/afero"
+)
+
+// Usage describes the capacity of the storage holding a path.
+type Usage struct {
+	Total     uint64 `json:"total"`
+	Free      uint64 `json:"free"`
+	BlockSize uint64 `json:"blockSize,omitempty"`
+	// Model names the device, when the storage is on one.
+	Model string `json:"model,omitempty"`
+}
+
+// Used returns the space taken on the storage.
+func (u *Usage) Used() uint64 {
+	if u.Free > u.Total {
+		return 0
+	}
+	return u.Total - u.Free
+}
+
+// UsageProvider is implemented by filesystems that report the capacity of
+// the storage behind them.
+type UsageProvider interface {
+	Usage(name string) (*Usage, error)
+}
+
+// OsFs is the local filesystem, reporting the usage of its partitions.
+type OsFs struct {
+	afero.OsFs
+	ctx context.Context
+}
+
+// NewOsFs returns the local filesystem.
+func NewOsFs() *OsFs {
+	return &OsFs{ctx: context.Background()}
+}
+
+// WithContext bounds the usage queries to ctx.
+func (fs *OsFs) WithContext(ctx context.Context) afero.Fs {
+	return &OsFs{ctx: ctx}
+}
+
+// Usage reports the partition holding name.
+func (fs *OsFs) Usage(name string) (*Usage, error) {
+	stat, err := disk.UsageWithContext(fs.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &Usage{Total: stat.Total, Free: stat.Free}, nil
+}
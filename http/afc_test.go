@@ -0,0 +1,1072 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/device"
+	"github.com/filebrowser/filebrowser/v2/diskcache"
+	"github.com/filebrowser/filebrowser/v2/govfs/afcfs"
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/govfs/services/afctest"
+	"github.com/filebrowser/filebrowser/v2/jobs"
+	"github.com/filebrowser/filebrowser/v2/settings"
+	"github.com/filebrowser/filebrowser/v2/storage/bolt"
+	"github.com/filebrowser/filebrowser/v2/trash"
+	"github.com/filebrowser/filebrowser/v2/users"
+	"github.com/filebrowser/filebrowser/v2/versions"
+)
+
+// newAfcTestServer serves the API for a user whose filesystem is the device
+// filesystem of --ga mode, backed by an in-process AFC server.
+func newAfcTestServer(t *testing.T) (srv *afctest.Server, ts *httptest.Server, token string) {
+	t.Helper()
+	return newAfcTestServerWithPerm(t, users.Permissions{Create: true, Modify: true, Delete: true, Rename: true, Download: true})
+}
+
+// newAfcTestServerWithPerm is newAfcTestServer for a user with perm.
+func newAfcTestServerWithPerm(t *testing.T, perm users.Permissions) (srv *afctest.Server, ts *httptest.Server, token string) {
+	t.Helper()
+	return newAfcTestServerWithSettings(t, perm, &settings.Server{})
+}
+
+// newAfcTestServerWithSettings is newAfcTestServerWithPerm for a server
+// run with the given settings.
+func newAfcTestServerWithSettings(t *testing.T, perm users.Permissions, server *settings.Server) (srv *afctest.Server, ts *httptest.Server, token string) {
+	t.Helper()
+
+	srv = afctest.NewServer()
+	t.Cleanup(srv.Close)
+
+	vfs, err := afcfs.NewVfsWithOptions(srv.Addr(), services.Options{
+		PoolSize:         2,
+		ReconnectBackoff: time.Millisecond,
+		OpTimeout:        5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to afc server: %v", err)
+	}
+
+	db, err := storm.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil { //nolint:govet
+			t.Errorf("failed to close db: %v", err)
+		}
+	})
+
+	storage, err := bolt.NewStorage(db)
+	if err != nil {
+		t.Fatalf("failed to get storage: %v", err)
+	}
+	user := &users.User{
+		Username: "username",
+		Password: "pw",
+		Perm:     perm,
+	}
+	if err = storage.Users.Save(user); err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	key := []byte("key")
+	if err = storage.Settings.Save(&settings.Settings{Key: key}); err != nil {
+		t.Fatalf("failed to save settings: %v", err)
+	}
+	storage.Users = &customFSUser{Store: storage.Users, fs: vfs}
+
+	handler, err := NewHandler(nil, diskcache.NewNoOp(), storage, server, fstest.MapFS{})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	ts = httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	claims := &authToken{
+		User: userInfo{ID: user.ID, Perm: user.Perm},
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenExpirationTime)),
+		},
+	}
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return srv, ts, token
+}
+
+func doAfcRequest(t *testing.T, ts *httptest.Server, token, method, path, body string) (int, string) {
+	t.Helper()
+	r, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to construct request: %v", err)
+	}
+	r.Header.Set("X-Auth", token)
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestAfcResourceHandlers(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServer(t)
+	if err := afero.WriteFile(srv.Fs, "/docs/readme.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := doAfcRequest(t, ts, token, http.MethodGet, "/api/resources/docs/", "")
+	if status != http.StatusOK {
+		t.Fatalf("listing: expected status code 200, got %d: %s", status, body)
+	}
+	var listing struct {
+		Items []struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(body), &listing); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+	if len(listing.Items) != 1 || listing.Items[0].Name != "readme.txt" || listing.Items[0].Size != 5 {
+		t.Fatalf("unexpected listing %+v", listing.Items)
+	}
+
+	status, body = doAfcRequest(t, ts, token, http.MethodGet, "/api/raw/docs/readme.txt", "")
+	if status != http.StatusOK || body != "hello" {
+		t.Fatalf("download: got %d %q", status, body)
+	}
+
+	status, body = doAfcRequest(t, ts, token, http.MethodPost, "/api/resources/docs/new.txt", "uploaded")
+	if status != http.StatusOK {
+		t.Fatalf("upload: expected status code 200, got %d: %s", status, body)
+	}
+	if data, err := afero.ReadFile(srv.Fs, "/docs/new.txt"); err != nil || string(data) != "uploaded" {
+		t.Fatalf("uploaded file: %q %v", data, err)
+	}
+
+	status, _ = doAfcRequest(t, ts, token, http.MethodDelete, "/api/resources/docs/new.txt", "")
+	if status != http.StatusOK {
+		t.Fatalf("delete: expected status code 200, got %d", status)
+	}
+	if exists, _ := afero.Exists(srv.Fs, "/docs/new.txt"); exists {
+		t.Fatal("deleted file still exists")
+	}
+}
+
+func TestAfcChecksum(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServer(t)
+	if err := afero.WriteFile(srv.Fs, "/docs/readme.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum := func(algo string) (sum string, remote bool) {
+		status, body := doAfcRequest(t, ts, token, http.MethodGet, "/api/resources/docs/readme.txt?checksum="+algo, "")
+		if status != http.StatusOK {
+			t.Fatalf("%s checksum: expected status code 200, got %d: %s", algo, status, body)
+		}
+		var file struct {
+			Checksums       map[string]string `json:"checksums"`
+			RemoteChecksums map[string]bool   `json:"remoteChecksums"`
+		}
+		if err := json.Unmarshal([]byte(body), &file); err != nil {
+			t.Fatalf("failed to decode file: %v", err)
+		}
+		return file.Checksums[algo], file.RemoteChecksums[algo]
+	}
+
+	if sum, remote := checksum("sha1"); sum != "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d" || !remote {
+		t.Errorf("sha1 checksum: %s, remote %v", sum, remote)
+	}
+	if n := srv.Requests(services.Afc_operation_get_file_Hash_range); n != 1 {
+		t.Errorf("the file was hashed by the device %d times", n)
+	}
+
+	// the device only hashes with sha1
+	if sum, remote := checksum("md5"); sum != "5d41402abc4b2a76b9719d911017c592" || remote {
+		t.Errorf("md5 checksum: %s, remote %v", sum, remote)
+	}
+}
+
+func TestAfcTusUpload(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServer(t)
+
+	tus := func(method string, header http.Header, body string) *http.Response {
+		t.Helper()
+		r, err := http.NewRequest(method, ts.URL+"/api/tus/docs/video.mov?override=true", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to construct request: %v", err)
+		}
+		r.Header = header
+		r.Header.Set("X-Auth", token)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	chunk := func(offset, body string) *http.Response {
+		return tus(http.MethodPatch, http.Header{
+			"Content-Type":  {"application/offset+octet-stream"},
+			"Upload-Offset": {offset},
+		}, body)
+	}
+
+	if resp := tus(http.MethodPost, http.Header{"Upload-Length": {"10"}}, ""); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: expected status code 201, got %d", resp.StatusCode)
+	}
+	if resp := chunk("0", "01234"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("first chunk: expected status code 204, got %d", resp.StatusCode)
+	}
+	if exists, _ := afero.Exists(srv.Fs, "/docs/video.mov"); exists {
+		t.Error("the target exists before the upload is complete")
+	}
+	resp := tus(http.MethodHead, http.Header{}, "")
+	if offset, length := resp.Header.Get("Upload-Offset"), resp.Header.Get("Upload-Length"); offset != "5" || length != "10" {
+		t.Errorf("head: offset %s, length %s", offset, length)
+	}
+
+	if resp = chunk("5", "56789"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("last chunk: expected status code 204, got %d", resp.StatusCode)
+	}
+	if data, _ := afero.ReadFile(srv.Fs, "/docs/video.mov"); string(data) != "0123456789" {
+		t.Errorf("content after the upload: %q", data)
+	}
+	if infos, _ := afero.ReadDir(srv.Fs, "/docs"); len(infos) != 1 {
+		t.Errorf("staging files were left: %d entries", len(infos))
+	}
+
+	// the staging files of uploads lost to a restart are removed once stale
+	stale, fresh := "/docs/.video.mov.0123456789ab.upload", "/docs/.video.mov.ba9876543210.upload"
+	for _, name := range []string{stale, fresh} {
+		if err := afero.WriteFile(srv.Fs, name, []byte("01"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-tusUploadTTL - time.Hour)
+	if err := srv.Fs.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if resp := tus(http.MethodPost, http.Header{"Upload-Length": {"10"}}, ""); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("second create: expected status code 201, got %d", resp.StatusCode)
+	}
+	if exists, _ := afero.Exists(srv.Fs, stale); exists {
+		t.Error("the stale staging file was not removed")
+	}
+	if exists, _ := afero.Exists(srv.Fs, fresh); !exists {
+		t.Error("a recent staging file was removed")
+	}
+}
+
+func TestTusUploadsExpire(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	uploads := newTusUploads()
+	for _, name := range []string{"/idle.bin", "/active.bin"} {
+		staging := tusStagingName(name)
+		if err := afero.WriteFile(fs, staging, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		uploads.set(name, &tusUpload{staging: staging, length: 10, fs: fs, updated: time.Now()})
+	}
+	uploads.get("/idle.bin").updated = time.Now().Add(-tusUploadTTL - time.Minute)
+
+	uploads.expire()
+	if uploads.get("/idle.bin") != nil {
+		t.Error("the idle upload was not forgotten")
+	}
+	if exists, _ := afero.Exists(fs, tusStagingName("/idle.bin")); exists {
+		t.Error("the staging file of the idle upload was not removed")
+	}
+	if uploads.get("/active.bin") == nil {
+		t.Error("the active upload was forgotten")
+	}
+}
+
+func TestAfcResourceHandlersDeviceErrors(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServer(t)
+	if err := afero.WriteFile(srv.Fs, "/docs/readme.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Inject(afctest.Fault{Op: services.AFC_OP_REMOVE_PATH_AND_CONTENTS, Err: services.Afc_Err_PermDenied})
+	status, _ := doAfcRequest(t, ts, token, http.MethodDelete, "/api/resources/docs/readme.txt", "")
+	if status < 400 {
+		t.Fatalf("delete with a failing device: expected an error status, got %d", status)
+	}
+	if exists, _ := afero.Exists(srv.Fs, "/docs/readme.txt"); !exists {
+		t.Fatal("file was removed despite the injected error")
+	}
+	srv.Reset()
+
+	status, _ = doAfcRequest(t, ts, token, http.MethodGet, "/api/resources/docs/missing.txt", "")
+	if status != http.StatusNotFound {
+		t.Errorf("missing file: expected status code 404, got %d", status)
+	}
+	status, _ = doAfcRequest(t, ts, token, http.MethodPut, "/api/resources/docs/missing.txt", "content")
+	if status != http.StatusNotFound {
+		t.Errorf("save of a missing file: expected status code 404, got %d", status)
+	}
+
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_write_file_atom, Err: services.Afc_Err_NoSpaceLeft})
+	status, _ = doAfcRequest(t, ts, token, http.MethodPut, "/api/resources/docs/readme.txt", "content")
+	if status != http.StatusInsufficientStorage {
+		t.Errorf("save on a full device: expected status code 507, got %d", status)
+	}
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_file_info, Path: "/docs/readme.txt", Err: services.Afc_Err_PermDenied})
+	status, _ = doAfcRequest(t, ts, token, http.MethodGet, "/api/resources/docs/readme.txt", "")
+	if status != http.StatusForbidden {
+		t.Errorf("file without permission: expected status code 403, got %d", status)
+	}
+	srv.Reset()
+
+	// the next request after a dropped connection is served on a new one
+	srv.CloseClientConnections()
+	status, body := doAfcRequest(t, ts, token, http.MethodGet, "/api/resources/docs/", "")
+	if status != http.StatusOK {
+		t.Fatalf("listing after disconnect: expected status code 200, got %d: %s", status, body)
+	}
+}
+
+func TestAfcSnapshots(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServer(t)
+	if err := afero.WriteFile(srv.Fs, "/app/db.sqlite", []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(srv.Fs, "/app/stale.log", []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := doAfcRequest(t, ts, token, http.MethodPost, "/api/snapshots", `{"name":"before","path":"/app","algo":"sha1"}`)
+	if status != http.StatusOK {
+		t.Fatalf("snapshot: expected status code 200, got %d: %s", status, body)
+	}
+	status, _ = doAfcRequest(t, ts, token, http.MethodPost, "/api/snapshots", `{"name":"before","path":"/app"}`)
+	if status != http.StatusConflict {
+		t.Errorf("snapshot with a taken name: expected status code 409, got %d", status)
+	}
+
+	if err := afero.WriteFile(srv.Fs, "/app/db.sqlite", []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Fs.Remove("/app/stale.log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(srv.Fs, "/app/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = doAfcRequest(t, ts, token, http.MethodGet, "/api/snapshots/before/diff", "")
+	if status != http.StatusOK {
+		t.Fatalf("diff: expected status code 200, got %d: %s", status, body)
+	}
+	var diff struct {
+		Created  []struct{ Path string } `json:"created"`
+		Modified []struct{ Path string } `json:"modified"`
+		Deleted  []struct{ Path string } `json:"deleted"`
+	}
+	if err := json.Unmarshal([]byte(body), &diff); err != nil {
+		t.Fatalf("failed to decode diff: %v", err)
+	}
+	if len(diff.Created) != 1 || diff.Created[0].Path != "new.txt" ||
+		len(diff.Modified) != 1 || diff.Modified[0].Path != "db.sqlite" ||
+		len(diff.Deleted) != 1 || diff.Deleted[0].Path != "stale.log" {
+		t.Errorf("unexpected diff %s", body)
+	}
+
+	status, _ = doAfcRequest(t, ts, token, http.MethodGet, "/api/snapshots/before?user=2", "")
+	if status != http.StatusForbidden {
+		t.Errorf("snapshot of another user: expected status code 403, got %d", status)
+	}
+
+	status, body = doAfcRequest(t, ts, token, http.MethodGet, "/api/snapshots", "")
+	if status != http.StatusOK || !strings.Contains(body, `"name":"before"`) || strings.Contains(body, "entries") {
+		t.Errorf("list: got %d %s", status, body)
+	}
+	status, _ = doAfcRequest(t, ts, token, http.MethodDelete, "/api/snapshots/before", "")
+	if status != http.StatusOK {
+		t.Errorf("delete: expected status code 200, got %d", status)
+	}
+	status, _ = doAfcRequest(t, ts, token, http.MethodGet, "/api/snapshots/before", "")
+	if status != http.StatusNotFound {
+		t.Errorf("deleted snapshot: expected status code 404, got %d", status)
+	}
+}
+
+func TestAfcDevices(t *testing.T) {
+	srv, ts, token := newAfcTestServerWithPerm(t, users.Permissions{Admin: true})
+	if err := afero.WriteFile(srv.Fs, "/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offline := afctest.NewServer()
+	offline.Close()
+	device.SetDefaults(users.DeviceOptions{Options: services.Options{PoolSize: 1, ReconnectAttempts: 1}})
+
+	// registering any device serves every user from theirs, so the default
+	// device is registered for the other tests, replacing that of a previous
+	// run at once
+	if err := users.AddDevice(users.DefaultDevice, srv.Addr(), users.DeviceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := doAfcRequest(t, ts, token, http.MethodPost, "/api/devices",
+		`{"name":"phone","addr":"`+srv.Addr()+`","cacheTTL":"0"}`)
+	if status != http.StatusOK {
+		t.Fatalf("add: expected status code 200, got %d: %s", status, body)
+	}
+	var info deviceInfo
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Stored || !info.Attached || !info.Connected || info.Usage == nil {
+		t.Errorf("online device: %s", body)
+	}
+
+	status, body = doAfcRequest(t, ts, token, http.MethodPost, "/api/devices",
+		`{"name":"offline","addr":"`+offline.Addr()+`"}`)
+	if status != http.StatusOK {
+		t.Fatalf("add offline: expected status code 200, got %d: %s", status, body)
+	}
+	info = deviceInfo{}
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Attached || info.Connected || info.Error == "" {
+		t.Errorf("offline device: %s", body)
+	}
+
+	for _, tc := range []struct {
+		body   string
+		status int
+	}{
+		{`{"name":"offline","addr":"127.0.0.1:1"}`, http.StatusConflict},
+		{`{"name":"bad","addr":"usbmux://phone"}`, http.StatusBadRequest},
+		{`{"name":"a/b","addr":"127.0.0.1:1"}`, http.StatusBadRequest},
+		{`{"name":"bad","addr":"127.0.0.1:1","cacheTTL":"soon"}`, http.StatusBadRequest},
+	} {
+		if status, body = doAfcRequest(t, ts, token, http.MethodPost, "/api/devices", tc.body); status != tc.status {
+			t.Errorf("add %s: expected status code %d, got %d: %s", tc.body, tc.status, status, body)
+		}
+	}
+
+	// disabling detaches the device but keeps it stored
+	status, body = doAfcRequest(t, ts, token, http.MethodPut, "/api/devices/offline",
+		`{"addr":"`+offline.Addr()+`","disabled":true}`)
+	if status != http.StatusOK {
+		t.Fatalf("disable: expected status code 200, got %d: %s", status, body)
+	}
+	info = deviceInfo{}
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Stored || info.Attached {
+		t.Errorf("disabled device: %s", body)
+	}
+	for _, name := range users.Devices() {
+		if name == "offline" {
+			t.Error("disabled device still attached")
+		}
+	}
+
+	status, body = doAfcRequest(t, ts, token, http.MethodGet, "/api/devices", "")
+	if status != http.StatusOK {
+		t.Fatalf("list: expected status code 200, got %d: %s", status, body)
+	}
+	var list []deviceInfo
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Name != "default" || list[0].Stored || list[1].Name != "offline" || list[2].Name != "phone" {
+		t.Errorf("list: %s", body)
+	}
+
+	for _, name := range []string{"offline", "phone"} {
+		if status, _ = doAfcRequest(t, ts, token, http.MethodDelete, "/api/devices/"+name, ""); status != http.StatusOK {
+			t.Errorf("delete %s: expected status code 200, got %d", name, status)
+		}
+	}
+	if status, _ = doAfcRequest(t, ts, token, http.MethodGet, "/api/devices/offline", ""); status != http.StatusNotFound {
+		t.Errorf("get deleted: expected status code 404, got %d", status)
+	}
+
+	_, ts, token = newAfcTestServer(t)
+	if status, _ = doAfcRequest(t, ts, token, http.MethodGet, "/api/devices", ""); status != http.StatusForbidden {
+		t.Errorf("list as a regular user: expected status code 403, got %d", status)
+	}
+}
+
+// waitJob follows the events of a job until it is finished and returns its
+// final state.
+func waitJob(t *testing.T, ts *httptest.Server, token string, id uint) jobs.Job {
+	t.Helper()
+	status, body := doAfcRequest(t, ts, token, http.MethodGet, fmt.Sprintf("/api/jobs/events?id=%d", id), "")
+	if status != http.StatusOK {
+		t.Fatalf("events: expected status code 200, got %d: %s", status, body)
+	}
+	var job jobs.Job
+	for _, line := range strings.Split(body, "\n") {
+		if data := strings.TrimPrefix(line, "data: "); data != line {
+			if err := json.Unmarshal([]byte(data), &job); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !job.State.Finished() {
+		t.Fatalf("events ended before the job finished: %s", body)
+	}
+	return job
+}
+
+func startJob(t *testing.T, ts *httptest.Server, token, method, path, body string) jobs.Job {
+	t.Helper()
+	status, resp := doAfcRequest(t, ts, token, method, path, body)
+	if status != http.StatusAccepted {
+		t.Fatalf("%s %s: expected status code 202, got %d: %s", method, path, status, resp)
+	}
+	var job jobs.Job
+	if err := json.Unmarshal([]byte(resp), &job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestAfcJobs(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServer(t)
+	for name, content := range map[string]string{
+		"/tree/a.txt":     "aaaa",
+		"/tree/sub/b.txt": "bb",
+		"/old.log":        "log",
+	} {
+		if err := afero.WriteFile(srv.Fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job := startJob(t, ts, token, http.MethodPost, "/api/jobs",
+		`{"action":"copy","sources":["/tree"],"destination":"/copy"}`)
+	if job = waitJob(t, ts, token, job.ID); job.State != jobs.StateDone || job.Bytes != 6 || job.Files != 2 {
+		t.Errorf("copy job: %+v", job)
+	}
+	if data, err := afero.ReadFile(srv.Fs, "/copy/sub/b.txt"); err != nil || string(data) != "bb" {
+		t.Errorf("copied file: %q %v", data, err)
+	}
+
+	status, _ := doAfcRequest(t, ts, token, http.MethodPost, "/api/jobs",
+		`{"action":"copy","sources":["/tree"],"destination":"/copy"}`)
+	if status != http.StatusConflict {
+		t.Errorf("copy over an existing tree: expected status code 409, got %d", status)
+	}
+
+	job = startJob(t, ts, token, http.MethodPatch, "/api/resources/copy?action=rename&destination=/moved&async=true", "")
+	if job = waitJob(t, ts, token, job.ID); job.State != jobs.StateDone {
+		t.Errorf("async rename: %+v", job)
+	}
+	if _, err := srv.Fs.Stat("/moved/a.txt"); err != nil {
+		t.Errorf("renamed tree: %v", err)
+	}
+
+	job = startJob(t, ts, token, http.MethodPost, "/api/jobs",
+		`{"action":"archive","sources":["/tree/a.txt","/tree/sub"],"destination":"/out/tree.zip","algo":"zip"}`)
+	if job = waitJob(t, ts, token, job.ID); job.State != jobs.StateDone || job.Files != 2 || job.TotalBytes != 6 {
+		t.Errorf("archive job: %+v", job)
+	}
+	data, err := afero.ReadFile(srv.Fs, "/out/tree.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"a.txt", "sub/", "sub/b.txt"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("archived %v, want %v", names, want)
+	}
+
+	// a nested file that cannot be read fails the job instead of being left out
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_file_open, Path: "/tree/sub/b.txt", Err: services.Afc_Err_PermDenied})
+	job = startJob(t, ts, token, http.MethodPost, "/api/jobs",
+		`{"action":"archive","sources":["/tree/sub"],"destination":"/out/partial.zip","algo":"zip"}`)
+	if job = waitJob(t, ts, token, job.ID); job.State != jobs.StateFailed || job.Error == "" {
+		t.Errorf("archive job with an unreadable file: %+v", job)
+	}
+	srv.Reset()
+	if exists, _ := afero.Exists(srv.Fs, "/out/partial.zip"); exists {
+		t.Error("an incomplete archive was written")
+	}
+
+	job = startJob(t, ts, token, http.MethodDelete, "/api/resources/old.log?async=true", "")
+	if job = waitJob(t, ts, token, job.ID); job.State != jobs.StateDone {
+		t.Errorf("async delete: %+v", job)
+	}
+	if _, err = srv.Fs.Stat("/old.log"); err == nil {
+		t.Error("deleted file still exists")
+	}
+
+	// a failed job can be retried once its cause is gone
+	job = startJob(t, ts, token, http.MethodPost, "/api/jobs",
+		`{"action":"copy","sources":["/missing"],"destination":"/found"}`)
+	if job = waitJob(t, ts, token, job.ID); job.State != jobs.StateFailed || job.Error == "" {
+		t.Errorf("failing job: %+v", job)
+	}
+	if err = afero.WriteFile(srv.Fs, "/missing", []byte("here"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, body := doAfcRequest(t, ts, token, http.MethodPost, fmt.Sprintf("/api/jobs/%d/retry", job.ID), "")
+	if status != http.StatusOK {
+		t.Fatalf("retry: expected status code 200, got %d: %s", status, body)
+	}
+	if job = waitJob(t, ts, token, job.ID); job.State != jobs.StateDone || job.Attempts != 2 {
+		t.Errorf("retried job: %+v", job)
+	}
+	if status, _ = doAfcRequest(t, ts, token, http.MethodPost, fmt.Sprintf("/api/jobs/%d/cancel", job.ID), ""); status != http.StatusConflict {
+		t.Errorf("cancel a finished job: expected status code 409, got %d", status)
+	}
+
+	status, body = doAfcRequest(t, ts, token, http.MethodGet, "/api/jobs", "")
+	var list []jobs.Job
+	if err = json.Unmarshal([]byte(body), &list); err != nil || status != http.StatusOK || len(list) != 6 {
+		t.Errorf("list: %d %s", status, body)
+	}
+	if status, _ = doAfcRequest(t, ts, token, http.MethodDelete, fmt.Sprintf("/api/jobs/%d", job.ID), ""); status != http.StatusOK {
+		t.Errorf("delete: expected status code 200, got %d", status)
+	}
+	if status, _ = doAfcRequest(t, ts, token, http.MethodGet, fmt.Sprintf("/api/jobs/%d", job.ID), ""); status != http.StatusNotFound {
+		t.Errorf("get a deleted job: expected status code 404, got %d", status)
+	}
+}
+
+func TestAfcBatch(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServer(t)
+	for name, content := range map[string]string{
+		"/in/a.txt":  "a",
+		"/in/b.txt":  "b",
+		"/in/c.txt":  "c",
+		"/out/a.txt": "old a",
+		"/out/b.txt": "old b",
+	} {
+		if err := afero.WriteFile(srv.Fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := func(body string) batchResponse {
+		t.Helper()
+		status, resp := doAfcRequest(t, ts, token, http.MethodPost, "/api/batch", body)
+		if status != http.StatusOK {
+			t.Fatalf("batch: expected status code 200, got %d: %s", status, resp)
+		}
+		var r batchResponse
+		if err := json.Unmarshal([]byte(resp), &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	results := func(r batchResponse) string {
+		list := []string{}
+		for _, result := range r.Results {
+			list = append(list, result.Result)
+		}
+		return strings.Join(list, ",")
+	}
+	content := func(name string) string {
+		data, err := afero.ReadFile(srv.Fs, name)
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+
+	// a failure rolls back the overwritten destination and the moves
+	r := batch(`{"conflict":"overwrite","rollback":true,"items":[
+		{"action":"rename","src":"/in/a.txt","dst":"/out/a.txt"},
+		{"action":"copy","src":"/in/b.txt","dst":"/out/b.txt"},
+		{"action":"rename","src":"/in/missing.txt","dst":"/out/missing.txt"},
+		{"action":"delete","src":"/in/c.txt"}]}`)
+	if got := results(r); !r.RolledBack || got != "rolledBack,rolledBack,failed,notRun" {
+		t.Errorf("rolled back batch: %s", got)
+	}
+	if content("/out/a.txt") != "old a" || content("/out/b.txt") != "old b" || content("/in/a.txt") != "a" {
+		t.Errorf("files after a rollback: %q %q %q", content("/out/a.txt"), content("/out/b.txt"), content("/in/a.txt"))
+	}
+	if infos, _ := afero.ReadDir(srv.Fs, "/out"); len(infos) != 2 {
+		t.Errorf("%d files left in /out after a rollback", len(infos))
+	}
+
+	r = batch(`{"conflict":"rename","items":[
+		{"action":"copy","src":"/in/a.txt","dst":"/out/a.txt"},
+		{"action":"rename","src":"/in/missing.txt","dst":"/out/missing.txt"},
+		{"action":"delete","src":"/in/c.txt"}]}`)
+	if got := results(r); r.RolledBack || got != "done,failed,done" {
+		t.Errorf("batch without rollback: %s", got)
+	}
+	if r.Results[0].Dst != "/out/a(1).txt" || content("/out/a(1).txt") != "a" {
+		t.Errorf("renamed copy: %q %q", r.Results[0].Dst, content("/out/a(1).txt"))
+	}
+	if _, err := srv.Fs.Stat("/in/c.txt"); err == nil {
+		t.Error("deleted file still exists")
+	}
+
+	r = batch(`{"conflict":"skip","items":[
+		{"action":"copy","src":"/in/b.txt","dst":"/out/b.txt"},
+		{"action":"copy","src":"/in/b.txt","dst":"/out/new.txt"}]}`)
+	if got := results(r); got != "skipped,done" || content("/out/b.txt") != "old b" {
+		t.Errorf("skipping batch: %s", got)
+	}
+
+	r = batch(`{"items":[{"action":"copy","src":"/in/b.txt","dst":"/out/b.txt"}]}`)
+	if got := results(r); got != "failed" {
+		t.Errorf("conflicting batch: %s", got)
+	}
+	if status, _ := doAfcRequest(t, ts, token, http.MethodPost, "/api/batch", `{"conflict":"merge","items":[]}`); status != http.StatusBadRequest {
+		t.Errorf("unknown conflict policy: expected status code 400, got %d", status)
+	}
+
+	// the overwritten files are stashed out of sight and removed once done
+	r = batch(`{"conflict":"overwrite","rollback":true,"items":[{"action":"copy","src":"/in/b.txt","dst":"/out/new.txt"}]}`)
+	if got := results(r); got != "done" || content("/out/new.txt") != "b" {
+		t.Errorf("overwriting batch: %s", got)
+	}
+	if infos, _ := afero.ReadDir(srv.Fs, "/"+trash.Dir); len(infos) != 0 {
+		t.Errorf("%d stashes left", len(infos))
+	}
+
+	// the rollback goes on past the items it fails to undo
+	if err := afero.WriteFile(srv.Fs, "/in/x.txt", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []uint64{services.Afc_operation_rename_path, services.Afc_operation_file_open} {
+		srv.Inject(afctest.Fault{Op: op, Path: "/out/x.txt", Err: services.Afc_Err_PermDenied})
+	}
+	r = batch(`{"conflict":"overwrite","rollback":true,"items":[
+		{"action":"rename","src":"/in/x.txt","dst":"/out/x.txt"},
+		{"action":"copy","src":"/in/a.txt","dst":"/out/b.txt"},
+		{"action":"rename","src":"/in/missing.txt","dst":"/out/missing.txt"}]}`)
+	srv.Reset()
+	if got := results(r); r.RolledBack || got != "rollbackFailed,rolledBack,failed" || !strings.Contains(r.Results[0].Error, "rollback") {
+		t.Errorf("partly rolled back batch: %s %+v", got, r.Results[0])
+	}
+	if content("/out/b.txt") != "old b" {
+		t.Errorf("overwritten file after a partial rollback: %q", content("/out/b.txt"))
+	}
+
+	// a destination that cannot be checked is not taken as free
+	srv.Inject(afctest.Fault{Op: services.Afc_operation_file_info, Path: "/out/b.txt", Err: services.Afc_Err_PermDenied})
+	r = batch(`{"items":[{"action":"copy","src":"/in/a.txt","dst":"/out/b.txt"}]}`)
+	srv.Reset()
+	if got := results(r); got != "failed" || content("/out/b.txt") != "old b" {
+		t.Errorf("batch with an unreadable destination: %s %q", got, content("/out/b.txt"))
+	}
+}
+
+func TestAfcTrash(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServerWithSettings(t,
+		users.Permissions{Create: true, Modify: true, Delete: true, Rename: true, Download: true},
+		&settings.Server{EnableTrash: true})
+	for name, content := range map[string]string{
+		"/dir/a.txt": "a",
+		"/b.txt":     "b",
+		"/c.txt":     "c",
+	} {
+		if err := afero.WriteFile(srv.Fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listTrash := func() []trash.Item {
+		t.Helper()
+		status, body := doAfcRequest(t, ts, token, http.MethodGet, "/api/trash", "")
+		if status != http.StatusOK {
+			t.Fatalf("trash: expected status code 200, got %d: %s", status, body)
+		}
+		var items []trash.Item
+		if err := json.Unmarshal([]byte(body), &items); err != nil {
+			t.Fatal(err)
+		}
+		return items
+	}
+
+	for _, name := range []string{"/dir", "/b.txt"} {
+		if status, body := doAfcRequest(t, ts, token, http.MethodDelete, "/api/resources"+name, ""); status != http.StatusOK {
+			t.Fatalf("delete %s: expected status code 200, got %d: %s", name, status, body)
+		}
+	}
+	items := listTrash()
+	if len(items) != 2 || items[0].Path != "/b.txt" || items[1].Path != "/dir" || !items[1].IsDir || items[1].Size != 1 {
+		t.Fatalf("trash after deleting: %+v", items)
+	}
+	if _, err := srv.Fs.Stat("/dir"); err == nil {
+		t.Error("/dir still exists")
+	}
+	trashed := fmt.Sprintf("/%s/%d/a.txt", trash.Dir, items[1].ID)
+	if _, err := srv.Fs.Stat(trashed); err != nil {
+		t.Errorf("%s: %v", trashed, err)
+	}
+
+	// the trash area is hidden, whatever the rules
+	_, body := doAfcRequest(t, ts, token, http.MethodGet, "/api/resources/", "")
+	if strings.Contains(body, trash.Dir) {
+		t.Errorf("trash area listed: %s", body)
+	}
+	if status, _ := doAfcRequest(t, ts, token, http.MethodGet, "/api/resources/"+trash.Dir+"/", ""); status != http.StatusForbidden {
+		t.Errorf("trash area: expected status code 403, got %d", status)
+	}
+
+	// restoring over a new file fails unless renamed
+	if err := srv.Fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	restore := fmt.Sprintf("/api/trash/%d/restore", items[1].ID)
+	if status, _ := doAfcRequest(t, ts, token, http.MethodPost, restore, ""); status != http.StatusConflict {
+		t.Errorf("restore over /dir: expected status code 409, got %d", status)
+	}
+	status, body := doAfcRequest(t, ts, token, http.MethodPost, restore+"?rename=true", "")
+	if status != http.StatusOK || !strings.Contains(body, `"path":"/dir(1)"`) {
+		t.Fatalf("restore: got %d: %s", status, body)
+	}
+	if data, err := afero.ReadFile(srv.Fs, "/dir(1)/a.txt"); err != nil || string(data) != "a" {
+		t.Errorf("restored file: %q %v", data, err)
+	}
+
+	// purge, permanent delete and empty
+	status, _ = doAfcRequest(t, ts, token, http.MethodDelete, fmt.Sprintf("/api/trash/%d", items[0].ID), "")
+	if status != http.StatusOK {
+		t.Errorf("purge: expected status code 200, got %d", status)
+	}
+	if infos, _ := afero.ReadDir(srv.Fs, "/"+trash.Dir); len(infos) != 0 {
+		t.Errorf("trash area after purging: %d entries", len(infos))
+	}
+	if status, _ = doAfcRequest(t, ts, token, http.MethodDelete, "/api/resources/c.txt?permanent=true", ""); status != http.StatusOK {
+		t.Errorf("permanent delete: expected status code 200, got %d", status)
+	}
+	if items = listTrash(); len(items) != 0 {
+		t.Errorf("trash after a permanent delete: %+v", items)
+	}
+	if status, _ = doAfcRequest(t, ts, token, http.MethodDelete, "/api/resources/dir", ""); status != http.StatusOK {
+		t.Errorf("delete: expected status code 200, got %d", status)
+	}
+	// the items whose content is already gone can be purged
+	if err := srv.Fs.RemoveAll("/" + trash.Dir); err != nil {
+		t.Fatal(err)
+	}
+	srv.Inject(afctest.Fault{Op: services.AFC_OP_REMOVE_PATH_AND_CONTENTS, Err: services.Afc_Err_ObjectNotFound})
+	status, body = doAfcRequest(t, ts, token, http.MethodDelete, "/api/trash", "")
+	srv.Reset()
+	if status != http.StatusOK || body != `{"purged":1}` {
+		t.Errorf("empty: got %d: %s", status, body)
+	}
+}
+
+func TestAfcVersions(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServerWithSettings(t,
+		users.Permissions{Create: true, Modify: true, Delete: true, Rename: true, Download: true},
+		&settings.Server{Versions: versions.Policy{Keep: 2}})
+	if err := afero.WriteFile(srv.Fs, "/notes.txt", []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := doAfcRequest(t, ts, token, http.MethodPut, "/api/resources/notes.txt", "v2"); status != http.StatusOK {
+		t.Fatalf("save: expected status code 200, got %d: %s", status, body)
+	}
+	if status, body := doAfcRequest(t, ts, token, http.MethodPost, "/api/resources/notes.txt?override=true", "v3"); status != http.StatusOK {
+		t.Fatalf("upload: expected status code 200, got %d: %s", status, body)
+	}
+
+	// new files have no version
+	if status, body := doAfcRequest(t, ts, token, http.MethodPost, "/api/resources/new.txt", "new"); status != http.StatusOK {
+		t.Fatalf("new upload: expected status code 200, got %d: %s", status, body)
+	}
+	if status, body := doAfcRequest(t, ts, token, http.MethodGet, "/api/versions/new.txt", ""); status != http.StatusOK || strings.TrimSpace(body) != "[]" {
+		t.Errorf("versions of a new file: got %d: %s", status, body)
+	}
+
+	status, body := doAfcRequest(t, ts, token, http.MethodGet, "/api/versions/notes.txt", "")
+	if status != http.StatusOK {
+		t.Fatalf("versions: expected status code 200, got %d: %s", status, body)
+	}
+	var list []versions.Version
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Size != 2 {
+		t.Fatalf("versions: %s", body)
+	}
+
+	status, body = doAfcRequest(t, ts, token, http.MethodGet, "/api/versions/notes.txt?id="+list[1].ID, "")
+	if status != http.StatusOK || body != "v1" {
+		t.Errorf("download: got %d: %q", status, body)
+	}
+	if status, _ = doAfcRequest(t, ts, token, http.MethodGet, "/api/versions/notes.txt?id=1", ""); status != http.StatusNotFound {
+		t.Errorf("unknown version: expected status code 404, got %d", status)
+	}
+
+	if status, body = doAfcRequest(t, ts, token, http.MethodPost, "/api/versions/notes.txt?id="+list[1].ID, ""); status != http.StatusOK {
+		t.Fatalf("restore: expected status code 200, got %d: %s", status, body)
+	}
+	if data, err := afero.ReadFile(srv.Fs, "/notes.txt"); err != nil || string(data) != "v1" {
+		t.Errorf("restored content: %q %v", data, err)
+	}
+
+	// the versions are hidden, whatever the rules
+	_, body = doAfcRequest(t, ts, token, http.MethodGet, "/api/resources/", "")
+	if strings.Contains(body, versions.Dir) {
+		t.Errorf("versions listed: %s", body)
+	}
+
+	// a failed upload leaves the original in place
+	srv.Inject(afctest.Fault{Path: "/" + versions.Dir + "/notes.txt", Err: services.Afc_Err_PermDenied})
+	if status, _ = doAfcRequest(t, ts, token, http.MethodPost, "/api/resources/notes.txt?override=true", "v4"); status == http.StatusOK {
+		t.Error("upload succeeded without saving the version")
+	}
+	if data, err := afero.ReadFile(srv.Fs, "/notes.txt"); err != nil || string(data) != "v1" {
+		t.Errorf("content after the failed upload: %q %v", data, err)
+	}
+}
+
+// newZip returns a zip archive of files, by entry name.
+func newZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAfcArchiveActions(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, token := newAfcTestServerWithSettings(t,
+		users.Permissions{Create: true, Modify: true, Delete: true, Rename: true, Download: true},
+		&settings.Server{ExtractMaxSize: 1024, Versions: versions.Policy{Keep: 2}})
+	for name, content := range map[string][]byte{
+		"/dir/a.txt":     []byte("a"),
+		"/dir/sub/b.txt": []byte("bb"),
+		"/hidden.zip":    newZip(t, map[string]string{"ok.txt": "ok", trash.Dir + "/1": "planted"}),
+		"/slip.zip":      newZip(t, map[string]string{"ok.txt": "ok", "../../evil.txt": "evil"}),
+		"/bomb.zip":      newZip(t, map[string]string{"zeros": strings.Repeat("0", 4096)}),
+	} {
+		if err := afero.WriteFile(srv.Fs, name, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job := waitJob(t, ts, token, startJob(t, ts, token, http.MethodPatch, "/api/resources/dir?action=compress&algo=targz", "").ID)
+	if job.State != jobs.StateDone || job.Destination != "/dir.tar.gz" {
+		t.Fatalf("compress: %+v", job)
+	}
+	job = waitJob(t, ts, token, startJob(t, ts, token, http.MethodPatch, "/api/resources/dir.tar.gz?action=extract&destination=/out", "").ID)
+	if job.State != jobs.StateDone || job.Algo != "targz" || job.Files != 2 {
+		t.Fatalf("extract: %+v", job)
+	}
+	if data, err := afero.ReadFile(srv.Fs, "/out/sub/b.txt"); err != nil || string(data) != "bb" {
+		t.Errorf("extracted file: %q %v", data, err)
+	}
+	if status, _ := doAfcRequest(t, ts, token, http.MethodPatch, "/api/resources/dir.tar.gz?action=extract&destination=/out", ""); status != http.StatusConflict {
+		t.Errorf("extract over /out: expected status code 409, got %d", status)
+	}
+
+	// the files extracted over are kept as versions
+	if err := afero.WriteFile(srv.Fs, "/out/sub/b.txt", []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job = waitJob(t, ts, token, startJob(t, ts, token, http.MethodPatch, "/api/resources/dir.tar.gz?action=extract&destination=/out&override=true", "").ID)
+	if job.State != jobs.StateDone {
+		t.Fatalf("extract with override: %+v", job)
+	}
+	if data, _ := afero.ReadFile(srv.Fs, "/out/sub/b.txt"); string(data) != "bb" {
+		t.Errorf("file extracted over: %q", data)
+	}
+	status, body := doAfcRequest(t, ts, token, http.MethodGet, "/api/versions/out/sub/b.txt", "")
+	var list []versions.Version
+	if err := json.Unmarshal([]byte(body), &list); err != nil || status != http.StatusOK || len(list) != 1 || list[0].Size != 6 {
+		t.Errorf("versions of a file extracted over: %d %s", status, body)
+	}
+
+	// the entries the rules hide are skipped
+	job = waitJob(t, ts, token, startJob(t, ts, token, http.MethodPatch, "/api/resources/hidden.zip?action=extract", "").ID)
+	if job.State != jobs.StateDone || job.Destination != "/hidden" || job.Files != 1 {
+		t.Errorf("extract hidden.zip: %+v", job)
+	}
+	if _, err := srv.Fs.Stat("/hidden/" + trash.Dir); err == nil {
+		t.Error("hidden entry extracted")
+	}
+
+	// failed extractions leave nothing behind
+	for name, reason := range map[string]string{"slip": "permission denied", "bomb": "extraction limits"} {
+		job = waitJob(t, ts, token, startJob(t, ts, token, http.MethodPatch, "/api/resources/"+name+".zip?action=extract", "").ID)
+		if job.State != jobs.StateFailed || !strings.Contains(job.Error, reason) {
+			t.Errorf("extract %s.zip: %+v", name, job)
+		}
+		if _, err := srv.Fs.Stat("/" + name); err == nil {
+			t.Errorf("/%s created", name)
+		}
+	}
+	infos, err := afero.ReadDir(srv.Fs, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".tmp") || info.Name() == "evil.txt" {
+			t.Errorf("left behind: %s", info.Name())
+		}
+	}
+
+	_, ts, token = newAfcTestServerWithPerm(t, users.Permissions{Modify: true, Download: true})
+	if status, _ := doAfcRequest(t, ts, token, http.MethodPatch, "/api/resources/dir.zip?action=extract", ""); status != http.StatusForbidden {
+		t.Errorf("extract without the create permission: expected status code 403, got %d", status)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/golang-jwt/jwt/v4/request"
 
 	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
 	"github.com/filebrowser/filebrowser/v2/users"
 )
 
@@ -87,6 +88,7 @@ func withUser(fn handleFunc) handleFunc {
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
+		d.user.Fs = govfs.WithContext(d.user.Fs, fsContext(r))
 		return fn(w, r, d)
 	}
 }
@@ -0,0 +1,360 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/files"
+	"github.com/filebrowser/filebrowser/v2/fileutils"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/trash"
+)
+
+// Conflict policies of a batch, applied to the items whose destination
+// exists.
+const (
+	conflictFail      = "fail"
+	conflictOverwrite = "overwrite"
+	conflictSkip      = "skip"
+	conflictRename    = "rename"
+)
+
+// Results of the items of a batch.
+const (
+	batchDone       = "done"
+	batchSkipped    = "skipped"
+	batchFailed     = "failed"
+	batchRolledBack = "rolledBack"
+	// batchRollbackFailed is an item done whose undo failed.
+	batchRollbackFailed = "rollbackFailed"
+	batchNotRun         = "notRun"
+)
+
+type batchItem struct {
+	Action string `json:"action"`
+	Src    string `json:"src"`
+	Dst    string `json:"dst,omitempty"`
+}
+
+type batchRequest struct {
+	Items []batchItem `json:"items"`
+	// Conflict is one of fail (the default), overwrite, skip or rename.
+	// Overwritten destinations are replaced, not merged with the source.
+	Conflict string `json:"conflict"`
+	// Rollback stops the batch at the first failed item and undoes the
+	// items done before it.
+	Rollback bool `json:"rollback"`
+	// Permanent deletes skip the trash.
+	Permanent bool `json:"permanent"`
+}
+
+type batchResult struct {
+	batchItem
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results    []*batchResult `json:"results"`
+	RolledBack bool           `json:"rolledBack"`
+}
+
+// batchUndo undoes part of the item at index item.
+type batchUndo struct {
+	item int
+	fn   func() error
+}
+
+// batch runs the items of a batch request. With rollback, the files
+// overwritten, and those deleted without going to the trash, are moved to a
+// stash in the trash area of the user until the batch is over, so that they
+// can be put back.
+type batch struct {
+	ctx       context.Context
+	d         *data
+	fileCache FileCache
+	rollback  bool
+	permanent bool
+	stashID   string
+	// detached is the filesystem of the user bound to a context the client
+	// cannot cancel, for the rollback and the cleanup to run to the end.
+	detached afero.Fs
+	// item is the index of the item running.
+	item int
+	// undo holds the functions undoing the items done, in their order.
+	undo    []batchUndo
+	stashes int
+}
+
+// stashDir is the directory holding what the batch stashed.
+func (b *batch) stashDir() string {
+	return path.Join("/", trash.Dir, "batch-"+b.stashID)
+}
+
+// onUndo registers fn to undo part of the running item.
+func (b *batch) onUndo(fn func() error) {
+	b.undo = append(b.undo, batchUndo{item: b.item, fn: fn})
+}
+
+// clear removes name, which is stashed when the batch can be rolled back.
+func (b *batch) clear(name string) error {
+	fs := b.d.user.Fs
+	if !b.rollback {
+		return govfs.RemoveAll(fs, name)
+	}
+	if err := fs.MkdirAll(b.stashDir(), 0775); err != nil { //nolint:gomnd
+		return err
+	}
+	stashed := path.Join(b.stashDir(), strconv.Itoa(b.stashes))
+	if err := fs.Rename(name, stashed); err != nil {
+		return err
+	}
+	b.stashes++
+	b.onUndo(func() error {
+		return b.detached.Rename(stashed, name)
+	})
+	return nil
+}
+
+// rollBack runs the undo functions, the latest first, and returns the
+// errors by item. It keeps going past the failures.
+func (b *batch) rollBack() map[int][]error {
+	errs := map[int][]error{}
+	for j := len(b.undo) - 1; j >= 0; j-- {
+		if err := b.undo[j].fn(); err != nil {
+			errs[b.undo[j].item] = append(errs[b.undo[j].item], err)
+		}
+	}
+	return errs
+}
+
+// removeStash removes what the batch stashed, once it is no longer needed.
+func (b *batch) removeStash() {
+	if b.stashes == 0 {
+		return
+	}
+	if err := govfs.RemoveAll(b.detached, b.stashDir()); err != nil {
+		log.Printf("batch: failed to remove %s: %v", b.stashDir(), err)
+	}
+}
+
+// check verifies the item and resolves its destination. skip is set when the
+// conflict policy skips it.
+func (b *batch) check(item *batchItem, conflict string) (skip, exists bool, err error) {
+	d := b.d
+	item.Src = path.Clean("/" + item.Src)
+	if item.Src == "/" || !d.Check(item.Src) {
+		return false, false, errors.ErrPermissionDenied
+	}
+	if _, err = d.user.Fs.Stat(item.Src); err != nil {
+		return false, false, err
+	}
+	if item.Action == "delete" {
+		if !d.user.Perm.Delete {
+			return false, false, errors.ErrPermissionDenied
+		}
+		item.Dst = ""
+		return false, false, nil
+	}
+	if item.Action != "copy" && item.Action != "rename" {
+		return false, false, fmt.Errorf("unsupported action %s: %w", item.Action, errors.ErrInvalidRequestParams)
+	}
+
+	item.Dst = path.Clean("/" + item.Dst)
+	if item.Dst == "/" || !d.Check(item.Dst) {
+		return false, false, errors.ErrPermissionDenied
+	}
+	if err = checkParent(item.Src, item.Dst); err != nil {
+		return false, false, err
+	}
+	// only a missing destination is free, other errors would let the item
+	// run over one that exists
+	if _, err = d.user.Fs.Stat(item.Dst); stdErrors.Is(err, os.ErrNotExist) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	switch conflict {
+	case conflictSkip:
+		return true, true, nil
+	case conflictRename:
+		item.Dst = addVersionSuffix(item.Dst, d.user.Fs)
+		return false, false, nil
+	case conflictOverwrite:
+		if !d.user.Perm.Modify {
+			return false, false, errors.ErrPermissionDenied
+		}
+		return false, true, nil
+	default:
+		return false, true, errors.ErrExist
+	}
+}
+
+// run runs a checked item.
+func (b *batch) run(item *batchItem, exists bool) error {
+	d := b.d
+	return d.RunHook(func() error {
+		if exists {
+			if err := b.clear(item.Dst); err != nil {
+				return err
+			}
+		}
+
+		switch item.Action {
+		case "delete":
+			file, err := files.NewFileInfo(files.FileOptions{
+				Fs:      d.user.Fs,
+				Path:    item.Src,
+				Modify:  d.user.Perm.Modify,
+				Checker: d,
+			})
+			if err != nil {
+				return err
+			}
+			if err = delThumbs(b.ctx, b.fileCache, file); err != nil {
+				return err
+			}
+			if !d.server.EnableTrash || b.permanent {
+				return b.clear(item.Src)
+			}
+			trashed, err := removeResource(d, item.Src, false)
+			if err != nil {
+				return err
+			}
+			b.onUndo(func() error {
+				return getTrash(d).Restore(b.detached, trashed, trashed.Path)
+			})
+			return nil
+		case "copy":
+			dst := item.Dst
+			err := patchAction(b.ctx, item.Action, item.Src, dst, d, b.fileCache, nil)
+			if err != nil {
+				// a copy can fail half way through
+				_ = govfs.RemoveAll(b.detached, dst)
+				return err
+			}
+			b.onUndo(func() error {
+				return govfs.RemoveAll(b.detached, dst)
+			})
+			return nil
+		default:
+			src, dst := item.Src, item.Dst
+			if err := patchAction(b.ctx, item.Action, src, dst, d, b.fileCache, nil); err != nil {
+				return err
+			}
+			b.onUndo(func() error {
+				return fileutils.MoveFile(b.detached, dst, src)
+			})
+			return nil
+		}
+	}, item.Action, item.Src, item.Dst, d.user)
+}
+
+// batchHandler runs a list of copy, rename and delete items, each with the
+// checks and hooks of the single operations, and answers with the result of
+// every item.
+func batchHandler(fileCache FileCache) handleFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		if r.Body == nil {
+			return http.StatusBadRequest, errors.ErrEmptyRequest
+		}
+		defer r.Body.Close()
+		var req batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("failed to decode body: %w", err)
+		}
+		switch req.Conflict {
+		case "":
+			req.Conflict = conflictFail
+		case conflictFail, conflictOverwrite, conflictSkip, conflictRename:
+		default:
+			return http.StatusBadRequest, fmt.Errorf("conflict %q: %w", req.Conflict, errors.ErrInvalidRequestParams)
+		}
+		if len(req.Items) == 0 {
+			return http.StatusBadRequest, errors.ErrInvalidRequestParams
+		}
+
+		b := &batch{
+			ctx:       r.Context(),
+			d:         d,
+			fileCache: fileCache,
+			rollback:  req.Rollback,
+			permanent: req.Permanent,
+			stashID:   strconv.FormatInt(time.Now().UnixNano(), 36), //nolint:gomnd
+			detached:  govfs.WithContext(d.user.Fs, context.Background()),
+		}
+		resp := &batchResponse{Results: make([]*batchResult, len(req.Items))}
+		failed := -1
+		for i := range req.Items {
+			result := &batchResult{batchItem: req.Items[i], Result: batchNotRun}
+			resp.Results[i] = result
+			if failed >= 0 {
+				continue
+			}
+
+			skip, exists, err := b.check(&result.batchItem, req.Conflict)
+			if err == nil && skip {
+				result.Result = batchSkipped
+				continue
+			}
+			if err == nil {
+				b.item = i
+				err = b.run(&result.batchItem, exists)
+			}
+			if err != nil {
+				result.Result, result.Error = batchFailed, err.Error()
+				if req.Rollback {
+					failed = i
+				}
+				continue
+			}
+			result.Result = batchDone
+		}
+
+		if failed < 0 {
+			b.removeStash()
+			return renderJSON(w, r, resp)
+		}
+
+		// undo everything, including what the failed item did before
+		// failing, such as stashing the destination it overwrote
+		errs := b.rollBack()
+		for i := 0; i <= failed; i++ {
+			result := resp.Results[i]
+			if len(errs[i]) == 0 {
+				if result.Result == batchDone {
+					result.Result = batchRolledBack
+				}
+				continue
+			}
+			if result.Result == batchDone {
+				result.Result = batchRollbackFailed
+			}
+			for _, err := range errs[i] {
+				if result.Error != "" {
+					result.Error += "; "
+				}
+				result.Error += "rollback: " + err.Error()
+			}
+		}
+		resp.RolledBack = len(errs) == 0
+		// what could not be put back stays in the stash
+		if resp.RolledBack {
+			b.removeStash()
+		}
+
+		return renderJSON(w, r, resp)
+	})
+}
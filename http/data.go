@@ -11,7 +11,9 @@ import (
 	"github.com/filebrowser/filebrowser/v2/runner"
 	"github.com/filebrowser/filebrowser/v2/settings"
 	"github.com/filebrowser/filebrowser/v2/storage"
+	"github.com/filebrowser/filebrowser/v2/trash"
 	"github.com/filebrowser/filebrowser/v2/users"
+	"github.com/filebrowser/filebrowser/v2/versions"
 )
 
 type handleFunc func(w http.ResponseWriter, r *http.Request, d *data) (int, error)
@@ -27,6 +29,11 @@ type data struct {
 
 // Check implements rules.Checker.
 func (d *data) Check(path string) bool {
+	// the trash area and the versions are only reached through their API
+	if trash.IsTrashPath(path) || versions.IsVersionsPath(path) {
+		return false
+	}
+
 	if d.user.HideDotfiles && rules.MatchHidden(path) {
 		return false
 	}
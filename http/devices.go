@@ -0,0 +1,173 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"github.com/filebrowser/filebrowser/v2/device"
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/users"
+)
+
+// deviceInfo is a device as listed by the API. Devices given on the command
+// line are attached without being stored.
+type deviceInfo struct {
+	*device.Device
+	Stored    bool         `json:"stored"`
+	Attached  bool         `json:"attached"`
+	Connected bool         `json:"connected"`
+	Error     string       `json:"error,omitempty"`
+	Usage     *govfs.Usage `json:"usage,omitempty"`
+}
+
+// listDevices returns the stored and attached devices, sorted by name, with
+// the state of the attached ones.
+func listDevices(r *http.Request, d *data) ([]*deviceInfo, error) {
+	stored, err := d.store.Devices.All()
+	if err != nil && err != errors.ErrNotExist {
+		return nil, err
+	}
+
+	infos := map[string]*deviceInfo{}
+	for _, dev := range stored {
+		infos[dev.Name] = &deviceInfo{Device: dev, Stored: true}
+	}
+	for name, status := range users.DeviceStatuses(r.Context()) {
+		info, ok := infos[name]
+		if !ok {
+			info = &deviceInfo{Device: &device.Device{Name: name, Addr: status.Addr}}
+			infos[name] = info
+		}
+		info.Attached = true
+		info.Connected = status.Connected
+		info.Error = status.Error
+		info.Usage = status.Usage
+	}
+
+	list := make([]*deviceInfo, 0, len(infos))
+	for _, info := range infos {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func getDeviceInfo(r *http.Request, d *data, name string) (*deviceInfo, error) {
+	list, err := listDevices(r, d)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range list {
+		if info.Name == name {
+			return info, nil
+		}
+	}
+	return nil, errors.ErrNotExist
+}
+
+func decodeDevice(r *http.Request) (*device.Device, error) {
+	if r.Body == nil {
+		return nil, errors.ErrEmptyRequest
+	}
+	defer r.Body.Close()
+	var dev device.Device
+	if err := json.NewDecoder(r.Body).Decode(&dev); err != nil {
+		return nil, fmt.Errorf("failed to decode body: %w", err)
+	}
+	return &dev, nil
+}
+
+var deviceListHandler = withAdmin(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	list, err := listDevices(r, d)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return renderJSON(w, r, list)
+})
+
+var deviceGetHandler = withAdmin(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	info, err := getDeviceInfo(r, d, mux.Vars(r)["name"])
+	if err != nil {
+		return errToStatus(err), err
+	}
+	return renderJSON(w, r, info)
+})
+
+// devicePostHandler stores a new device and attaches it unless it is
+// disabled.
+var devicePostHandler = withAdmin(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	dev, err := decodeDevice(r)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	for _, name := range users.Devices() {
+		if name == dev.Name {
+			return http.StatusConflict, errors.ErrExist
+		}
+	}
+	if err = d.store.Devices.Create(dev); err != nil {
+		return errToStatus(err), err
+	}
+	if !dev.Disabled {
+		if err = dev.Attach(); err != nil {
+			return errToStatus(err), err
+		}
+	}
+
+	info, err := getDeviceInfo(r, d, dev.Name)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	return renderJSON(w, r, info)
+})
+
+// devicePutHandler replaces a device, attaching it again or detaching it
+// when it is disabled. Devices given on the command line are stored by it.
+var devicePutHandler = withAdmin(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	dev, err := decodeDevice(r)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	dev.Name = mux.Vars(r)["name"]
+	if _, err = getDeviceInfo(r, d, dev.Name); err != nil {
+		return errToStatus(err), err
+	}
+	if err = d.store.Devices.Save(dev); err != nil {
+		return errToStatus(err), err
+	}
+	if dev.Disabled {
+		err = users.RemoveDevice(dev.Name)
+		if err == errors.ErrNotExist {
+			err = nil
+		}
+	} else {
+		err = dev.Attach()
+	}
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	info, err := getDeviceInfo(r, d, dev.Name)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	return renderJSON(w, r, info)
+})
+
+// deviceDeleteHandler detaches a device and deletes it from the storage.
+var deviceDeleteHandler = withAdmin(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	name := mux.Vars(r)["name"]
+	detachErr := users.RemoveDevice(name)
+	err := d.store.Devices.Delete(name)
+	if err == errors.ErrNotExist && detachErr == nil {
+		err = nil
+	}
+	return errToStatus(err), err
+})
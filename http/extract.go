@@ -0,0 +1,229 @@
+package http
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v3"
+
+	libErrors "github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/jobs"
+	"github.com/filebrowser/filebrowser/v2/versions"
+)
+
+// archiveAlgos are the algo values accepted by archiveFormat.
+var archiveAlgos = []string{"zip", "tar", "targz", "tarbz2", "tarxz", "tarlz4", "tarsz"}
+
+// archiveAlgoOf returns the algo of the archive name, after its extension.
+func archiveAlgoOf(name string) (algo, ext string, ok bool) {
+	lower := strings.ToLower(name)
+	for _, algo := range archiveAlgos {
+		ext, _, _ := archiveFormat(algo)
+		if strings.HasSuffix(lower, ext) {
+			return algo, ext, true
+		}
+	}
+	return "", "", false
+}
+
+// archiveDestination returns the default destination of the extract and
+// compress actions on src: the archive name without its extension, or src
+// with the extension of algo.
+func archiveDestination(action, src, algo string) string {
+	src = path.Clean(src)
+	if action == "compress" {
+		ext, _, err := archiveFormat(algo)
+		if err != nil {
+			return src
+		}
+		return src + ext
+	}
+	if _, ext, ok := archiveAlgoOf(src); ok && len(ext) < len(path.Base(src)) {
+		return src[:len(src)-len(ext)]
+	}
+	return strings.TrimSuffix(src, path.Ext(src))
+}
+
+// extractFormat returns the reader of the archive format named algo.
+func extractFormat(algo string) (archiver.Reader, error) {
+	_, ar, err := archiveFormat(algo)
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := ar.(archiver.Reader)
+	if !ok {
+		return nil, fmt.Errorf("format %s cannot be extracted", algo)
+	}
+	return reader, nil
+}
+
+// archiveEntryPath returns the path of an archive entry relative to the
+// extraction directory, or an error for the entries escaping it.
+func archiveEntryPath(f archiver.File) (string, error) {
+	// the name of the file info is the base name of the entry
+	name := f.Name()
+	switch header := f.Header.(type) {
+	case zip.FileHeader:
+		name = header.Name
+	case *tar.Header:
+		name = header.Name
+	}
+
+	name = strings.ReplaceAll(name, "\\", "/")
+	clean := path.Clean(name)
+	if path.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("archive entry %s: %w", name, libErrors.ErrPermissionDenied)
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+// extraction writes the entries of an archive below dst, staged in target
+// until the extraction is complete when dst is new.
+type extraction struct {
+	ctx      context.Context
+	d        *data
+	dst      string
+	target   string
+	progress *jobs.Progress
+
+	maxSize  int64
+	maxFiles int
+	size     int64
+	files    int
+}
+
+// extractReader reads the content of the entry being written, enforcing
+// the size limit on what is actually extracted rather than on the sizes
+// announced by the archive.
+type extractReader struct {
+	x *extraction
+	r io.Reader
+}
+
+func (e *extractReader) Read(p []byte) (int, error) {
+	if err := e.x.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := e.r.Read(p)
+	e.x.size += int64(n)
+	e.x.progress.Add(int64(n), 0)
+	if e.x.maxSize > 0 && e.x.size > e.x.maxSize {
+		return n, libErrors.ErrArchiveTooLarge
+	}
+	return n, err
+}
+
+func (x *extraction) entry(f archiver.File) error {
+	rel, err := archiveEntryPath(f)
+	if err != nil || rel == "" {
+		return err
+	}
+	// the rules apply to where the entry ends up
+	if !x.d.Check(path.Join(x.dst, rel)) {
+		return nil
+	}
+	name := path.Join(x.target, rel)
+
+	switch {
+	case f.IsDir():
+		return x.d.user.Fs.MkdirAll(name, 0775) //nolint:gomnd
+	case f.Mode().IsRegular():
+		x.files++
+		if x.maxFiles > 0 && x.files > x.maxFiles {
+			return libErrors.ErrArchiveTooLarge
+		}
+		if err = x.d.user.Fs.MkdirAll(path.Dir(name), 0775); err != nil { //nolint:gomnd
+			return err
+		}
+		// the files of an existing destination are overwritten
+		if x.target == x.dst {
+			if err = versions.Save(x.d.user.Fs, name, x.d.server.Versions); err != nil {
+				return err
+			}
+		}
+		if err = govfs.WriteFile(x.d.user.Fs, name, &extractReader{x: x, r: f}, f.Mode().Perm()|0600); err != nil { //nolint:gomnd
+			return err
+		}
+		x.progress.Add(0, 1)
+		return nil
+	default:
+		// links could point out of the destination
+		return nil
+	}
+}
+
+// runExtractJob extracts the archive src into the directory dst, over its
+// files when dst exists.
+func runExtractJob(ctx context.Context, d *data, src, dst, algo string, progress *jobs.Progress) error {
+	reader, err := extractFormat(algo)
+	if err != nil {
+		return err
+	}
+	fd, err := d.user.Fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if err = reader.Open(fd, info.Size()); err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	x := &extraction{
+		ctx:      ctx,
+		d:        d,
+		dst:      dst,
+		target:   dst,
+		progress: progress,
+		maxSize:  d.server.ExtractMaxSize,
+		maxFiles: d.server.ExtractMaxFiles,
+	}
+	_, err = d.user.Fs.Stat(dst)
+	fresh := errors.Is(err, os.ErrNotExist)
+	if fresh {
+		x.target = govfs.TempName(dst)
+	}
+	if err = d.user.Fs.MkdirAll(x.target, 0775); err != nil { //nolint:gomnd
+		return err
+	}
+
+	for err == nil {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		var f archiver.File
+		if f, err = reader.Read(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+		err = x.entry(f)
+		f.Close()
+	}
+
+	if fresh {
+		if err == nil {
+			err = d.user.Fs.Rename(x.target, dst)
+		}
+		if err != nil {
+			_ = govfs.RemoveAll(d.user.Fs, x.target)
+		}
+	}
+	return err
+}
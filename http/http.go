@@ -6,6 +6,7 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/filebrowser/filebrowser/v2/jobs"
 	"github.com/filebrowser/filebrowser/v2/settings"
 	"github.com/filebrowser/filebrowser/v2/storage"
 )
@@ -24,6 +25,11 @@ func NewHandler(
 ) (http.Handler, error) {
 	server.Clean()
 
+	jobManager, err := jobs.NewManager(store.Jobs)
+	if err != nil {
+		return nil, err
+	}
+
 	r := mux.NewRouter()
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -60,14 +66,20 @@ func NewHandler(
 	users.Handle("/{id:[0-9]+}", monkey(userDeleteHandler, "")).Methods("DELETE")
 
 	api.PathPrefix("/resources").Handler(monkey(resourceGetHandler, "/api/resources")).Methods("GET")
-	api.PathPrefix("/resources").Handler(monkey(resourceDeleteHandler(fileCache), "/api/resources")).Methods("DELETE")
+	api.PathPrefix("/resources").Handler(monkey(resourceDeleteHandler(fileCache, jobManager), "/api/resources")).Methods("DELETE")
 	api.PathPrefix("/resources").Handler(monkey(resourcePostHandler(fileCache), "/api/resources")).Methods("POST")
 	api.PathPrefix("/resources").Handler(monkey(resourcePutHandler, "/api/resources")).Methods("PUT")
-	api.PathPrefix("/resources").Handler(monkey(resourcePatchHandler(fileCache), "/api/resources")).Methods("PATCH")
+	api.PathPrefix("/resources").Handler(monkey(resourcePatchHandler(fileCache, jobManager), "/api/resources")).Methods("PATCH")
+
+	api.PathPrefix("/versions").Handler(monkey(versionsGetHandler, "/api/versions")).Methods("GET")
+	api.PathPrefix("/versions").Handler(monkey(versionsRestoreHandler, "/api/versions")).Methods("POST")
 
-	api.PathPrefix("/tus").Handler(monkey(tusPostHandler(), "/api/tus")).Methods("POST")
-	api.PathPrefix("/tus").Handler(monkey(tusHeadHandler(), "/api/tus")).Methods("HEAD", "GET")
-	api.PathPrefix("/tus").Handler(monkey(tusPatchHandler(), "/api/tus")).Methods("PATCH")
+	uploads := newTusUploads()
+	api.Handle("/batch", monkey(batchHandler(fileCache), "")).Methods("POST")
+
+	api.PathPrefix("/tus").Handler(monkey(tusPostHandler(uploads), "/api/tus")).Methods("POST")
+	api.PathPrefix("/tus").Handler(monkey(tusHeadHandler(uploads), "/api/tus")).Methods("HEAD", "GET")
+	api.PathPrefix("/tus").Handler(monkey(tusPatchHandler(uploads), "/api/tus")).Methods("PATCH")
 
 	api.PathPrefix("/usage").Handler(monkey(diskUsage, "/api/usage")).Methods("GET")
 
@@ -76,6 +88,35 @@ func NewHandler(
 	api.PathPrefix("/share").Handler(monkey(sharePostHandler, "/api/share")).Methods("POST")
 	api.PathPrefix("/share").Handler(monkey(shareDeleteHandler, "/api/share")).Methods("DELETE")
 
+	snapshots := api.PathPrefix("/snapshots").Subrouter()
+	snapshots.Handle("", monkey(snapshotListHandler, "")).Methods("GET")
+	snapshots.Handle("", monkey(snapshotPostHandler, "")).Methods("POST")
+	snapshots.Handle("/{name}", monkey(snapshotGetHandler, "")).Methods("GET")
+	snapshots.Handle("/{name}", monkey(snapshotDeleteHandler, "")).Methods("DELETE")
+	snapshots.Handle("/{name}/diff", monkey(snapshotDiffHandler, "")).Methods("GET")
+
+	devices := api.PathPrefix("/devices").Subrouter()
+	devices.Handle("", monkey(deviceListHandler, "")).Methods("GET")
+	devices.Handle("", monkey(devicePostHandler, "")).Methods("POST")
+	devices.Handle("/{name}", monkey(deviceGetHandler, "")).Methods("GET")
+	devices.Handle("/{name}", monkey(devicePutHandler, "")).Methods("PUT")
+	devices.Handle("/{name}", monkey(deviceDeleteHandler, "")).Methods("DELETE")
+
+	trashRouter := api.PathPrefix("/trash").Subrouter()
+	trashRouter.Handle("", monkey(trashListHandler, "")).Methods("GET")
+	trashRouter.Handle("", monkey(trashEmptyHandler, "")).Methods("DELETE")
+	trashRouter.Handle("/{id:[0-9]+}", monkey(trashPurgeHandler, "")).Methods("DELETE")
+	trashRouter.Handle("/{id:[0-9]+}/restore", monkey(trashRestoreHandler, "")).Methods("POST")
+
+	jobsRouter := api.PathPrefix("/jobs").Subrouter()
+	jobsRouter.Handle("", monkey(jobListHandler(jobManager), "")).Methods("GET")
+	jobsRouter.Handle("", monkey(jobPostHandler(jobManager, fileCache), "")).Methods("POST")
+	jobsRouter.Handle("/events", monkey(jobEventsHandler(jobManager), "")).Methods("GET")
+	jobsRouter.Handle("/{id:[0-9]+}", monkey(jobGetHandler(jobManager), "")).Methods("GET")
+	jobsRouter.Handle("/{id:[0-9]+}", monkey(jobDeleteHandler(jobManager), "")).Methods("DELETE")
+	jobsRouter.Handle("/{id:[0-9]+}/cancel", monkey(jobCancelHandler(jobManager), "")).Methods("POST")
+	jobsRouter.Handle("/{id:[0-9]+}/retry", monkey(jobRetryHandler(jobManager, fileCache), "")).Methods("POST")
+
 	api.Handle("/settings", monkey(settingsGetHandler, "")).Methods("GET")
 	api.Handle("/settings", monkey(settingsPutHandler, "")).Methods("PUT")
 
@@ -0,0 +1,402 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/files"
+	"github.com/filebrowser/filebrowser/v2/fileutils"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/jobs"
+)
+
+// withJob loads the job whose id is in the URL, which must belong to the
+// user unless they are an admin.
+func withJob(manager *jobs.Manager, fn func(w http.ResponseWriter, r *http.Request, d *data, job *jobs.Job) (int, error)) handleFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+		if err != nil {
+			return http.StatusNotFound, err
+		}
+		job, err := manager.Get(uint(id))
+		if err != nil {
+			return errToStatus(err), err
+		}
+		if job.UserID != d.user.ID && !d.user.Perm.Admin {
+			return http.StatusForbidden, nil
+		}
+		return fn(w, r, d, job)
+	})
+}
+
+// prepareJob checks that the user can run the job and returns the function
+// running it. A retried job keeps its destination, which may hold what the
+// failed attempt left.
+//
+//nolint:gocyclo
+func prepareJob(d *data, fileCache FileCache, job *jobs.Job, retry bool) (jobs.RunFunc, int, error) {
+	if len(job.Sources) == 0 {
+		return nil, http.StatusBadRequest, errors.ErrInvalidRequestParams
+	}
+	for i, src := range job.Sources {
+		src = path.Clean("/" + src)
+		if src == "/" || !d.Check(src) {
+			return nil, http.StatusForbidden, nil
+		}
+		job.Sources[i] = src
+	}
+
+	if job.Action == "extract" && job.Algo == "" && len(job.Sources) == 1 {
+		algo, _, ok := archiveAlgoOf(job.Sources[0])
+		if !ok {
+			return nil, http.StatusBadRequest, fmt.Errorf("unknown archive format: %w", errors.ErrInvalidRequestParams)
+		}
+		job.Algo = algo
+	}
+
+	switch job.Action {
+	case "copy", "rename", "archive", "extract":
+		if job.Destination == "" || (job.Action != "archive" && len(job.Sources) != 1) {
+			return nil, http.StatusBadRequest, errors.ErrInvalidRequestParams
+		}
+		dst := path.Clean("/" + job.Destination)
+		if dst == "/" || !d.Check(dst) {
+			return nil, http.StatusForbidden, nil
+		}
+		for _, src := range job.Sources {
+			if err := checkParent(src, dst); err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+		}
+		if !retry {
+			if !job.Override && !job.Rename {
+				if _, err := d.user.Fs.Stat(dst); err == nil {
+					return nil, http.StatusConflict, nil
+				}
+			}
+			if job.Rename {
+				dst = addVersionSuffix(dst, d.user.Fs)
+			}
+		}
+		if job.Override && !d.user.Perm.Modify {
+			return nil, http.StatusForbidden, nil
+		}
+		job.Destination = dst
+	case "delete":
+		if !d.user.Perm.Delete {
+			return nil, http.StatusForbidden, nil
+		}
+		job.Destination = ""
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("unsupported action %s: %w", job.Action, errors.ErrInvalidRequestParams)
+	}
+
+	switch job.Action {
+	case "copy":
+		if !d.user.Perm.Create {
+			return nil, http.StatusForbidden, nil
+		}
+	case "rename":
+		if !d.user.Perm.Rename {
+			return nil, http.StatusForbidden, nil
+		}
+	case "archive":
+		if !d.user.Perm.Create {
+			return nil, http.StatusForbidden, nil
+		}
+		if _, _, err := archiveFormat(job.Algo); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("%v: %w", err, errors.ErrInvalidRequestParams)
+		}
+	case "extract":
+		if !d.user.Perm.Create {
+			return nil, http.StatusForbidden, nil
+		}
+		if _, err := extractFormat(job.Algo); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("%v: %w", err, errors.ErrInvalidRequestParams)
+		}
+	}
+
+	action, sources, dst, algo, permanent := job.Action, job.Sources, job.Destination, job.Algo, job.Permanent
+	return func(ctx context.Context, progress *jobs.Progress) error {
+		// the job outlives the request, and runs in its own context
+		jd := *d
+		user := *d.user
+		user.Fs = govfs.WithContext(user.Fs, ctx)
+		jd.user = &user
+
+		switch action {
+		case "delete":
+			return runDeleteJob(ctx, &jd, fileCache, sources, permanent, progress)
+		case "archive":
+			return runArchiveJob(ctx, &jd, sources, dst, algo, progress)
+		case "extract":
+			return jd.RunHook(func() error {
+				return runExtractJob(ctx, &jd, sources[0], dst, algo, progress)
+			}, action, sources[0], dst, &user)
+		default:
+			if size, err := govfs.TreeSize(user.Fs, sources[0]); err == nil {
+				progress.SetTotal(size)
+			}
+			return jd.RunHook(func() error {
+				return patchAction(ctx, action, sources[0], dst, &jd, fileCache, progress.Add)
+			}, action, sources[0], dst, &user)
+		}
+	}, 0, nil
+}
+
+func runDeleteJob(ctx context.Context, d *data, fileCache FileCache, names []string, permanent bool, progress *jobs.Progress) error {
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file, err := files.NewFileInfo(files.FileOptions{
+			Fs:      d.user.Fs,
+			Path:    name,
+			Modify:  d.user.Perm.Modify,
+			Checker: d,
+		})
+		if err != nil {
+			return err
+		}
+		if err = delThumbs(ctx, fileCache, file); err != nil {
+			return err
+		}
+		err = d.RunHook(func() error {
+			_, err := removeResource(d, name, permanent) //nolint:govet
+			return err
+		}, "delete", name, "", d.user)
+		if err != nil {
+			return err
+		}
+		progress.Add(0, 1)
+	}
+	return nil
+}
+
+// runArchiveJob writes an archive of names to dst, atomically.
+func runArchiveJob(ctx context.Context, d *data, names []string, dst, algo string, progress *jobs.Progress) error {
+	_, ar, err := archiveFormat(algo)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, name := range names {
+		if size, err := govfs.TreeSize(d.user.Fs, name); err == nil { //nolint:govet
+			total += size
+		}
+	}
+	progress.SetTotal(total)
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := ar.Create(pw)
+		if err == nil {
+			commonDir := fileutils.CommonPrefix(filepath.Separator, names...)
+			p := &archiveProgress{ctx: ctx, progress: progress}
+			for _, name := range names {
+				if err = addFile(ar, d, name, commonDir, p); err != nil {
+					break
+				}
+			}
+			if cerr := ar.Close(); err == nil {
+				err = cerr
+			}
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	err = d.RunHook(func() error {
+		if err := d.user.Fs.MkdirAll(path.Dir(dst), 0775); err != nil { //nolint:gomnd,govet
+			return err
+		}
+		return govfs.WriteFile(d.user.Fs, dst, pr, 0775) //nolint:gomnd
+	}, "archive", names[0], dst, d.user)
+	// unblock the archive writer when the file could not be written
+	_ = pr.CloseWithError(err)
+	return err
+}
+
+// submitJob starts a job for the user and answers with it.
+func submitJob(w http.ResponseWriter, r *http.Request, d *data, manager *jobs.Manager, fileCache FileCache, job *jobs.Job) (int, error) {
+	run, status, err := prepareJob(d, fileCache, job, false)
+	if err != nil || status != 0 {
+		return status, err
+	}
+	job.UserID = d.user.ID
+	if err = manager.Submit(job, run); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	if err = json.NewEncoder(w).Encode(job); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func jobListHandler(manager *jobs.Manager) handleFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		list, err := manager.List(d.user.ID, d.user.Perm.Admin)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].ID < list[j].ID
+		})
+		return renderJSON(w, r, list)
+	})
+}
+
+func jobGetHandler(manager *jobs.Manager) handleFunc {
+	return withJob(manager, func(w http.ResponseWriter, r *http.Request, d *data, job *jobs.Job) (int, error) {
+		return renderJSON(w, r, job)
+	})
+}
+
+// jobPostHandler starts a job described by the body, with the fields
+// action, sources, destination, override, rename and algo.
+func jobPostHandler(manager *jobs.Manager, fileCache FileCache) handleFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		if r.Body == nil {
+			return http.StatusBadRequest, errors.ErrEmptyRequest
+		}
+		defer r.Body.Close()
+		var body jobs.Job
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("failed to decode body: %w", err)
+		}
+		return submitJob(w, r, d, manager, fileCache, &jobs.Job{
+			Action:      body.Action,
+			Sources:     body.Sources,
+			Destination: body.Destination,
+			Override:    body.Override,
+			Rename:      body.Rename,
+			Algo:        body.Algo,
+		})
+	})
+}
+
+func jobCancelHandler(manager *jobs.Manager) handleFunc {
+	return withJob(manager, func(w http.ResponseWriter, r *http.Request, d *data, job *jobs.Job) (int, error) {
+		if err := manager.Cancel(job.ID); err != nil {
+			return errToStatus(err), err
+		}
+		job, err := manager.Get(job.ID)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		return renderJSON(w, r, job)
+	})
+}
+
+// jobRetryHandler runs a failed or canceled job again. Only its owner can
+// retry it, since it runs with their permissions.
+func jobRetryHandler(manager *jobs.Manager, fileCache FileCache) handleFunc {
+	return withJob(manager, func(w http.ResponseWriter, r *http.Request, d *data, job *jobs.Job) (int, error) {
+		if job.UserID != d.user.ID {
+			return http.StatusForbidden, nil
+		}
+		if job.State != jobs.StateFailed && job.State != jobs.StateCanceled {
+			return http.StatusConflict, errors.ErrInvalidJobState
+		}
+		run, status, err := prepareJob(d, fileCache, job, true)
+		if err != nil || status != 0 {
+			return status, err
+		}
+		job, err = manager.Retry(job.ID, run)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		return renderJSON(w, r, job)
+	})
+}
+
+// jobDeleteHandler cancels a job if it still runs and deletes it.
+func jobDeleteHandler(manager *jobs.Manager) handleFunc {
+	return withJob(manager, func(w http.ResponseWriter, r *http.Request, d *data, job *jobs.Job) (int, error) {
+		err := manager.Delete(job.ID)
+		return errToStatus(err), err
+	})
+}
+
+// jobEventsHandler streams the updates of the jobs of the user, or of every
+// user for admins, as server-sent events. The current state of the jobs is
+// sent first. With the id query parameter, only the updates of that job are
+// sent, and the stream ends once it is finished.
+func jobEventsHandler(manager *jobs.Manager) handleFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			return http.StatusInternalServerError, fmt.Errorf("streaming is not supported")
+		}
+		var only uint64
+		if id := r.URL.Query().Get("id"); id != "" {
+			var err error
+			if only, err = strconv.ParseUint(id, 10, 0); err != nil {
+				return http.StatusBadRequest, err
+			}
+		}
+		visible := func(job *jobs.Job) bool {
+			if only != 0 && uint64(job.ID) != only {
+				return false
+			}
+			return job.UserID == d.user.ID || d.user.Perm.Admin
+		}
+
+		// subscribe first, so that no update is lost in between
+		updates, unsubscribe := manager.Subscribe()
+		defer unsubscribe()
+		list, err := manager.List(d.user.ID, d.user.Perm.Admin)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		send := func(job *jobs.Job) (finished bool, err error) {
+			data, err := json.Marshal(job)
+			if err != nil {
+				return false, err
+			}
+			if _, err = fmt.Fprintf(w, "event: job\ndata: %s\n\n", data); err != nil {
+				return false, err
+			}
+			flusher.Flush()
+			return only != 0 && job.State.Finished(), nil
+		}
+
+		for _, job := range list {
+			if !visible(job) {
+				continue
+			}
+			if finished, err := send(job); err != nil || finished { //nolint:govet
+				return 0, err
+			}
+		}
+		for {
+			select {
+			case <-r.Context().Done():
+				return 0, nil
+			case job := <-updates:
+				if !visible(&job) {
+					continue
+				}
+				if finished, err := send(&job); err != nil || finished {
+					return 0, err
+				}
+			}
+		}
+	})
+}
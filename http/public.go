@@ -1,6 +1,7 @@
 package http
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
@@ -12,7 +13,9 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/filebrowser/filebrowser/v2/files"
+	"github.com/filebrowser/filebrowser/v2/govfs"
 	"github.com/filebrowser/filebrowser/v2/share"
+	"github.com/filebrowser/filebrowser/v2/users"
 )
 
 var withHashFile = func(fn handleFunc) handleFunc {
@@ -34,6 +37,7 @@ var withHashFile = func(fn handleFunc) handleFunc {
 		}
 
 		d.user = user
+		d.user.Fs = govfs.WithContext(d.user.Fs, fsContext(r))
 
 		file, err := files.NewFileInfo(files.FileOptions{
 			Fs:         d.user.Fs,
@@ -142,7 +146,34 @@ func authenticateShareRequest(r *http.Request, l *share.Link) (int, error) {
 	return 0, nil
 }
 
+type backendHealth struct {
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status   string                   `json:"status"`
+	Backends map[string]backendHealth `json:"backends,omitempty"`
+}
+
 func healthHandler(w http.ResponseWriter, _ *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"status":"OK"}`))
+	status := http.StatusOK
+	resp := healthResponse{Status: "OK"}
+
+	for name, err := range users.BackendHealth() {
+		if resp.Backends == nil {
+			resp.Backends = map[string]backendHealth{}
+		}
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			resp.Status = "UNAVAILABLE"
+			resp.Backends[name] = backendHealth{Connected: false, Error: err.Error()}
+			continue
+		}
+		resp.Backends[name] = backendHealth{Connected: true}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
 }
@@ -1,7 +1,9 @@
 package http
 
 import (
+	"context"
 	"errors"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
@@ -13,6 +15,7 @@ import (
 
 	"github.com/filebrowser/filebrowser/v2/files"
 	"github.com/filebrowser/filebrowser/v2/fileutils"
+	"github.com/filebrowser/filebrowser/v2/jobs"
 	"github.com/filebrowser/filebrowser/v2/users"
 )
 
@@ -44,10 +47,16 @@ func parseQueryFiles(r *http.Request, f *files.FileInfo, _ *users.User) ([]strin
 	return fileSlice, nil
 }
 
-// nolint: goconst,nolintlint
 func parseQueryAlgorithm(r *http.Request) (string, archiver.Writer, error) {
+	return archiveFormat(r.URL.Query().Get("algo"))
+}
+
+// archiveFormat returns the extension and the writer of the archive format
+// named algo.
+// nolint: goconst,nolintlint
+func archiveFormat(algo string) (string, archiver.Writer, error) {
 	// TODO: use enum
-	switch r.URL.Query().Get("algo") {
+	switch algo {
 	case "zip", "true", "":
 		return ".zip", archiver.NewZip(), nil
 	case "tar":
@@ -105,10 +114,37 @@ var rawHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data)
 	return rawDirHandler(w, r, d, file)
 })
 
-func addFile(ar archiver.Writer, d *data, path, commonPath string) error {
+// archiveProgress reports the files added to an archive by a job, and
+// stops adding them once the context of the job is done.
+type archiveProgress struct {
+	ctx      context.Context
+	progress *jobs.Progress
+}
+
+type archiveReader struct {
+	io.ReadCloser
+	p *archiveProgress
+}
+
+func (r *archiveReader) Read(b []byte) (int, error) {
+	if err := r.p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.ReadCloser.Read(b)
+	r.p.progress.Add(int64(n), 0)
+	return n, err
+}
+
+// addFile adds path to the archive. progress is nil outside of jobs.
+func addFile(ar archiver.Writer, d *data, path, commonPath string, progress *archiveProgress) error {
 	if !d.Check(path) {
 		return nil
 	}
+	if progress != nil {
+		if err := progress.ctx.Err(); err != nil {
+			return err
+		}
+	}
 
 	info, err := d.user.Fs.Stat(path)
 	if err != nil {
@@ -126,6 +162,10 @@ func addFile(ar archiver.Writer, d *data, path, commonPath string) error {
 	defer file.Close()
 
 	if path != commonPath {
+		var reader io.ReadCloser = file
+		if progress != nil {
+			reader = &archiveReader{ReadCloser: file, p: progress}
+		}
 		filename := strings.TrimPrefix(path, commonPath)
 		filename = strings.TrimPrefix(filename, string(filepath.Separator))
 		err = ar.Write(archiver.File{
@@ -133,11 +173,14 @@ func addFile(ar archiver.Writer, d *data, path, commonPath string) error {
 				FileInfo:   info,
 				CustomName: filename,
 			},
-			ReadCloser: file,
+			ReadCloser: reader,
 		})
 		if err != nil {
 			return err
 		}
+		if progress != nil && !info.IsDir() {
+			progress.progress.Add(0, 1)
+		}
 	}
 
 	if info.IsDir() {
@@ -148,8 +191,15 @@ func addFile(ar archiver.Writer, d *data, path, commonPath string) error {
 
 		for _, name := range names {
 			fPath := filepath.Join(path, name)
-			err = addFile(ar, d, fPath, commonPath)
+			err = addFile(ar, d, fPath, commonPath, progress)
+			if progress != nil && progress.ctx.Err() != nil {
+				return progress.ctx.Err()
+			}
 			if err != nil {
+				// a job must not report an incomplete archive as done
+				if progress != nil {
+					return err
+				}
 				log.Printf("Failed to archive %s: %v", fPath, err)
 			}
 		}
@@ -190,7 +240,7 @@ func rawDirHandler(w http.ResponseWriter, r *http.Request, d *data, file *files.
 	w.Header().Set("Content-Disposition", "attachment; filename*=utf-8''"+url.PathEscape(name))
 
 	for _, fname := range filenames {
-		err = addFile(ar, d, fname, commonDir)
+		err = addFile(ar, d, fname, commonDir, nil)
 		if err != nil {
 			log.Printf("Failed to archive %s: %v", fname, err)
 		}
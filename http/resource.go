@@ -11,12 +11,14 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/spf13/afero"
 
 	"github.com/filebrowser/filebrowser/v2/errors"
 	"github.com/filebrowser/filebrowser/v2/files"
 	"github.com/filebrowser/filebrowser/v2/fileutils"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/jobs"
+	"github.com/filebrowser/filebrowser/v2/versions"
 )
 
 var resourceGetHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
@@ -54,7 +56,7 @@ var resourceGetHandler = withUser(func(w http.ResponseWriter, r *http.Request, d
 	return renderJSON(w, r, file)
 })
 
-func resourceDeleteHandler(fileCache FileCache) handleFunc {
+func resourceDeleteHandler(fileCache FileCache, manager *jobs.Manager) handleFunc {
 	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
 		if r.URL.Path == "/" || !d.user.Perm.Delete {
 			return http.StatusForbidden, nil
@@ -72,6 +74,15 @@ func resourceDeleteHandler(fileCache FileCache) handleFunc {
 			return errToStatus(err), err
 		}
 
+		permanent := r.URL.Query().Get("permanent") == "true"
+		if r.URL.Query().Get("async") == "true" {
+			return submitJob(w, r, d, manager, fileCache, &jobs.Job{
+				Action:    "delete",
+				Sources:   []string{r.URL.Path},
+				Permanent: permanent,
+			})
+		}
+
 		// delete thumbnails
 		err = delThumbs(r.Context(), fileCache, file)
 		if err != nil {
@@ -79,7 +90,8 @@ func resourceDeleteHandler(fileCache FileCache) handleFunc {
 		}
 
 		err = d.RunHook(func() error {
-			return d.user.Fs.RemoveAll(r.URL.Path)
+			_, err := removeResource(d, r.URL.Path, permanent) //nolint:govet
+			return err
 		}, "delete", r.URL.Path, "", d.user)
 
 		if err != nil {
@@ -127,6 +139,9 @@ func resourcePostHandler(fileCache FileCache) handleFunc {
 		}
 
 		err = d.RunHook(func() error {
+			if err := versions.Save(d.user.Fs, r.URL.Path, d.server.Versions); err != nil { //nolint:govet
+				return err
+			}
 			info, writeErr := writeFile(d.user.Fs, r.URL.Path, r.Body)
 			if writeErr != nil {
 				return writeErr
@@ -137,10 +152,6 @@ func resourcePostHandler(fileCache FileCache) handleFunc {
 			return nil
 		}, "upload", r.URL.Path, "", d.user)
 
-		if err != nil {
-			_ = d.user.Fs.RemoveAll(r.URL.Path)
-		}
-
 		return errToStatus(err), err
 	})
 }
@@ -155,15 +166,14 @@ var resourcePutHandler = withUser(func(w http.ResponseWriter, r *http.Request, d
 		return http.StatusMethodNotAllowed, nil
 	}
 
-	exists, err := afero.Exists(d.user.Fs, r.URL.Path)
-	if err != nil {
-		return http.StatusInternalServerError, err
-	}
-	if !exists {
-		return http.StatusNotFound, nil
+	if _, err := d.user.Fs.Stat(r.URL.Path); err != nil {
+		return errToStatus(err), err
 	}
 
-	err = d.RunHook(func() error {
+	err := d.RunHook(func() error {
+		if err := versions.Save(d.user.Fs, r.URL.Path, d.server.Versions); err != nil {
+			return err
+		}
 		info, writeErr := writeFile(d.user.Fs, r.URL.Path, r.Body)
 		if writeErr != nil {
 			return writeErr
@@ -177,12 +187,16 @@ var resourcePutHandler = withUser(func(w http.ResponseWriter, r *http.Request, d
 	return errToStatus(err), err
 })
 
-func resourcePatchHandler(fileCache FileCache) handleFunc {
+func resourcePatchHandler(fileCache FileCache, manager *jobs.Manager) handleFunc {
 	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
 		src := r.URL.Path
 		dst := r.URL.Query().Get("destination")
 		action := r.URL.Query().Get("action")
 		dst, err := url.QueryUnescape(dst)
+		archive := action == "extract" || action == "compress"
+		if archive && dst == "" {
+			dst = archiveDestination(action, src, r.URL.Query().Get("algo"))
+		}
 		if !d.Check(src) || !d.Check(dst) {
 			return http.StatusForbidden, nil
 		}
@@ -214,8 +228,23 @@ func resourcePatchHandler(fileCache FileCache) handleFunc {
 			return http.StatusForbidden, nil
 		}
 
+		// archives are always extracted and compressed in the background
+		if archive || r.URL.Query().Get("async") == "true" {
+			job := &jobs.Job{
+				Action:      action,
+				Sources:     []string{src},
+				Destination: dst,
+				Override:    override,
+				Algo:        r.URL.Query().Get("algo"),
+			}
+			if action == "compress" {
+				job.Action = "archive"
+			}
+			return submitJob(w, r, d, manager, fileCache, job)
+		}
+
 		err = d.RunHook(func() error {
-			return patchAction(r.Context(), action, src, dst, d, fileCache)
+			return patchAction(r.Context(), action, src, dst, d, fileCache, nil)
 		}, action, src, dst, d.user)
 
 		return errToStatus(err), err
@@ -254,6 +283,8 @@ func addVersionSuffix(source string, fs afero.Fs) string {
 	return source
 }
 
+// writeFile replaces dst with the content of in. The content is written
+// atomically, an interrupted upload leaves the previous content in place.
 func writeFile(fs afero.Fs, dst string, in io.Reader) (os.FileInfo, error) {
 	dir, _ := path.Split(dst)
 	err := fs.MkdirAll(dir, 0775) //nolint:gomnd
@@ -261,19 +292,13 @@ func writeFile(fs afero.Fs, dst string, in io.Reader) (os.FileInfo, error) {
 		return nil, err
 	}
 
-	file, err := fs.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0775) //nolint:gomnd
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	_, err = io.Copy(file, in)
+	err = govfs.WriteFile(fs, dst, in, 0775) //nolint:gomnd
 	if err != nil {
 		return nil, err
 	}
 
 	// Gets the info about the file.
-	info, err := file.Stat()
+	info, err := fs.Stat(dst)
 	if err != nil {
 		return nil, err
 	}
@@ -292,7 +317,9 @@ func delThumbs(ctx context.Context, fileCache FileCache, file *files.FileInfo) e
 	return nil
 }
 
-func patchAction(ctx context.Context, action, src, dst string, d *data, fileCache FileCache) error {
+// patchAction runs a copy or rename, reporting the progress of copies to
+// progress, which may be nil.
+func patchAction(ctx context.Context, action, src, dst string, d *data, fileCache FileCache, progress fileutils.Progress) error {
 	switch action {
 	// TODO: use enum
 	case "copy":
@@ -300,7 +327,7 @@ func patchAction(ctx context.Context, action, src, dst string, d *data, fileCach
 			return errors.ErrPermissionDenied
 		}
 
-		return fileutils.Copy(d.user.Fs, src, dst)
+		return fileutils.CopyContext(ctx, d.user.Fs, src, dst, progress)
 	case "rename":
 		if !d.user.Perm.Rename {
 			return errors.ErrPermissionDenied
@@ -326,7 +353,7 @@ func patchAction(ctx context.Context, action, src, dst string, d *data, fileCach
 			return err
 		}
 
-		return fileutils.MoveFile(d.user.Fs, src, dst)
+		return fileutils.MoveFileContext(ctx, d.user.Fs, src, dst, progress)
 	default:
 		return fmt.Errorf("unsupported action %s: %w", action, errors.ErrInvalidRequestParams)
 	}
@@ -335,6 +362,7 @@ func patchAction(ctx context.Context, action, src, dst string, d *data, fileCach
 type DiskUsageResponse struct {
 	Total uint64 `json:"total"`
 	Used  uint64 `json:"used"`
+	Model string `json:"model,omitempty"`
 }
 
 var diskUsage = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
@@ -350,7 +378,6 @@ var diskUsage = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (
 	if err != nil {
 		return errToStatus(err), err
 	}
-	fPath := file.RealPath()
 	if !file.IsDir {
 		return renderJSON(w, r, &DiskUsageResponse{
 			Total: 0,
@@ -358,19 +385,15 @@ var diskUsage = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (
 		})
 	}
 
-	usage, err := disk.UsageWithContext(r.Context(), fPath)
-	if err != nil {
-		usage = &disk.UsageStat{
-			Path:        fPath,
-			Total:       0,
-			Free:        0,
-			Used:        0,
-			UsedPercent: 0,
+	usage := &govfs.Usage{}
+	if provider, ok := d.user.Fs.(govfs.UsageProvider); ok {
+		if u, err := provider.Usage(r.URL.Path); err == nil {
+			usage = u
 		}
-		// return errToStatus(err), err
 	}
 	return renderJSON(w, r, &DiskUsageResponse{
 		Total: usage.Total,
-		Used:  usage.Used,
+		Used:  usage.Used(),
+		Model: usage.Model,
 	})
 })
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/filebrowser/filebrowser/v2/govfs"
 	"github.com/filebrowser/filebrowser/v2/rules"
 	"github.com/filebrowser/filebrowser/v2/settings"
+	"github.com/filebrowser/filebrowser/v2/users"
 )
 
 type settingsData struct {
@@ -18,6 +20,8 @@ type settingsData struct {
 	Tus              settings.Tus          `json:"tus"`
 	Shell            []string              `json:"shell"`
 	Commands         map[string][]string   `json:"commands"`
+	// Devices is the storage of the connected devices; it is read-only.
+	Devices map[string]*govfs.Usage `json:"devices,omitempty"`
 }
 
 var settingsGetHandler = withAdmin(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
@@ -31,6 +35,7 @@ var settingsGetHandler = withAdmin(func(w http.ResponseWriter, r *http.Request,
 		Tus:              d.settings.Tus,
 		Shell:            d.settings.Shell,
 		Commands:         d.settings.Commands,
+		Devices:          users.DeviceUsage(r.Context()),
 	}
 
 	return renderJSON(w, r, data)
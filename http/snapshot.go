@@ -0,0 +1,168 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/snapshot"
+)
+
+type snapshotCreateBody struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Algo string `json:"algo"`
+}
+
+// withSnapshot loads the snapshot named in the URL. It is one of the user,
+// or for admins, of the user given by the "user" query parameter.
+func withSnapshot(fn func(w http.ResponseWriter, r *http.Request, d *data, snap *snapshot.Snapshot) (int, error)) handleFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		snap, status, err := getSnapshot(r, d, mux.Vars(r)["name"])
+		if err != nil || status != 0 {
+			return status, err
+		}
+		return fn(w, r, d, snap)
+	})
+}
+
+func getSnapshot(r *http.Request, d *data, name string) (*snapshot.Snapshot, int, error) {
+	owner := d.user.ID
+	if param := r.URL.Query().Get("user"); param != "" {
+		if !d.user.Perm.Admin {
+			return nil, http.StatusForbidden, nil
+		}
+		id, err := strconv.ParseUint(param, 10, 0)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		owner = uint(id)
+	}
+
+	snap, err := d.store.Snapshots.Get(owner, name)
+	if err != nil {
+		return nil, errToStatus(err), err
+	}
+	return snap, 0, nil
+}
+
+// captureSnapshot reads the entries below name in the scope of the user.
+func captureSnapshot(d *data, name, algo string) ([]snapshot.Entry, int, error) {
+	if !d.Check(name) {
+		return nil, http.StatusForbidden, nil
+	}
+	entries, err := snapshot.Capture(d.user.Fs, name, algo, d)
+	if err == errors.ErrInvalidOption {
+		return nil, http.StatusBadRequest, err
+	}
+	if err != nil {
+		return nil, errToStatus(err), err
+	}
+	return entries, 0, nil
+}
+
+var snapshotListHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	var (
+		snaps []*snapshot.Snapshot
+		err   error
+	)
+	if d.user.Perm.Admin {
+		snaps, err = d.store.Snapshots.All()
+	} else {
+		snaps, err = d.store.Snapshots.FindByUserID(d.user.ID)
+	}
+	if err != nil && err != errors.ErrNotExist {
+		return http.StatusInternalServerError, err
+	}
+
+	// the entries are only sent for a single snapshot
+	list := make([]snapshot.Snapshot, 0, len(snaps))
+	for _, snap := range snaps {
+		s := *snap
+		s.Entries = nil
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+
+	return renderJSON(w, r, list)
+})
+
+var snapshotGetHandler = withSnapshot(func(w http.ResponseWriter, r *http.Request, d *data, snap *snapshot.Snapshot) (int, error) {
+	return renderJSON(w, r, snap)
+})
+
+var snapshotPostHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	if r.Body == nil {
+		return http.StatusBadRequest, errors.ErrEmptyRequest
+	}
+	defer r.Body.Close()
+	var body snapshotCreateBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("failed to decode body: %w", err)
+	}
+
+	name := path.Join("/", body.Path)
+	entries, status, err := captureSnapshot(d, name, body.Algo)
+	if err != nil || status != 0 {
+		return status, err
+	}
+
+	snap := &snapshot.Snapshot{
+		Name:    body.Name,
+		UserID:  d.user.ID,
+		Path:    name,
+		Algo:    body.Algo,
+		Created: time.Now(),
+		Entries: entries,
+	}
+	if err := d.store.Snapshots.Create(snap); err != nil {
+		return errToStatus(err), err
+	}
+
+	return renderJSON(w, r, snap)
+})
+
+var snapshotDeleteHandler = withSnapshot(func(w http.ResponseWriter, r *http.Request, d *data, snap *snapshot.Snapshot) (int, error) {
+	err := d.store.Snapshots.Delete(snap.ID)
+	return errToStatus(err), err
+})
+
+// snapshotDiffHandler compares the snapshot with the one named by the "to"
+// query parameter, or with the current state of its tree when there is
+// none.
+var snapshotDiffHandler = withSnapshot(func(w http.ResponseWriter, r *http.Request, d *data, from *snapshot.Snapshot) (int, error) {
+	var entries []snapshot.Entry
+	if name := r.URL.Query().Get("to"); name != "" {
+		to, status, err := getSnapshot(r, d, name)
+		if err != nil || status != 0 {
+			return status, err
+		}
+		entries = to.Entries
+	} else {
+		// only the trees in the scope of the user can be read again
+		if from.UserID != d.user.ID || from.Source != "" {
+			return http.StatusBadRequest, nil
+		}
+		var (
+			status int
+			err    error
+		)
+		entries, status, err = captureSnapshot(d, from.Path, from.Algo)
+		if err != nil || status != 0 {
+			return status, err
+		}
+	}
+
+	diff := snapshot.Compare(from.Entries, entries)
+	diff.From, diff.To = from.Name, r.URL.Query().Get("to")
+	return renderJSON(w, r, diff)
+})
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/trash"
+)
+
+// getTrash returns the trash of the server. The items deleted while it was
+// enabled can still be restored and purged once it is disabled.
+func getTrash(d *data) *trash.Trash {
+	return &trash.Trash{Store: d.store.Trash, LocalDir: d.server.TrashDir}
+}
+
+// removeResource deletes name, moving it to the trash unless the trash is
+// disabled or permanent is set. The trash item is nil when name is removed
+// for good.
+func removeResource(d *data, name string, permanent bool) (*trash.Item, error) {
+	if d.server.EnableTrash && !permanent {
+		return getTrash(d).Move(d.user.Fs, d.user.ID, name)
+	}
+	return nil, govfs.RemoveAll(d.user.Fs, name)
+}
+
+// withTrashItem loads the trash item whose id is in the URL, which must
+// belong to the user.
+func withTrashItem(fn func(w http.ResponseWriter, r *http.Request, d *data, t *trash.Trash, item *trash.Item) (int, error)) handleFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		t := getTrash(d)
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+		if err != nil {
+			return http.StatusNotFound, err
+		}
+		item, err := t.Store.Get(uint(id))
+		if err != nil {
+			return errToStatus(err), err
+		}
+		if item.UserID != d.user.ID {
+			return http.StatusNotFound, nil
+		}
+		return fn(w, r, d, t, item)
+	})
+}
+
+// trashListHandler lists the trash of the user, the latest deleted first.
+var trashListHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	items, err := d.store.Trash.FindByUserID(d.user.ID)
+	if err != nil && err != errors.ErrNotExist {
+		return http.StatusInternalServerError, err
+	}
+	if items == nil {
+		items = []*trash.Item{}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted.After(items[j].Deleted)
+	})
+	return renderJSON(w, r, items)
+})
+
+// trashRestoreHandler moves an item back to where it was deleted from, or
+// next to it with rename=true when the path is taken again.
+var trashRestoreHandler = withTrashItem(func(w http.ResponseWriter, r *http.Request, d *data, t *trash.Trash, item *trash.Item) (int, error) {
+	if !d.user.Perm.Create || !d.Check(item.Path) {
+		return http.StatusForbidden, nil
+	}
+	dst := item.Path
+	if r.URL.Query().Get("rename") == "true" {
+		dst = addVersionSuffix(dst, d.user.Fs)
+	}
+	err := d.RunHook(func() error {
+		return t.Restore(d.user.Fs, item, dst)
+	}, "restore", dst, "", d.user)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	item.Path = dst
+	return renderJSON(w, r, item)
+})
+
+// trashPurgeHandler deletes an item for good.
+var trashPurgeHandler = withTrashItem(func(w http.ResponseWriter, r *http.Request, d *data, t *trash.Trash, item *trash.Item) (int, error) {
+	if !d.user.Perm.Delete {
+		return http.StatusForbidden, nil
+	}
+	err := t.Purge(d.user.Fs, item)
+	return errToStatus(err), err
+})
+
+// trashEmptyHandler deletes the whole trash of the user for good.
+var trashEmptyHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	if !d.user.Perm.Delete {
+		return http.StatusForbidden, nil
+	}
+	purged, err := getTrash(d).Empty(d.user.Fs, d.user.ID)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	return renderJSON(w, r, map[string]int{"purged": purged})
+})
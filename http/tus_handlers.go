@@ -1,21 +1,140 @@
 package http
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/afero"
 
 	"github.com/filebrowser/filebrowser/v2/files"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/versions"
 )
 
-func tusPostHandler() handleFunc {
+// tusUploadTTL is how long an upload may go without receiving a chunk
+// before it is abandoned and its staging file removed.
+const tusUploadTTL = 24 * time.Hour
+
+// tusStagingSuffix ends the names of the staging files, so that those
+// orphaned by a restart can be told from other temporary files.
+const tusStagingSuffix = ".upload"
+
+// tusUpload is an upload whose length was announced on creation. Its content
+// is staged in a temporary file next to the target, which is renamed over the
+// target once complete so that the target never has partial content.
+type tusUpload struct {
+	staging string
+	length  int64
+	// fs is the filesystem of the user, not bound to any request, for the
+	// staging file to be removed once the upload expires
+	fs      afero.Fs
+	updated time.Time
+}
+
+// tusUploads tracks the staged uploads in progress, by user and path.
+type tusUploads struct {
+	mu      sync.Mutex
+	uploads map[string]*tusUpload
+	ttl     time.Duration
+}
+
+func newTusUploads() *tusUploads {
+	return &tusUploads{uploads: map[string]*tusUpload{}, ttl: tusUploadTTL}
+}
+
+// tusStagingName returns the name of a staging file of name.
+func tusStagingName(name string) string {
+	return strings.TrimSuffix(govfs.TempName(name), ".tmp") + tusStagingSuffix
+}
+
+func tusUploadKey(d *data, name string) string {
+	return strconv.FormatUint(uint64(d.user.ID), 10) + ":" + name
+}
+
+func (u *tusUploads) get(key string) *tusUpload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload := u.uploads[key]
+	if upload != nil {
+		upload.updated = time.Now()
+	}
+	return upload
+}
+
+func (u *tusUploads) set(key string, upload *tusUpload) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if upload == nil {
+		delete(u.uploads, key)
+		return
+	}
+	u.uploads[key] = upload
+}
+
+// expire forgets the uploads that received nothing for longer than the ttl
+// and removes their staging file.
+func (u *tusUploads) expire() {
+	var expired []*tusUpload
+	u.mu.Lock()
+	for key, upload := range u.uploads {
+		if time.Since(upload.updated) > u.ttl {
+			expired = append(expired, upload)
+			delete(u.uploads, key)
+		}
+	}
+	u.mu.Unlock()
+
+	for _, upload := range expired {
+		_ = upload.fs.Remove(upload.staging)
+	}
+}
+
+// removeOrphans removes the staging files of name that are not tracked and
+// older than the ttl, such as those of the uploads in progress when the
+// server stopped.
+func (u *tusUploads) removeOrphans(fs afero.Fs, name string) {
+	dir, base := path.Split(name)
+	infos, err := govfs.ReadDir(fs, dir)
+	if err != nil {
+		return
+	}
+	tracked := map[string]bool{}
+	u.mu.Lock()
+	for _, upload := range u.uploads {
+		tracked[upload.staging] = true
+	}
+	u.mu.Unlock()
+
+	for _, info := range infos {
+		staging := path.Join(dir, info.Name())
+		if !strings.HasPrefix(info.Name(), "."+base+".") || !strings.HasSuffix(info.Name(), tusStagingSuffix) ||
+			tracked[staging] || time.Since(info.ModTime()) <= u.ttl {
+			continue
+		}
+		_ = fs.Remove(staging)
+	}
+}
+
+// complete moves a fully received upload over its target.
+func (u *tusUploads) complete(fs afero.Fs, key, name string, upload *tusUpload) error {
+	if err := fs.Rename(upload.staging, name); err != nil {
+		return err
+	}
+	u.set(key, nil)
+	return nil
+}
+
+func tusPostHandler(uploads *tusUploads) handleFunc {
 	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
 		file, err := files.NewFileInfo(files.FileOptions{
 			Fs:         d.user.Fs,
@@ -26,13 +145,13 @@ func tusPostHandler() handleFunc {
 			Checker:    d,
 		})
 		switch {
-		case errors.Is(err, afero.ErrFileNotFound) || strings.Contains(err.Error(), "ObjectNotFound"):
+		case errors.Is(err, os.ErrNotExist):
 			if !d.user.Perm.Create || !d.Check(r.URL.Path) {
 				return http.StatusForbidden, nil
 			}
 
 			dirPath := filepath.Dir(r.URL.Path)
-			if _, statErr := d.user.Fs.Stat(dirPath); statErr != nil && (os.IsNotExist(statErr) || strings.Contains(statErr.Error(), "ObjectNotFound")) {
+			if _, statErr := d.user.Fs.Stat(dirPath); errors.Is(statErr, os.ErrNotExist) {
 				if mkdirErr := d.user.Fs.MkdirAll(dirPath, files.PermDir); mkdirErr != nil {
 					return http.StatusInternalServerError, err
 				}
@@ -51,9 +170,30 @@ func tusPostHandler() handleFunc {
 			if file.IsDir {
 				return http.StatusBadRequest, fmt.Errorf("cannot upload to a directory %s", file.RealPath())
 			}
+			if err = versions.Save(d.user.Fs, r.URL.Path, d.server.Versions); err != nil {
+				return errToStatus(err), err
+			}
+		}
+
+		uploadLength, err := getUploadLength(r)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		uploads.expire()
+		uploads.removeOrphans(d.user.Fs, r.URL.Path)
+		key := tusUploadKey(d, r.URL.Path)
+		if previous := uploads.get(key); previous != nil {
+			_ = d.user.Fs.Remove(previous.staging)
+			uploads.set(key, nil)
+		}
+
+		// uploads of unknown length are written in place
+		target := r.URL.Path
+		if uploadLength >= 0 {
+			target = tusStagingName(r.URL.Path)
 		}
 
-		openFile, err := d.user.Fs.OpenFile(r.URL.Path, fileFlags, files.PermFile)
+		openFile, err := d.user.Fs.OpenFile(target, fileFlags, files.PermFile)
 		if err != nil {
 			return errToStatus(err), err
 		}
@@ -61,17 +201,42 @@ func tusPostHandler() handleFunc {
 			return errToStatus(err), err
 		}
 
+		if uploadLength >= 0 {
+			upload := &tusUpload{
+				staging: target,
+				length:  uploadLength,
+				fs:      govfs.WithContext(d.user.Fs, context.Background()),
+				updated: time.Now(),
+			}
+			uploads.set(key, upload)
+			if uploadLength == 0 {
+				if err := uploads.complete(d.user.Fs, key, r.URL.Path, upload); err != nil {
+					return errToStatus(err), err
+				}
+			}
+		}
+
 		return http.StatusCreated, nil
 	})
 }
 
-func tusHeadHandler() handleFunc {
+func tusHeadHandler(uploads *tusUploads) handleFunc {
 	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
 		w.Header().Set("Cache-Control", "no-store")
 		if !d.Check(r.URL.Path) {
 			return http.StatusForbidden, nil
 		}
 
+		if upload := uploads.get(tusUploadKey(d, r.URL.Path)); upload != nil {
+			info, err := d.user.Fs.Stat(upload.staging)
+			if err != nil {
+				return errToStatus(err), err
+			}
+			w.Header().Set("Upload-Offset", strconv.FormatInt(info.Size(), 10))
+			w.Header().Set("Upload-Length", strconv.FormatInt(upload.length, 10))
+			return http.StatusOK, nil
+		}
+
 		file, err := files.NewFileInfo(files.FileOptions{
 			Fs:         d.user.Fs,
 			Path:       r.URL.Path,
@@ -91,7 +256,7 @@ func tusHeadHandler() handleFunc {
 	})
 }
 
-func tusPatchHandler() handleFunc {
+func tusPatchHandler(uploads *tusUploads) handleFunc {
 	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
 		if !d.user.Perm.Modify || !d.Check(r.URL.Path) {
 			return http.StatusForbidden, nil
@@ -105,6 +270,12 @@ func tusPatchHandler() handleFunc {
 			return http.StatusBadRequest, fmt.Errorf("invalid upload offset: %v", err)
 		}
 
+		key := tusUploadKey(d, r.URL.Path)
+		upload := uploads.get(key)
+		if upload != nil {
+			return tusPatchStaged(w, r, d, uploads, key, upload, uploadOffset)
+		}
+
 		file, err := files.NewFileInfo(files.FileOptions{
 			Fs:         d.user.Fs,
 			Path:       r.URL.Path,
@@ -132,19 +303,15 @@ func tusPatchHandler() handleFunc {
 			)
 		}
 
-		openFile, err := d.user.Fs.OpenFile(r.URL.Path, os.O_WRONLY|os.O_APPEND, files.PermFile)
+		openFile, err := d.user.Fs.OpenFile(r.URL.Path, os.O_WRONLY, files.PermFile)
 		if err != nil {
 			return http.StatusInternalServerError, fmt.Errorf("could not open file: %v", err)
 		}
 		defer openFile.Close()
 
-		_, err = openFile.Seek(uploadOffset, 0)
-		if err != nil {
-			return http.StatusInternalServerError, fmt.Errorf("could not seek file: %v", err)
-		}
-
+		// positional writes keep the chunk at the offset it was sent for
 		defer r.Body.Close()
-		bytesWritten, err := io.Copy(openFile, r.Body)
+		bytesWritten, err := io.Copy(io.NewOffsetWriter(openFile, uploadOffset), r.Body)
 		if err != nil {
 			return http.StatusInternalServerError, fmt.Errorf("could not write to file: %v", err)
 		}
@@ -155,6 +322,52 @@ func tusPatchHandler() handleFunc {
 	})
 }
 
+// tusPatchStaged appends a chunk to the staging file of upload, and moves it
+// over the target with the last chunk.
+func tusPatchStaged(w http.ResponseWriter, r *http.Request, d *data, uploads *tusUploads,
+	key string, upload *tusUpload, uploadOffset int64) (int, error) {
+	info, err := d.user.Fs.Stat(upload.staging)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	if info.Size() != uploadOffset {
+		return http.StatusConflict, fmt.Errorf(
+			"%s upload size doesn't match the provided offset: %d",
+			r.URL.Path,
+			uploadOffset,
+		)
+	}
+
+	openFile, err := d.user.Fs.OpenFile(upload.staging, os.O_WRONLY, files.PermFile)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("could not open file: %v", err)
+	}
+
+	// bytes past the announced length are dropped
+	defer r.Body.Close()
+	in := io.LimitReader(r.Body, upload.length-uploadOffset)
+	bytesWritten, err := io.Copy(io.NewOffsetWriter(openFile, uploadOffset), in)
+	if err == nil && uploadOffset+bytesWritten == upload.length {
+		err = openFile.Sync()
+	}
+	if cerr := openFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("could not write to file: %v", err)
+	}
+
+	if uploadOffset+bytesWritten == upload.length {
+		if err := uploads.complete(d.user.Fs, key, r.URL.Path, upload); err != nil {
+			return errToStatus(err), err
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(uploadOffset+bytesWritten, 10))
+
+	return http.StatusNoContent, nil
+}
+
 func getUploadOffset(r *http.Request) (int64, error) {
 	uploadOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
 	if err != nil {
@@ -162,3 +375,17 @@ func getUploadOffset(r *http.Request) (int64, error) {
 	}
 	return uploadOffset, nil
 }
+
+// getUploadLength returns the Upload-Length of a request, or -1 when it is
+// not known.
+func getUploadLength(r *http.Request) (int64, error) {
+	header := r.Header.Get("Upload-Length")
+	if header == "" {
+		return -1, nil
+	}
+	uploadLength, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || uploadLength < 0 {
+		return 0, fmt.Errorf("invalid upload length: %q", header)
+	}
+	return uploadLength, nil
+}
@@ -16,7 +16,7 @@ import (
 )
 
 var (
-	NonModifiableFieldsForNonAdmin = []string{"Username", "Scope", "LockPassword", "Perm", "Commands", "Rules"}
+	NonModifiableFieldsForNonAdmin = []string{"Username", "Scope", "Device", "LockPassword", "Perm", "Commands", "Rules"}
 )
 
 type modifyUserRequest struct {
@@ -1,14 +1,17 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"syscall"
 
 	libErrors "github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs/cache"
 )
 
 func renderJSON(w http.ResponseWriter, _ *http.Request, data interface{}) (int, error) {
@@ -30,16 +33,23 @@ func errToStatus(err error) int {
 	switch {
 	case err == nil:
 		return http.StatusOK
-	case os.IsPermission(err):
+	case errors.Is(err, os.ErrPermission):
 		return http.StatusForbidden
-	case os.IsNotExist(err), errors.Is(err, libErrors.ErrNotExist):
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, libErrors.ErrNotExist):
 		return http.StatusNotFound
-	case os.IsExist(err), errors.Is(err, libErrors.ErrExist):
+	case errors.Is(err, os.ErrExist), errors.Is(err, libErrors.ErrExist), errors.Is(err, syscall.ENOTEMPTY),
+		errors.Is(err, libErrors.ErrInvalidJobState):
 		return http.StatusConflict
+	case errors.Is(err, syscall.ENOSPC):
+		return http.StatusInsufficientStorage
 	case errors.Is(err, libErrors.ErrPermissionDenied):
 		return http.StatusForbidden
 	case errors.Is(err, libErrors.ErrInvalidRequestParams):
 		return http.StatusBadRequest
+	case errors.Is(err, libErrors.ErrArchiveTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, libErrors.ErrUnknownDevice):
+		return http.StatusServiceUnavailable
 	case errors.Is(err, libErrors.ErrRootUserDeletion):
 		return http.StatusForbidden
 	default:
@@ -66,3 +76,14 @@ func stripPrefix(prefix string, h http.Handler) http.Handler {
 		h.ServeHTTP(w, r2)
 	})
 }
+
+// fsContext returns the context the backend requests of r run under. They
+// are aborted as soon as the client goes away, and skip the metadata cache
+// of the backend when the client sends Cache-Control: no-cache.
+func fsContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if strings.Contains(r.Header.Get("Cache-Control"), "no-cache") {
+		ctx = cache.Bypass(ctx)
+	}
+	return ctx
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/filebrowser/filebrowser/v2/versions"
+)
+
+// versionsGetHandler lists the versions of a file, the latest first, or
+// downloads the version given by the id parameter.
+var versionsGetHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	if !d.Check(r.URL.Path) {
+		return http.StatusForbidden, nil
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		list, err := versions.List(d.user.Fs, r.URL.Path, d.server.Versions)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		return renderJSON(w, r, list)
+	}
+
+	if !d.user.Perm.Download {
+		return http.StatusForbidden, nil
+	}
+	version, err := versions.Get(d.user.Fs, r.URL.Path, id, d.server.Versions)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	fd, err := versions.Open(d.user.Fs, r.URL.Path, id, d.server.Versions)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	defer fd.Close()
+
+	name := path.Base(r.URL.Path)
+	w.Header().Set("Content-Disposition", "attachment; filename*=utf-8''"+url.PathEscape(name))
+	w.Header().Add("Content-Security-Policy", `script-src 'none';`)
+	w.Header().Set("Cache-Control", "private")
+	http.ServeContent(w, r, name, version.Saved, fd)
+	return 0, nil
+})
+
+// versionsRestoreHandler replaces the content of a file with the version
+// given by the id parameter, through the save hooks.
+var versionsRestoreHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	if !d.user.Perm.Modify || !d.Check(r.URL.Path) {
+		return http.StatusForbidden, nil
+	}
+
+	err := d.RunHook(func() error {
+		return versions.Restore(d.user.Fs, r.URL.Path, r.URL.Query().Get("id"), d.server.Versions)
+	}, "save", r.URL.Path, "", d.user)
+	return errToStatus(err), err
+})
@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// State is the state of a job.
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateRunning  State = "running"
+	StateDone     State = "done"
+	StateFailed   State = "failed"
+	StateCanceled State = "canceled"
+)
+
+// Finished tells whether a job in the state s is over.
+func (s State) Finished() bool {
+	return s == StateDone || s == StateFailed || s == StateCanceled
+}
+
+// Job is a long file operation run in the background on behalf of a user.
+// The fields describing the operation are up to the code running it; the
+// manager only keeps the state and progress up to date.
+type Job struct {
+	ID          uint     `json:"id" storm:"id,increment"`
+	UserID      uint     `json:"userID" storm:"index"`
+	Action      string   `json:"action"`
+	Sources     []string `json:"sources"`
+	Destination string   `json:"destination,omitempty"`
+	Override    bool     `json:"override,omitempty"`
+	Rename      bool     `json:"rename,omitempty"`
+	Algo        string   `json:"algo,omitempty"`
+	// Permanent deletes skip the trash.
+	Permanent bool `json:"permanent,omitempty"`
+
+	State State  `json:"state"`
+	Error string `json:"error,omitempty"`
+	// TotalBytes is zero when the size of the operation is not known.
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"totalBytes"`
+	Files      int64 `json:"files"`
+	Attempts   int   `json:"attempts"`
+
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Progress is updated by a running job, concurrently with the manager
+// reading it.
+type Progress struct {
+	bytes      atomic.Int64
+	totalBytes atomic.Int64
+	files      atomic.Int64
+}
+
+// Add counts bytes and files done. It can be called on a nil Progress.
+func (p *Progress) Add(bytes int64, files int) {
+	if p == nil {
+		return
+	}
+	p.bytes.Add(bytes)
+	p.files.Add(int64(files))
+}
+
+// SetTotal sets the size of the operation. It can be called on a nil
+// Progress.
+func (p *Progress) SetTotal(bytes int64) {
+	if p == nil {
+		return
+	}
+	p.totalBytes.Store(bytes)
+}
+
+func (p *Progress) update(job *Job) {
+	job.Bytes = p.bytes.Load()
+	job.TotalBytes = p.totalBytes.Load()
+	job.Files = p.files.Load()
+}
@@ -0,0 +1,313 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+)
+
+// RunFunc runs the operation of a job, reporting to p. It must return soon
+// after ctx is done.
+type RunFunc func(ctx context.Context, p *Progress) error
+
+// UpdateInterval is how often the progress of running jobs is saved and
+// sent to the subscribers.
+var UpdateInterval = time.Second
+
+// Workers is how many jobs run at once. The others wait, pending, for one of
+// them to finish.
+var Workers = 4
+
+// Manager runs jobs in the background and keeps their state in a Storage.
+type Manager struct {
+	store *Storage
+
+	mu      sync.Mutex
+	running map[uint]*run
+	subs    map[chan Job]struct{}
+	// slots holds a value for each job running fn
+	slots chan struct{}
+}
+
+type run struct {
+	job      *Job
+	progress *Progress
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewManager returns a manager of the jobs in store. The jobs left pending
+// or running by a previous process failed, and can be retried.
+func NewManager(store *Storage) (*Manager, error) {
+	m := &Manager{
+		store:   store,
+		running: map[uint]*run{},
+		subs:    map[chan Job]struct{}{},
+		slots:   make(chan struct{}, Workers),
+	}
+
+	all, err := store.All()
+	if err != nil && err != errors.ErrNotExist {
+		return nil, err
+	}
+	for _, job := range all {
+		if job.State.Finished() {
+			continue
+		}
+		now := time.Now()
+		job.State, job.Error, job.Finished = StateFailed, "interrupted by a restart", &now
+		if err := store.Save(job); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Submit saves a new job and runs fn for it in the background. job is set
+// to its pending state, and is not updated afterwards.
+func (m *Manager) Submit(job *Job, fn RunFunc) error {
+	job.ID = 0
+	job.Created = time.Now()
+	job.Attempts = 0
+	reset(job)
+	if err := m.store.Save(job); err != nil {
+		return err
+	}
+
+	// the ID is new, so no other call can run the job
+	m.mu.Lock()
+	r := m.newRun(job)
+	m.mu.Unlock()
+	m.start(r, fn)
+	return nil
+}
+
+// Retry runs fn again for a job that failed or was canceled.
+func (m *Manager) Retry(id uint, fn RunFunc) (*Job, error) {
+	// the job is checked and registered at once, for concurrent retries not
+	// to both run it
+	m.mu.Lock()
+	if _, running := m.running[id]; running {
+		m.mu.Unlock()
+		return nil, errors.ErrInvalidJobState
+	}
+	job, err := m.store.Get(id)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	if job.State != StateFailed && job.State != StateCanceled {
+		m.mu.Unlock()
+		return nil, errors.ErrInvalidJobState
+	}
+	reset(job)
+	r := m.newRun(job)
+	m.mu.Unlock()
+
+	if err = m.store.Save(job); err != nil {
+		r.cancel()
+		m.mu.Lock()
+		delete(m.running, id)
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.start(r, fn)
+	return job, nil
+}
+
+// reset sets a job to its pending state for a new attempt.
+func reset(job *Job) {
+	job.State, job.Error = StatePending, ""
+	job.Bytes, job.TotalBytes, job.Files = 0, 0, 0
+	job.Started, job.Finished = nil, nil
+	job.Attempts++
+}
+
+// newRun registers a run of job. m.mu must be held.
+func (m *Manager) newRun(job *Job) *run {
+	ctx, cancel := context.WithCancel(context.Background())
+	owned := *job
+	r := &run{job: &owned, progress: &Progress{}, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	m.running[job.ID] = r
+	return r
+}
+
+func (m *Manager) start(r *run, fn RunFunc) {
+	m.publish(*r.job)
+	go m.run(r, fn)
+}
+
+func (m *Manager) run(r *run, fn RunFunc) {
+	defer close(r.done)
+	defer r.cancel()
+
+	// pending jobs wait for a free slot, and may be canceled meanwhile
+	var err error
+	select {
+	case m.slots <- struct{}{}:
+		err = m.execute(r, fn)
+		<-m.slots
+	case <-r.ctx.Done():
+	}
+
+	m.mu.Lock()
+	now := time.Now()
+	r.job.Finished = &now
+	switch {
+	case r.ctx.Err() != nil:
+		r.job.State = StateCanceled
+	case err != nil:
+		r.job.State, r.job.Error = StateFailed, err.Error()
+	default:
+		r.job.State = StateDone
+	}
+	m.mu.Unlock()
+	m.update(r)
+
+	// the job is read from the store once its final state is saved
+	m.mu.Lock()
+	delete(m.running, r.job.ID)
+	m.mu.Unlock()
+}
+
+// execute runs fn for a job, saving its progress periodically.
+func (m *Manager) execute(r *run, fn RunFunc) error {
+	m.mu.Lock()
+	now := time.Now()
+	r.job.State, r.job.Started = StateRunning, &now
+	m.mu.Unlock()
+	m.update(r)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- fn(r.ctx, r.progress)
+	}()
+
+	ticker := time.NewTicker(UpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-result:
+			return err
+		case <-ticker.C:
+			m.update(r)
+		}
+	}
+}
+
+// update saves the current state of a job and sends it to the subscribers.
+func (m *Manager) update(r *run) {
+	m.mu.Lock()
+	r.progress.update(r.job)
+	job := *r.job
+	m.mu.Unlock()
+
+	if err := m.store.Save(&job); err != nil {
+		log.Printf("job %d: %v", job.ID, err)
+	}
+	m.publish(job)
+}
+
+// Get returns a job, with the current progress of running ones.
+func (m *Manager) Get(id uint) (*Job, error) {
+	m.mu.Lock()
+	if r, ok := m.running[id]; ok {
+		r.progress.update(r.job)
+		job := *r.job
+		m.mu.Unlock()
+		return &job, nil
+	}
+	m.mu.Unlock()
+	return m.store.Get(id)
+}
+
+// List returns the jobs of a user, or of every user when all is set, with
+// the current progress of running ones.
+func (m *Manager) List(userID uint, all bool) ([]*Job, error) {
+	var (
+		list []*Job
+		err  error
+	)
+	if all {
+		list, err = m.store.All()
+	} else {
+		list, err = m.store.FindByUserID(userID)
+	}
+	if err != nil && err != errors.ErrNotExist {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, job := range list {
+		if r, ok := m.running[job.ID]; ok {
+			r.progress.update(r.job)
+			current := *r.job
+			list[i] = &current
+		}
+	}
+	return list, nil
+}
+
+// Cancel stops a pending or running job and waits for it to return.
+func (m *Manager) Cancel(id uint) error {
+	m.mu.Lock()
+	r, ok := m.running[id]
+	m.mu.Unlock()
+	if !ok {
+		if _, err := m.store.Get(id); err != nil {
+			return err
+		}
+		return errors.ErrInvalidJobState
+	}
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// Delete cancels a job if it still runs and deletes it.
+func (m *Manager) Delete(id uint) error {
+	if err := m.Cancel(id); err != nil && err != errors.ErrInvalidJobState {
+		return err
+	}
+	return m.store.Delete(id)
+}
+
+// Subscribe returns a channel receiving every update of every job, and a
+// function to call once done with it. The oldest updates are dropped when
+// the channel is full, so that slow readers still get the latest state.
+func (m *Manager) Subscribe() (<-chan Job, func()) {
+	ch := make(chan Job, 64) //nolint:gomnd
+	m.mu.Lock()
+	m.subs[ch] = struct{}{}
+	m.mu.Unlock()
+	return ch, func() {
+		m.mu.Lock()
+		delete(m.subs, ch)
+		m.mu.Unlock()
+	}
+}
+
+func (m *Manager) publish(job Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subs {
+		select {
+		case ch <- job:
+		default:
+			// make room for the update by dropping the oldest one
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- job:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	fberrors "github.com/filebrowser/filebrowser/v2/errors"
+)
+
+type memBackend struct {
+	mu     sync.Mutex
+	jobs   map[uint]Job
+	nextID uint
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{jobs: map[uint]Job{}}
+}
+
+func (b *memBackend) All() ([]*Job, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var list []*Job
+	for _, job := range b.jobs {
+		job := job
+		list = append(list, &job)
+	}
+	return list, nil
+}
+
+func (b *memBackend) FindByUserID(id uint) ([]*Job, error) {
+	all, _ := b.All()
+	var list []*Job
+	for _, job := range all {
+		if job.UserID == id {
+			list = append(list, job)
+		}
+	}
+	return list, nil
+}
+
+func (b *memBackend) Get(id uint) (*Job, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	if !ok {
+		return nil, fberrors.ErrNotExist
+	}
+	return &job, nil
+}
+
+func (b *memBackend) Save(j *Job) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if j.ID == 0 {
+		b.nextID++
+		j.ID = b.nextID
+	}
+	b.jobs[j.ID] = *j
+	return nil
+}
+
+func (b *memBackend) Delete(id uint) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.jobs[id]; !ok {
+		return fberrors.ErrNotExist
+	}
+	delete(b.jobs, id)
+	return nil
+}
+
+// wait returns the job once the manager reports it finished.
+func wait(t *testing.T, updates <-chan Job, id uint) Job {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case job := <-updates:
+			if job.ID == id && job.State.Finished() {
+				return job
+			}
+		case <-timeout:
+			t.Fatalf("job %d did not finish", id)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	store := NewStorage(newMemBackend())
+	m, err := NewManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	job := &Job{UserID: 1, Action: "copy"}
+	err = m.Submit(job, func(ctx context.Context, p *Progress) error {
+		p.SetTotal(10)
+		p.Add(10, 2)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := wait(t, updates, job.ID)
+	if done.State != StateDone || done.Bytes != 10 || done.TotalBytes != 10 || done.Files != 2 {
+		t.Errorf("finished job: %+v", done)
+	}
+	if stored, _ := store.Get(job.ID); stored.State != StateDone {
+		t.Errorf("stored state %q", stored.State)
+	}
+	if err = m.Cancel(job.ID); !errors.Is(err, fberrors.ErrInvalidJobState) {
+		t.Errorf("canceling a finished job: %v", err)
+	}
+
+	// a failed job can be retried
+	failing := &Job{UserID: 1, Action: "delete"}
+	if err = m.Submit(failing, func(ctx context.Context, p *Progress) error { return errors.New("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	if done = wait(t, updates, failing.ID); done.State != StateFailed || done.Error != "boom" {
+		t.Errorf("failed job: %+v", done)
+	}
+	if _, err = m.Retry(failing.ID, func(ctx context.Context, p *Progress) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if done = wait(t, updates, failing.ID); done.State != StateDone || done.Attempts != 2 || done.Error != "" {
+		t.Errorf("retried job: %+v", done)
+	}
+
+	// a running job is canceled through its context
+	started := make(chan struct{})
+	blocked := &Job{UserID: 2, Action: "archive"}
+	err = m.Submit(blocked, func(ctx context.Context, p *Progress) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if running, _ := m.Get(blocked.ID); running.State != StateRunning {
+		t.Errorf("state of a running job: %q", running.State)
+	}
+	if err = m.Cancel(blocked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := m.Get(blocked.ID); stored.State != StateCanceled {
+		t.Errorf("state of a canceled job: %q", stored.State)
+	}
+
+	if list, _ := m.List(1, false); len(list) != 2 {
+		t.Errorf("jobs of user 1: %d", len(list))
+	}
+	if list, _ := m.List(1, true); len(list) != 3 {
+		t.Errorf("jobs of every user: %d", len(list))
+	}
+	if err = m.Delete(blocked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Get(blocked.ID); !errors.Is(err, fberrors.ErrNotExist) {
+		t.Errorf("deleted job: %v", err)
+	}
+}
+
+func TestManagerRestart(t *testing.T) {
+	store := NewStorage(newMemBackend())
+	if err := store.Save(&Job{UserID: 1, Action: "copy", State: StateRunning}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewManager(store); err != nil {
+		t.Fatal(err)
+	}
+	job, _ := store.Get(1)
+	if job.State != StateFailed || job.Finished == nil {
+		t.Errorf("job interrupted by a restart: %+v", job)
+	}
+}
+
+// slowBackend widens the window between reading a job and saving it.
+type slowBackend struct {
+	*memBackend
+}
+
+func (b slowBackend) Get(id uint) (*Job, error) {
+	job, err := b.memBackend.Get(id)
+	time.Sleep(10 * time.Millisecond)
+	return job, err
+}
+
+func TestManagerRetryOnce(t *testing.T) {
+	store := NewStorage(slowBackend{newMemBackend()})
+	failed := &Job{UserID: 1, Action: "copy", State: StateFailed}
+	if err := store.Save(failed); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		retried int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Retry(failed.ID, func(ctx context.Context, p *Progress) error {
+				<-release
+				return nil
+			})
+			if err == nil {
+				mu.Lock()
+				retried++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if retried != 1 {
+		t.Errorf("concurrent retries started the job %d times", retried)
+	}
+}
+
+func TestManagerWorkers(t *testing.T) {
+	m, err := NewManager(NewStorage(newMemBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	release := make(chan struct{})
+	started := make(chan struct{}, Workers+1)
+	block := func(ctx context.Context, p *Progress) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+	for i := 0; i < Workers; i++ {
+		if err = m.Submit(&Job{UserID: 1, Action: "copy"}, block); err != nil {
+			t.Fatal(err)
+		}
+		<-started
+	}
+
+	// the pool is full, so the next jobs wait
+	queued := &Job{UserID: 1, Action: "copy"}
+	if err = m.Submit(queued, block); err != nil {
+		t.Fatal(err)
+	}
+	canceled := &Job{UserID: 1, Action: "copy"}
+	if err = m.Submit(canceled, block); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+		t.Fatal("a job started beyond the worker limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if job, _ := m.Get(queued.ID); job.State != StatePending {
+		t.Errorf("state of a queued job: %q", job.State)
+	}
+	if err = m.Cancel(canceled.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := m.Get(canceled.ID); job.State != StateCanceled {
+		t.Errorf("state of a job canceled while queued: %q", job.State)
+	}
+
+	close(release)
+	if done := wait(t, updates, queued.ID); done.State != StateDone {
+		t.Errorf("queued job: %+v", done)
+	}
+}
@@ -9,6 +9,7 @@ import (
 // for some fields on User.
 type UserDefaults struct {
 	Scope        string            `json:"scope"`
+	Device       string            `json:"device"`
 	Locale       string            `json:"locale"`
 	ViewMode     users.ViewMode    `json:"viewMode"`
 	SingleClick  bool              `json:"singleClick"`
@@ -22,6 +23,7 @@ type UserDefaults struct {
 // Apply applies the default options to a user.
 func (d *UserDefaults) Apply(u *users.User) {
 	u.Scope = d.Scope
+	u.Device = d.Device
 	u.Locale = d.Locale
 	u.ViewMode = d.ViewMode
 	u.SingleClick = d.SingleClick
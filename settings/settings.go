@@ -3,12 +3,23 @@ package settings
 import (
 	"crypto/rand"
 	"strings"
+	"time"
 
 	"github.com/filebrowser/filebrowser/v2/rules"
+	"github.com/filebrowser/filebrowser/v2/versions"
 )
 
 const DefaultUsersHomeBasePath = "/users"
 
+// Default limits of the content extracted from an archive.
+const (
+	DefaultExtractMaxSize  = 10 << 30
+	DefaultExtractMaxFiles = 100000
+)
+
+// DefaultTrashRetention is how long deleted files are kept by default.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
 // AuthMethod describes an authentication method.
 type AuthMethod string
 
@@ -47,6 +58,19 @@ type Server struct {
 	EnableExec            bool   `json:"enableExec"`
 	TypeDetectionByHeader bool   `json:"typeDetectionByHeader"`
 	AuthHook              string `json:"authHook"`
+	EnableTrash           bool   `json:"enableTrash"`
+	// TrashDir is a local directory holding the trash of every user instead
+	// of the trash area of their scope.
+	TrashDir string `json:"trashDir"`
+	// TrashRetention is how long deleted files are kept, zero keeping them
+	// until they are purged.
+	TrashRetention time.Duration `json:"trashRetention"`
+	// Versions is the policy of the versions kept of the files overwritten.
+	Versions versions.Policy `json:"versions"`
+	// ExtractMaxSize and ExtractMaxFiles bound the content extracted from
+	// an archive, zero meaning no limit.
+	ExtractMaxSize  int64 `json:"extractMaxSize"`
+	ExtractMaxFiles int   `json:"extractMaxFiles"`
 }
 
 // Clean cleans any variables that might need cleaning.
@@ -54,6 +78,18 @@ func (s *Server) Clean() {
 	s.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
 }
 
+// DefaultServer returns the server settings with the defaults of the
+// settings whose zero value is not the default. The settings stored before
+// these settings existed are read over them.
+func DefaultServer() *Server {
+	return &Server{
+		EnableTrash:     true,
+		TrashRetention:  DefaultTrashRetention,
+		ExtractMaxSize:  DefaultExtractMaxSize,
+		ExtractMaxFiles: DefaultExtractMaxFiles,
+	}
+}
+
 // GenerateKey generates a key of 512 bits.
 func GenerateKey() ([]byte, error) {
 	b := make([]byte, 64) //nolint:gomnd
@@ -48,6 +48,7 @@ var defaultEvents = []string{
 	"rename",
 	"upload",
 	"delete",
+	"restore",
 }
 
 // Save saves the settings for the current instance.
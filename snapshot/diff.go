@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"sort"
+)
+
+// Diff lists the changes between two snapshots. To is empty when the
+// snapshot From is compared with the current state of its tree.
+type Diff struct {
+	From     string   `json:"from"`
+	To       string   `json:"to,omitempty"`
+	Created  []Entry  `json:"created"`
+	Modified []Change `json:"modified"`
+	Deleted  []Entry  `json:"deleted"`
+}
+
+// Change is an entry modified between two snapshots.
+type Change struct {
+	Path string `json:"path"`
+	Old  Entry  `json:"old"`
+	New  Entry  `json:"new"`
+}
+
+// Compare returns the entries created, modified and deleted going from the
+// old entries to the new ones, sorted by path. Directories are reported
+// created or deleted, but not modified when only their time changes, since
+// it does whenever their content does. Files are modified when their size,
+// modification time or hash differ.
+func Compare(old, new []Entry) *Diff { //nolint:revive
+	d := &Diff{Created: []Entry{}, Modified: []Change{}, Deleted: []Entry{}}
+
+	before := make(map[string]Entry, len(old))
+	for _, entry := range old {
+		before[entry.Path] = entry
+	}
+	for _, entry := range new {
+		prev, ok := before[entry.Path]
+		if !ok {
+			d.Created = append(d.Created, entry)
+			continue
+		}
+		delete(before, entry.Path)
+		if modified(prev, entry) {
+			d.Modified = append(d.Modified, Change{Path: entry.Path, Old: prev, New: entry})
+		}
+	}
+	for _, entry := range before {
+		d.Deleted = append(d.Deleted, entry)
+	}
+
+	sort.Slice(d.Created, func(i, j int) bool { return d.Created[i].Path < d.Created[j].Path })
+	sort.Slice(d.Modified, func(i, j int) bool { return d.Modified[i].Path < d.Modified[j].Path })
+	sort.Slice(d.Deleted, func(i, j int) bool { return d.Deleted[i].Path < d.Deleted[j].Path })
+	return d
+}
+
+func modified(old, new Entry) bool { //nolint:revive
+	if old.IsDir || new.IsDir {
+		return old.IsDir != new.IsDir
+	}
+	if old.Hash != "" && new.Hash != "" && old.Hash != new.Hash {
+		return true
+	}
+	return old.Size != new.Size || !old.ModTime.Equal(new.ModTime)
+}
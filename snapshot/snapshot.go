@@ -0,0 +1,157 @@
+// Package snapshot records the state of a directory tree, so that the
+// files created, modified or deleted between two moments can be listed.
+package snapshot
+
+import (
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	stdErrors "errors"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/rules"
+)
+
+// SourceLocal is the source of the snapshots of the machine running the
+// command line.
+const SourceLocal = "local"
+
+// Snapshot is the state of a tree at some point.
+type Snapshot struct {
+	ID uint `json:"id" storm:"id,increment"`
+	// Name is unique among the snapshots of UserID.
+	Name   string `json:"name" storm:"index"`
+	UserID uint   `json:"userID" storm:"index"`
+	// Source is where the tree was read: empty for the scope of UserID,
+	// SourceLocal for the machine running the command line, or else the
+	// address of a device.
+	Source  string    `json:"source,omitempty"`
+	Path    string    `json:"path"`
+	Algo    string    `json:"algo,omitempty"`
+	Created time.Time `json:"created"`
+	Entries []Entry   `json:"entries,omitempty"`
+}
+
+// Entry is a file or directory of a snapshot. Path is relative to the
+// path of the snapshot.
+type Entry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+	IsDir   bool      `json:"isDir,omitempty"`
+	// Hash is the digest of the content of regular files, computed with
+	// the algorithm of the snapshot when it has one.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Capture walks the tree rooted at root, leaving out the paths checker
+// rejects when it is not nil, and returns its entries, parents before
+// children. The content of the regular files is hashed with algo unless it
+// is empty. The entries removed while the tree is walked are left out.
+func Capture(fs afero.Fs, root, algo string, checker rules.Checker) ([]Entry, error) {
+	if algo != "" {
+		if _, err := newHash(algo); err != nil {
+			return nil, err
+		}
+	}
+
+	root = path.Join("/", filepath.ToSlash(root))
+	entries := []Entry{}
+	err := govfs.Walk(fs, root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			if name == root || !stdErrors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			// a directory removed once listed was added before being read
+			if n := len(entries); n > 0 && entries[n-1].Path == relPath(root, name) {
+				entries = entries[:n-1]
+			}
+			return filepath.SkipDir
+		}
+		if name == root {
+			return nil
+		}
+		if checker != nil && !checker.Check(name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		entry := Entry{
+			Path:    relPath(root, name),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		}
+		if algo != "" && info.Mode().IsRegular() {
+			sum, err := hashFile(fs, name, algo, info.Size())
+			if stdErrors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			entry.Hash = hex.EncodeToString(sum)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func relPath(root, name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+}
+
+func newHash(algo string) (hash.Hash, error) {
+	//nolint:gosec
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, errors.ErrInvalidOption
+	}
+}
+
+// hashFile hashes name where it is stored when fs supports algo.
+func hashFile(fs afero.Fs, name, algo string, size int64) ([]byte, error) {
+	if hasher, ok := fs.(govfs.Hasher); ok {
+		sum, err := hasher.Hash(name, algo, 0, size)
+		if !stdErrors.Is(err, govfs.ErrNotSupported) {
+			return sum, err
+		}
+	}
+
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
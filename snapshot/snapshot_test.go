@@ -0,0 +1,206 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	fberrors "github.com/filebrowser/filebrowser/v2/errors"
+)
+
+type denyPrefix string
+
+func (p denyPrefix) Check(path string) bool {
+	return !strings.HasPrefix(path, string(p))
+}
+
+func paths(entries []Entry) []string {
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Path)
+	}
+	return names
+}
+
+func TestCaptureAndCompare(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	mtime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for name, content := range map[string]string{
+		"/app/Documents/db.sqlite": "v1",
+		"/app/Documents/keep.txt":  "same",
+		"/app/Library/cache":       "cache",
+		"/app/tmp/secret":          "secret",
+		"/other":                   "other",
+	} {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := Capture(fs, "/app", "sha1", denyPrefix("/app/tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Documents", "Documents/db.sqlite", "Documents/keep.txt", "Library", "Library/cache"}
+	if got := paths(before); !reflect.DeepEqual(got, want) {
+		t.Errorf("captured %v, want %v", got, want)
+	}
+	if len(before[1].Hash) != 40 { //nolint:gomnd
+		t.Errorf("hash of a file = %q", before[1].Hash)
+	}
+	if before[0].Hash != "" {
+		t.Errorf("directory hashed: %q", before[0].Hash)
+	}
+
+	// same size and time, different content
+	if err = afero.WriteFile(fs, "/app/Documents/db.sqlite", []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.Chtimes("/app/Documents/db.sqlite", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.RemoveAll("/app/Library"); err != nil {
+		t.Fatal(err)
+	}
+	if err = afero.WriteFile(fs, "/app/Documents/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := Capture(fs, "/app", "sha1", denyPrefix("/app/tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := Compare(before, after)
+	if got := paths(diff.Created); !reflect.DeepEqual(got, []string{"Documents/new.txt"}) {
+		t.Errorf("created %v", got)
+	}
+	if len(diff.Modified) != 1 || diff.Modified[0].Path != "Documents/db.sqlite" {
+		t.Errorf("modified %+v", diff.Modified)
+	}
+	if got := paths(diff.Deleted); !reflect.DeepEqual(got, []string{"Library", "Library/cache"}) {
+		t.Errorf("deleted %v", got)
+	}
+
+	if _, err = Capture(fs, "/app", "crc32", nil); err == nil {
+		t.Error("capture with an unknown algorithm did not fail")
+	}
+}
+
+// vanishingFs fails to open the names removed after being listed.
+type vanishingFs struct {
+	afero.Fs
+	removed map[string]bool
+}
+
+func (fs vanishingFs) Open(name string) (afero.File, error) {
+	if fs.removed[name] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestCaptureRemovedEntries(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	for _, name := range []string{"/app/a.txt", "/app/gone.txt", "/app/gone/b.txt", "/app/kept/c.txt"} {
+		if err := afero.WriteFile(mem, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := vanishingFs{Fs: mem, removed: map[string]bool{"/app/gone.txt": true, "/app/gone": true}}
+
+	entries, err := Capture(fs, "/app", "sha256", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := paths(entries), []string{"a.txt", "kept", "kept/c.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("captured %v, want %v", got, want)
+	}
+
+	fs.removed["/app"] = true
+	if _, err = Capture(fs, "/app", "", nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("capture of a removed root: %v", err)
+	}
+}
+
+type memBackend struct {
+	snaps  map[uint]Snapshot
+	nextID uint
+}
+
+func (b *memBackend) All() ([]*Snapshot, error) {
+	var list []*Snapshot
+	for _, snap := range b.snaps {
+		snap := snap
+		list = append(list, &snap)
+	}
+	return list, nil
+}
+
+func (b *memBackend) FindByUserID(id uint) ([]*Snapshot, error) {
+	all, _ := b.All()
+	var list []*Snapshot
+	for _, snap := range all {
+		if snap.UserID == id {
+			list = append(list, snap)
+		}
+	}
+	return list, nil
+}
+
+func (b *memBackend) Get(userID uint, name string) (*Snapshot, error) {
+	for _, snap := range b.snaps {
+		if snap.UserID == userID && snap.Name == name {
+			return &snap, nil
+		}
+	}
+	return nil, fberrors.ErrNotExist
+}
+
+func (b *memBackend) Save(s *Snapshot) error {
+	if s.ID == 0 {
+		b.nextID++
+		s.ID = b.nextID
+	}
+	b.snaps[s.ID] = *s
+	return nil
+}
+
+func (b *memBackend) Delete(id uint) error {
+	if _, ok := b.snaps[id]; !ok {
+		return fberrors.ErrNotExist
+	}
+	delete(b.snaps, id)
+	return nil
+}
+
+func TestStorageNamesByUser(t *testing.T) {
+	store := NewStorage(&memBackend{snaps: map[uint]Snapshot{}})
+	if err := store.Create(&Snapshot{Name: "before", UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(&Snapshot{Name: "before", UserID: 1}); !errors.Is(err, fberrors.ErrExist) {
+		t.Errorf("taken name: %v", err)
+	}
+	other := &Snapshot{Name: "before", UserID: 2, Path: "/other"}
+	if err := store.Create(other); err != nil {
+		t.Fatalf("name taken by another user: %v", err)
+	}
+
+	if err := store.Delete(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(2, "before"); !errors.Is(err, fberrors.ErrNotExist) {
+		t.Errorf("deleted snapshot: %v", err)
+	}
+	if _, err := store.Get(1, "before"); err != nil {
+		t.Errorf("snapshot of the other user: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"strings"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+)
+
+// StorageBackend is the interface to implement for a snapshot storage.
+type StorageBackend interface {
+	All() ([]*Snapshot, error)
+	FindByUserID(id uint) ([]*Snapshot, error)
+	Get(userID uint, name string) (*Snapshot, error)
+	Save(s *Snapshot) error
+	Delete(id uint) error
+}
+
+// Storage is a storage.
+type Storage struct {
+	back StorageBackend
+}
+
+// NewStorage creates a snapshot storage from a backend.
+func NewStorage(back StorageBackend) *Storage {
+	return &Storage{back: back}
+}
+
+// All wraps a StorageBackend.All.
+func (s *Storage) All() ([]*Snapshot, error) {
+	return s.back.All()
+}
+
+// FindByUserID wraps a StorageBackend.FindByUserID.
+func (s *Storage) FindByUserID(id uint) ([]*Snapshot, error) {
+	return s.back.FindByUserID(id)
+}
+
+// Get wraps a StorageBackend.Get.
+func (s *Storage) Get(userID uint, name string) (*Snapshot, error) {
+	return s.back.Get(userID, name)
+}
+
+// Create saves a new snapshot. It fails with errors.ErrExist when its user
+// already has a snapshot of that name.
+func (s *Storage) Create(snap *Snapshot) error {
+	if snap.Name == "" || strings.ContainsAny(snap.Name, `/\`) {
+		return errors.ErrInvalidRequestParams
+	}
+	_, err := s.back.Get(snap.UserID, snap.Name)
+	switch err {
+	case nil:
+		return errors.ErrExist
+	case errors.ErrNotExist:
+		return s.back.Save(snap)
+	default:
+		return err
+	}
+}
+
+// Delete wraps a StorageBackend.Delete.
+func (s *Storage) Delete(id uint) error {
+	return s.back.Delete(id)
+}
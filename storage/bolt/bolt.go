@@ -4,9 +4,13 @@ import (
 	"github.com/asdine/storm/v3"
 
 	"github.com/filebrowser/filebrowser/v2/auth"
+	"github.com/filebrowser/filebrowser/v2/device"
+	"github.com/filebrowser/filebrowser/v2/jobs"
 	"github.com/filebrowser/filebrowser/v2/settings"
 	"github.com/filebrowser/filebrowser/v2/share"
+	"github.com/filebrowser/filebrowser/v2/snapshot"
 	"github.com/filebrowser/filebrowser/v2/storage"
+	"github.com/filebrowser/filebrowser/v2/trash"
 	"github.com/filebrowser/filebrowser/v2/users"
 )
 
@@ -16,6 +20,10 @@ func NewStorage(db *storm.DB) (*storage.Storage, error) {
 	shareStore := share.NewStorage(shareBackend{db: db})
 	settingsStore := settings.NewStorage(settingsBackend{db: db})
 	authStore := auth.NewStorage(authBackend{db: db}, userStore)
+	snapshotStore := snapshot.NewStorage(snapshotBackend{db: db})
+	deviceStore := device.NewStorage(deviceBackend{db: db})
+	jobStore := jobs.NewStorage(jobBackend{db: db})
+	trashStore := trash.NewStorage(trashBackend{db: db})
 
 	err := save(db, "version", 2) //nolint:gomnd
 	if err != nil {
@@ -23,9 +31,13 @@ func NewStorage(db *storm.DB) (*storage.Storage, error) {
 	}
 
 	return &storage.Storage{
-		Auth:     authStore,
-		Users:    userStore,
-		Share:    shareStore,
-		Settings: settingsStore,
+		Auth:      authStore,
+		Users:     userStore,
+		Share:     shareStore,
+		Settings:  settingsStore,
+		Snapshots: snapshotStore,
+		Devices:   deviceStore,
+		Jobs:      jobStore,
+		Trash:     trashStore,
 	}, nil
 }
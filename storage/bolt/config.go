@@ -20,7 +20,7 @@ func (s settingsBackend) Save(set *settings.Settings) error {
 }
 
 func (s settingsBackend) GetServer() (*settings.Server, error) {
-	server := &settings.Server{}
+	server := settings.DefaultServer()
 	return server, get(s.db, "server", server)
 }
 
@@ -0,0 +1,44 @@
+package bolt
+
+import (
+	"github.com/asdine/storm/v3"
+
+	"github.com/filebrowser/filebrowser/v2/device"
+	"github.com/filebrowser/filebrowser/v2/errors"
+)
+
+type deviceBackend struct {
+	db *storm.DB
+}
+
+func (s deviceBackend) All() ([]*device.Device, error) {
+	var v []*device.Device
+	err := s.db.All(&v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s deviceBackend) Get(name string) (*device.Device, error) {
+	var v device.Device
+	err := s.db.One("Name", name, &v)
+	if err == storm.ErrNotFound {
+		return nil, errors.ErrNotExist
+	}
+
+	return &v, err
+}
+
+func (s deviceBackend) Save(d *device.Device) error {
+	return s.db.Save(d)
+}
+
+func (s deviceBackend) Delete(name string) error {
+	err := s.db.DeleteStruct(&device.Device{Name: name})
+	if err == storm.ErrNotFound {
+		return errors.ErrNotExist
+	}
+	return err
+}
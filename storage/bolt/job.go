@@ -0,0 +1,55 @@
+package bolt
+
+import (
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/jobs"
+)
+
+type jobBackend struct {
+	db *storm.DB
+}
+
+func (s jobBackend) All() ([]*jobs.Job, error) {
+	var v []*jobs.Job
+	err := s.db.All(&v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s jobBackend) FindByUserID(id uint) ([]*jobs.Job, error) {
+	var v []*jobs.Job
+	err := s.db.Select(q.Eq("UserID", id)).Find(&v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s jobBackend) Get(id uint) (*jobs.Job, error) {
+	var v jobs.Job
+	err := s.db.One("ID", id, &v)
+	if err == storm.ErrNotFound {
+		return nil, errors.ErrNotExist
+	}
+
+	return &v, err
+}
+
+func (s jobBackend) Save(j *jobs.Job) error {
+	return s.db.Save(j)
+}
+
+func (s jobBackend) Delete(id uint) error {
+	err := s.db.DeleteStruct(&jobs.Job{ID: id})
+	if err == storm.ErrNotFound {
+		return errors.ErrNotExist
+	}
+	return err
+}
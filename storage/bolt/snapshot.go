@@ -0,0 +1,55 @@
+package bolt
+
+import (
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/snapshot"
+)
+
+type snapshotBackend struct {
+	db *storm.DB
+}
+
+func (s snapshotBackend) All() ([]*snapshot.Snapshot, error) {
+	var v []*snapshot.Snapshot
+	err := s.db.All(&v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s snapshotBackend) FindByUserID(id uint) ([]*snapshot.Snapshot, error) {
+	var v []*snapshot.Snapshot
+	err := s.db.Select(q.Eq("UserID", id)).Find(&v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s snapshotBackend) Get(userID uint, name string) (*snapshot.Snapshot, error) {
+	var v snapshot.Snapshot
+	err := s.db.Select(q.Eq("UserID", userID), q.Eq("Name", name)).First(&v)
+	if err == storm.ErrNotFound {
+		return nil, errors.ErrNotExist
+	}
+
+	return &v, err
+}
+
+func (s snapshotBackend) Save(snap *snapshot.Snapshot) error {
+	return s.db.Save(snap)
+}
+
+func (s snapshotBackend) Delete(id uint) error {
+	err := s.db.DeleteStruct(&snapshot.Snapshot{ID: id})
+	if err == storm.ErrNotFound {
+		return errors.ErrNotExist
+	}
+	return err
+}
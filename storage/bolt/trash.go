@@ -0,0 +1,55 @@
+package bolt
+
+import (
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/trash"
+)
+
+type trashBackend struct {
+	db *storm.DB
+}
+
+func (s trashBackend) All() ([]*trash.Item, error) {
+	var v []*trash.Item
+	err := s.db.All(&v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s trashBackend) FindByUserID(id uint) ([]*trash.Item, error) {
+	var v []*trash.Item
+	err := s.db.Select(q.Eq("UserID", id)).Find(&v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s trashBackend) Get(id uint) (*trash.Item, error) {
+	var v trash.Item
+	err := s.db.One("ID", id, &v)
+	if err == storm.ErrNotFound {
+		return nil, errors.ErrNotExist
+	}
+
+	return &v, err
+}
+
+func (s trashBackend) Save(i *trash.Item) error {
+	return s.db.Save(i)
+}
+
+func (s trashBackend) Delete(id uint) error {
+	err := s.db.DeleteStruct(&trash.Item{ID: id})
+	if err == storm.ErrNotFound {
+		return errors.ErrNotExist
+	}
+	return err
+}
@@ -2,16 +2,24 @@ package storage
 
 import (
 	"github.com/filebrowser/filebrowser/v2/auth"
+	"github.com/filebrowser/filebrowser/v2/device"
+	"github.com/filebrowser/filebrowser/v2/jobs"
 	"github.com/filebrowser/filebrowser/v2/settings"
 	"github.com/filebrowser/filebrowser/v2/share"
+	"github.com/filebrowser/filebrowser/v2/snapshot"
+	"github.com/filebrowser/filebrowser/v2/trash"
 	"github.com/filebrowser/filebrowser/v2/users"
 )
 
 // Storage is a storage powered by a Backend which makes the necessary
 // verifications when fetching and saving data to ensure consistency.
 type Storage struct {
-	Users    users.Store
-	Share    *share.Storage
-	Auth     *auth.Storage
-	Settings *settings.Storage
+	Users     users.Store
+	Share     *share.Storage
+	Auth      *auth.Storage
+	Settings  *settings.Storage
+	Snapshots *snapshot.Storage
+	Devices   *device.Storage
+	Jobs      *jobs.Storage
+	Trash     *trash.Storage
 }
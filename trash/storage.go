@@ -0,0 +1,45 @@
+package trash
+
+// StorageBackend is the interface to implement for a trash storage.
+type StorageBackend interface {
+	All() ([]*Item, error)
+	FindByUserID(id uint) ([]*Item, error)
+	Get(id uint) (*Item, error)
+	Save(i *Item) error
+	Delete(id uint) error
+}
+
+// Storage is a storage.
+type Storage struct {
+	back StorageBackend
+}
+
+// NewStorage creates a trash storage from a backend.
+func NewStorage(back StorageBackend) *Storage {
+	return &Storage{back: back}
+}
+
+// All wraps a StorageBackend.All.
+func (s *Storage) All() ([]*Item, error) {
+	return s.back.All()
+}
+
+// FindByUserID wraps a StorageBackend.FindByUserID.
+func (s *Storage) FindByUserID(id uint) ([]*Item, error) {
+	return s.back.FindByUserID(id)
+}
+
+// Get wraps a StorageBackend.Get.
+func (s *Storage) Get(id uint) (*Item, error) {
+	return s.back.Get(id)
+}
+
+// Save wraps a StorageBackend.Save.
+func (s *Storage) Save(i *Item) error {
+	return s.back.Save(i)
+}
+
+// Delete wraps a StorageBackend.Delete.
+func (s *Storage) Delete(id uint) error {
+	return s.back.Delete(id)
+}
@@ -0,0 +1,229 @@
+// Package trash keeps the files deleted by the users, so that they can be
+// restored until they are purged or expire.
+package trash
+
+import (
+	stdErrors "errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/fileutils"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+// Dir is the name of the trash area, a directory at the root of the scope
+// of each user holding the files the user deleted.
+const Dir = ".filebrowser-trash"
+
+// Item is a file or directory in the trash.
+type Item struct {
+	ID     uint   `json:"id" storm:"id,increment"`
+	UserID uint   `json:"userID" storm:"index"`
+	Path   string `json:"path"`
+	IsDir  bool   `json:"isDir"`
+	// Size is the total size of the files of a directory.
+	Size    int64     `json:"size"`
+	Deleted time.Time `json:"deleted"`
+	// Local is the local trash directory the item was moved to, empty when
+	// it is in the trash area of the filesystem it was deleted from.
+	Local string `json:"local,omitempty"`
+}
+
+// IsTrashPath tells whether name is a trash area or is inside one.
+func IsTrashPath(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if elem == Dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Trash moves deleted files to the trash area of the filesystem they are
+// deleted from, or to a local directory.
+type Trash struct {
+	Store *Storage
+	// LocalDir, when set, is a directory of this machine holding the trash
+	// of every user instead of their trash area.
+	LocalDir string
+}
+
+// location returns the filesystem and path of the content of item, fs
+// being the filesystem of its owner.
+func location(fs afero.Fs, item *Item) (afero.Fs, string) {
+	name := strconv.FormatUint(uint64(item.ID), 10)
+	if item.Local != "" {
+		user := strconv.FormatUint(uint64(item.UserID), 10)
+		return govfs.NewOsFs(), filepath.Join(item.Local, user, name)
+	}
+	return fs, path.Join("/", Dir, name)
+}
+
+// Move moves name from fs, the filesystem of the user, to the trash.
+func (t *Trash) Move(fs afero.Fs, userID uint, name string) (*Item, error) {
+	name = path.Clean("/" + name)
+	if name == "/" || IsTrashPath(name) {
+		return nil, errors.ErrPermissionDenied
+	}
+	info, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &Item{
+		UserID:  userID,
+		Path:    name,
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		Deleted: time.Now(),
+		Local:   t.LocalDir,
+	}
+	if info.IsDir() {
+		if item.Size, err = govfs.TreeSize(fs, name); err != nil {
+			return nil, err
+		}
+	}
+	if err = t.Store.Save(item); err != nil {
+		return nil, err
+	}
+
+	dstFs, dst := location(fs, item)
+	if err = move(fs, name, dstFs, dst, item.Local == ""); err != nil {
+		_ = t.Store.Delete(item.ID)
+		return nil, err
+	}
+	return item, nil
+}
+
+// Restore moves item back to fs, the filesystem of its owner, at dst. It
+// fails with errors.ErrExist when dst exists.
+func (t *Trash) Restore(fs afero.Fs, item *Item, dst string) error {
+	if _, err := fs.Stat(dst); err == nil {
+		return errors.ErrExist
+	}
+
+	srcFs, src := location(fs, item)
+	if err := move(srcFs, src, fs, dst, item.Local == ""); err != nil {
+		return err
+	}
+	return t.Store.Delete(item.ID)
+}
+
+// Purge deletes item for good. fs is the filesystem of its owner.
+func (t *Trash) Purge(fs afero.Fs, item *Item) error {
+	itemFs, name := location(fs, item)
+	if err := govfs.RemoveAll(itemFs, name); err != nil && !stdErrors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return t.Store.Delete(item.ID)
+}
+
+// Empty purges the trash of a user and returns the number of items purged.
+func (t *Trash) Empty(fs afero.Fs, userID uint) (int, error) {
+	items, err := t.Store.FindByUserID(userID)
+	if err != nil && err != errors.ErrNotExist {
+		return 0, err
+	}
+	for i, item := range items {
+		if err = t.Purge(fs, item); err != nil {
+			return i, err
+		}
+	}
+	return len(items), nil
+}
+
+// Sweep purges the items deleted before the given time and returns the
+// number of items purged. fsOf returns the filesystem of a user; the items
+// of the users whose filesystem is not available are kept, and the first
+// such error is returned once the others are purged. The items of the users
+// that no longer exist, for whom fsOf fails with errors.ErrNotExist, are
+// only removed from the store.
+func (t *Trash) Sweep(before time.Time, fsOf func(userID uint) (afero.Fs, error)) (int, error) {
+	items, err := t.Store.All()
+	if err != nil && err != errors.ErrNotExist {
+		return 0, err
+	}
+
+	var (
+		purged   int
+		firstErr error
+	)
+	filesystems := map[uint]afero.Fs{}
+	for _, item := range items {
+		if !item.Deleted.Before(before) {
+			continue
+		}
+		fs, ok := filesystems[item.UserID]
+		if !ok && item.Local == "" {
+			if fs, err = fsOf(item.UserID); err != nil {
+				if stdErrors.Is(err, errors.ErrNotExist) {
+					err = t.Store.Delete(item.ID)
+				}
+				if err == nil {
+					purged++
+				} else if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			filesystems[item.UserID] = fs
+		}
+		if err = t.Purge(fs, item); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		purged++
+	}
+	return purged, firstErr
+}
+
+// move moves src of srcFs to dst of dstFs, which is srcFs when same is
+// set. dst must not exist.
+func move(srcFs afero.Fs, src string, dstFs afero.Fs, dst string, same bool) error {
+	if err := dstFs.MkdirAll(path.Dir(dst), 0775); err != nil { //nolint:gomnd
+		return err
+	}
+	if same {
+		return fileutils.MoveFile(srcFs, src, dst)
+	}
+
+	if err := copyTree(srcFs, src, dstFs, dst); err != nil {
+		_ = govfs.RemoveAll(dstFs, dst)
+		return err
+	}
+	return govfs.RemoveAll(srcFs, src)
+}
+
+// copyTree copies the tree src of srcFs to dst of dstFs.
+func copyTree(srcFs afero.Fs, src string, dstFs afero.Fs, dst string) error {
+	return govfs.Walk(srcFs, src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := path.Join(dst, strings.TrimPrefix(name, src))
+
+		switch {
+		case info.IsDir():
+			return dstFs.MkdirAll(target, info.Mode().Perm()|0700) //nolint:gomnd
+		case info.Mode().IsRegular():
+			f, err := srcFs.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return govfs.WriteFile(dstFs, target, f, info.Mode().Perm())
+		default:
+			return fmt.Errorf("%s: %w", name, errors.ErrInvalidDataType)
+		}
+	})
+}
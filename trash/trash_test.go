@@ -0,0 +1,178 @@
+package trash
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	fberrors "github.com/filebrowser/filebrowser/v2/errors"
+)
+
+type memBackend struct {
+	mu     sync.Mutex
+	items  map[uint]Item
+	nextID uint
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{items: map[uint]Item{}}
+}
+
+func (b *memBackend) All() ([]*Item, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var list []*Item
+	for _, item := range b.items {
+		item := item
+		list = append(list, &item)
+	}
+	return list, nil
+}
+
+func (b *memBackend) FindByUserID(id uint) ([]*Item, error) {
+	all, _ := b.All()
+	var list []*Item
+	for _, item := range all {
+		if item.UserID == id {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+func (b *memBackend) Get(id uint) (*Item, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	item, ok := b.items[id]
+	if !ok {
+		return nil, fberrors.ErrNotExist
+	}
+	return &item, nil
+}
+
+func (b *memBackend) Save(i *Item) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if i.ID == 0 {
+		b.nextID++
+		i.ID = b.nextID
+	}
+	b.items[i.ID] = *i
+	return nil
+}
+
+func (b *memBackend) Delete(id uint) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.items[id]; !ok {
+		return fberrors.ErrNotExist
+	}
+	delete(b.items, id)
+	return nil
+}
+
+func TestIsTrashPath(t *testing.T) {
+	for name, want := range map[string]bool{
+		"/" + Dir:                true,
+		"/" + Dir + "/1/a.txt":   true,
+		"/scope/" + Dir + "/2":   true,
+		"/" + Dir + "-not/a.txt": false,
+		"/docs/a.txt":            false,
+	} {
+		if got := IsTrashPath(name); got != want {
+			t.Errorf("IsTrashPath(%q) = %v", name, got)
+		}
+	}
+}
+
+func TestLocalTrash(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/docs/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/docs/sub/b.txt", []byte("bb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	trash := &Trash{Store: NewStorage(newMemBackend()), LocalDir: t.TempDir()}
+
+	item, err := trash.Move(fs, 1, "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !item.IsDir || item.Size != 3 || item.Local == "" {
+		t.Errorf("item: %+v", item)
+	}
+	if _, err = fs.Stat("/docs"); err == nil {
+		t.Error("/docs still exists")
+	}
+	if _, err = trash.Move(fs, 1, "/"+Dir); err != fberrors.ErrPermissionDenied {
+		t.Errorf("moving the trash area: %v", err)
+	}
+
+	if err = trash.Restore(fs, item, "/restored"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := afero.ReadFile(fs, "/restored/sub/b.txt")
+	if err != nil || string(data) != "bb" {
+		t.Errorf("restored file: %q %v", data, err)
+	}
+	if _, err = trash.Store.Get(item.ID); err != fberrors.ErrNotExist {
+		t.Errorf("restored item still stored: %v", err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	trash := &Trash{Store: NewStorage(newMemBackend())}
+	for _, name := range []string{"/old.txt", "/new.txt", "/other.txt"} {
+		if err := afero.WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, err := trash.Move(fs, 1, "/old.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Deleted = time.Now().Add(-48 * time.Hour)
+	if err = trash.Store.Save(old); err != nil {
+		t.Fatal(err)
+	}
+	other, err := trash.Move(fs, 2, "/other.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Deleted = old.Deleted
+	if err = trash.Store.Save(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = trash.Move(fs, 1, "/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = trash.Store.Save(&Item{UserID: 3, Path: "/gone.txt", Deleted: old.Deleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the filesystem of user 2 is not available, and user 3 was deleted
+	purged, err := trash.Sweep(time.Now().Add(-24*time.Hour), func(id uint) (afero.Fs, error) {
+		switch id {
+		case 1:
+			return fs, nil
+		case 2:
+			return nil, fberrors.ErrUnknownDevice
+		default:
+			return nil, fberrors.ErrNotExist
+		}
+	})
+	if purged != 2 || err != fberrors.ErrUnknownDevice {
+		t.Errorf("sweep: %d purged, %v", purged, err)
+	}
+	items, _ := trash.Store.All()
+	if len(items) != 2 {
+		t.Errorf("%d items left", len(items))
+	}
+	if infos, _ := afero.ReadDir(fs, "/"+Dir); len(infos) != 2 {
+		t.Errorf("%d entries left in the trash area", len(infos))
+	}
+}
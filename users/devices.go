@@ -0,0 +1,307 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+	"github.com/filebrowser/filebrowser/v2/govfs/afcfs"
+	"github.com/filebrowser/filebrowser/v2/govfs/cache"
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+)
+
+// DefaultDevice is the device of users that do not name one.
+const DefaultDevice = "default"
+
+var (
+	devicesMutex sync.RWMutex
+	devices      = map[string]*registeredDevice{}
+	// deviceMode is set by the first AddDevice and never cleared, so that
+	// detaching every device does not serve users from the local disk.
+	deviceMode bool
+)
+
+type registeredDevice struct {
+	addr string
+	fs   afero.Fs
+}
+
+// DeviceOptions configures a device registered with AddDevice.
+type DeviceOptions struct {
+	services.Options
+	// Mounts are the services mounted next to the media partition.
+	Mounts []afcfs.MountConfig
+	// CacheTTL is how long the stat of files and directory listings are
+	// cached; zero disables the cache.
+	CacheTTL time.Duration
+}
+
+// DeviceStatus is the state of a registered device.
+type DeviceStatus struct {
+	Addr      string       `json:"addr"`
+	Connected bool         `json:"connected"`
+	Error     string       `json:"error,omitempty"`
+	Usage     *govfs.Usage `json:"usage,omitempty"`
+}
+
+// AddDevice registers the filesystem of the AFC server at addr under name,
+// replacing and closing any device registered under the same name. The
+// server is dialed by the first request, so that a device can be added
+// while it is offline. As soon as one device is registered, users are
+// served from their device instead of the local disk.
+func AddDevice(name, addr string, opts DeviceOptions) error {
+	if name == "" {
+		return fmt.Errorf("device name: %w", errors.ErrInvalidRequestParams)
+	}
+	for _, a := range append([]string{addr}, mountAddrs(opts.Mounts)...) {
+		if _, _, _, err := services.ParseAddr(a); err != nil {
+			return fmt.Errorf("device %q: %w", name, err)
+		}
+	}
+
+	opts.Lazy = true
+	vfs, err := afcfs.NewVfsWithOptions(addr, opts.Options, opts.Mounts...)
+	if err != nil {
+		return fmt.Errorf("device %q: %w", name, err)
+	}
+
+	var fs afero.Fs = vfs
+	if opts.CacheTTL > 0 {
+		fs = cache.New(vfs, cache.Options{TTL: opts.CacheTTL})
+	}
+
+	devicesMutex.Lock()
+	old := devices[name]
+	devices[name] = &registeredDevice{addr: addr, fs: fs}
+	deviceMode = true
+	devicesMutex.Unlock()
+
+	if old != nil {
+		closeDevice(old)
+	}
+	return nil
+}
+
+func mountAddrs(mounts []afcfs.MountConfig) []string {
+	addrs := make([]string, 0, len(mounts))
+	for _, m := range mounts {
+		addrs = append(addrs, m.Addr)
+	}
+	return addrs
+}
+
+// RemoveDevice unregisters the device called name and closes its
+// connections. Users of the device fail with errors.ErrUnknownDevice until
+// one is registered again under the same name.
+func RemoveDevice(name string) error {
+	devicesMutex.Lock()
+	dev, ok := devices[name]
+	delete(devices, name)
+	devicesMutex.Unlock()
+
+	if !ok {
+		return errors.ErrNotExist
+	}
+	closeDevice(dev)
+	return nil
+}
+
+func closeDevice(dev *registeredDevice) {
+	if closer, ok := dev.fs.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// Devices returns the names of the registered devices, sorted.
+func Devices() []string {
+	devicesMutex.RLock()
+	defer devicesMutex.RUnlock()
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeviceStatuses checks every registered device, concurrently, and returns
+// their state keyed by device name. The storage of the devices that can be
+// reached is reported with their state.
+func DeviceStatuses(ctx context.Context) map[string]*DeviceStatus {
+	registered := registeredDevices()
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	statuses := make(map[string]*DeviceStatus, len(registered))
+	for name, dev := range registered {
+		wg.Add(1)
+		go func(name string, dev *registeredDevice) {
+			defer wg.Done()
+			status := checkDevice(ctx, dev)
+			mu.Lock()
+			statuses[name] = status
+			mu.Unlock()
+		}(name, dev)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// registeredDevices returns a copy of the registry, for the devices to be
+// reached without holding devicesMutex.
+func registeredDevices() map[string]*registeredDevice {
+	devicesMutex.RLock()
+	defer devicesMutex.RUnlock()
+	registered := make(map[string]*registeredDevice, len(devices))
+	for name, dev := range devices {
+		registered[name] = dev
+	}
+	return registered
+}
+
+func checkDevice(ctx context.Context, dev *registeredDevice) *DeviceStatus {
+	status := &DeviceStatus{Addr: dev.addr}
+	if checker, ok := dev.fs.(interface{ Health() error }); ok {
+		if err := checker.Health(); err != nil {
+			status.Error = err.Error()
+			return status
+		}
+	}
+	status.Connected = true
+	if provider, ok := govfs.WithContext(dev.fs, ctx).(govfs.UsageProvider); ok {
+		if u, err := provider.Usage("/"); err == nil {
+			status.Usage = u
+		}
+	}
+	return status
+}
+
+// BackendHealth reports the connectivity of every registered device, keyed
+// by device name. It is empty when no device is registered.
+func BackendHealth() map[string]error {
+	status := map[string]error{}
+	for name, dev := range registeredDevices() {
+		if checker, ok := dev.fs.(interface{ Health() error }); ok {
+			status[name] = checker.Health()
+		}
+	}
+	return status
+}
+
+// DeviceUsage reports the storage of every registered device that can be
+// reached, keyed by device name.
+func DeviceUsage(ctx context.Context) map[string]*govfs.Usage {
+	usage := map[string]*govfs.Usage{}
+	for name, dev := range registeredDevices() {
+		provider, ok := govfs.WithContext(dev.fs, ctx).(govfs.UsageProvider)
+		if !ok {
+			continue
+		}
+		if u, err := provider.Usage("/"); err == nil {
+			usage[name] = u
+		}
+	}
+	return usage
+}
+
+// deviceFs returns the filesystem of the user's device restricted to the
+// user's scope. ok is false when no device was ever registered. The
+// filesystem of a device that is not registered fails every operation with
+// errors.ErrUnknownDevice, so that its users can still be listed and moved
+// to another device.
+func (u *User) deviceFs() (fs afero.Fs, ok bool) {
+	devicesMutex.RLock()
+	defer devicesMutex.RUnlock()
+	if !deviceMode {
+		return nil, false
+	}
+
+	name := u.Device
+	if name == "" {
+		name = DefaultDevice
+	}
+	dev, ok := devices[name]
+	if !ok {
+		return &unknownDeviceFs{err: fmt.Errorf("%w: %s", errors.ErrUnknownDevice, name)}, true
+	}
+	fs = dev.fs
+
+	// scopes on a device are device paths, independent of the server root
+	scope := path.Join("/", u.Scope)
+	if scope == "/" {
+		return fs, true
+	}
+	return govfs.NewScopedFs(fs, scope), true
+}
+
+// unknownDeviceFs is the filesystem of the users of a device that is not
+// registered.
+type unknownDeviceFs struct {
+	err error
+}
+
+func (fs *unknownDeviceFs) fail(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: fs.err}
+}
+
+func (fs *unknownDeviceFs) Create(name string) (afero.File, error) {
+	return nil, fs.fail("create", name)
+}
+
+func (fs *unknownDeviceFs) Mkdir(name string, _ os.FileMode) error {
+	return fs.fail("mkdir", name)
+}
+
+func (fs *unknownDeviceFs) MkdirAll(name string, _ os.FileMode) error {
+	return fs.fail("mkdir", name)
+}
+
+func (fs *unknownDeviceFs) Open(name string) (afero.File, error) {
+	return nil, fs.fail("open", name)
+}
+
+func (fs *unknownDeviceFs) OpenFile(name string, _ int, _ os.FileMode) (afero.File, error) {
+	return nil, fs.fail("open", name)
+}
+
+func (fs *unknownDeviceFs) Remove(name string) error {
+	return fs.fail("remove", name)
+}
+
+func (fs *unknownDeviceFs) RemoveAll(name string) error {
+	return fs.fail("remove", name)
+}
+
+func (fs *unknownDeviceFs) Rename(oldname, _ string) error {
+	return fs.fail("rename", oldname)
+}
+
+func (fs *unknownDeviceFs) Stat(name string) (os.FileInfo, error) {
+	return nil, fs.fail("stat", name)
+}
+
+func (fs *unknownDeviceFs) Name() string {
+	return "UnknownDeviceFs"
+}
+
+func (fs *unknownDeviceFs) Chmod(name string, _ os.FileMode) error {
+	return fs.fail("chmod", name)
+}
+
+func (fs *unknownDeviceFs) Chown(name string, _, _ int) error {
+	return fs.fail("chown", name)
+}
+
+func (fs *unknownDeviceFs) Chtimes(name string, _, _ time.Time) error {
+	return fs.fail("chtimes", name)
+}
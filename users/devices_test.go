@@ -0,0 +1,154 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	fberrors "github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs/services"
+	"github.com/filebrowser/filebrowser/v2/govfs/services/afctest"
+)
+
+func resetDevices() {
+	devicesMutex.Lock()
+	devices = map[string]*registeredDevice{}
+	deviceMode = false
+	devicesMutex.Unlock()
+}
+
+func TestCleanDeviceScope(t *testing.T) {
+	phone, tablet := afctest.NewServer(), afctest.NewServer()
+	defer phone.Close()
+	defer tablet.Close()
+	if err := afero.WriteFile(phone.Fs, "/home/alice/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(tablet.Fs, "/b.txt", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddDevice(DefaultDevice, phone.Addr(), DeviceOptions{Options: services.Options{PoolSize: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddDevice("tablet", tablet.Addr(), DeviceOptions{Options: services.Options{PoolSize: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	defer resetDevices()
+
+	alice := &User{Username: "alice", Password: "pw", Scope: "./home/alice"}
+	if err := alice.Clean("/srv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Fs.Stat("/a.txt"); err != nil {
+		t.Errorf("scoped file not found: %v", err)
+	}
+	if _, err := alice.Fs.Stat("/../../b.txt"); err == nil {
+		t.Error("file outside of the scope is reachable")
+	}
+	if got := alice.FullPath("/a.txt"); got != "/home/alice/a.txt" {
+		t.Errorf("FullPath = %q", got)
+	}
+
+	bob := &User{Username: "bob", Password: "pw", Scope: ".", Device: "tablet"}
+	if err := bob.Clean("/srv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Fs.Stat("/b.txt"); err != nil {
+		t.Errorf("file on the tablet not found: %v", err)
+	}
+
+	// the users of an unknown device can still be loaded and edited
+	carol := &User{Username: "carol", Password: "pw", Device: "missing"}
+	if err := carol.Clean("/srv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := carol.Fs.Stat("/a.txt"); !errors.Is(err, fberrors.ErrUnknownDevice) {
+		t.Errorf("got %v, want %v", err, fberrors.ErrUnknownDevice)
+	}
+}
+
+func TestAddDeviceOffline(t *testing.T) {
+	defer resetDevices()
+
+	srv := afctest.NewServer()
+	addr := srv.Addr()
+	srv.Close()
+
+	opts := DeviceOptions{Options: services.Options{PoolSize: 1, ReconnectAttempts: 1}}
+	if err := AddDevice("phone", addr, opts); err != nil {
+		t.Fatalf("adding an offline device: %v", err)
+	}
+	if err := AddDevice("broken", "usbmux://phone", opts); err == nil {
+		t.Error("added a device with an invalid address")
+	}
+
+	status := DeviceStatuses(context.Background())["phone"]
+	if status == nil || status.Connected || status.Error == "" {
+		t.Errorf("status of an offline device: %+v", status)
+	}
+
+	if err := RemoveDevice("phone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveDevice("phone"); !errors.Is(err, fberrors.ErrNotExist) {
+		t.Errorf("removing a missing device: %v", err)
+	}
+
+	// users are not served from the local disk once every device is gone
+	alice := &User{Username: "alice", Password: "pw", Device: "phone"}
+	if err := alice.Clean("/srv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Fs.Stat("/"); !errors.Is(err, fberrors.ErrUnknownDevice) {
+		t.Errorf("got %v, want %v", err, fberrors.ErrUnknownDevice)
+	}
+}
+
+func TestProbeDoesNotBlockRegistry(t *testing.T) {
+	defer resetDevices()
+
+	// a device that accepts connections and never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	opts := DeviceOptions{Options: services.Options{PoolSize: 1, ReconnectAttempts: 1, OpTimeout: 3 * time.Second}}
+	if err = AddDevice("silent", l.Addr().String(), opts); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		BackendHealth()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if err = AddDevice("other", l.Addr().String(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("AddDevice waited %s for the probe", elapsed)
+	}
+	<-done
+}
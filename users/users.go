@@ -8,7 +8,7 @@ import (
 
 	"github.com/filebrowser/filebrowser/v2/errors"
 	"github.com/filebrowser/filebrowser/v2/files"
-	"github.com/filebrowser/filebrowser/v2/govfs/afcfs"
+	"github.com/filebrowser/filebrowser/v2/govfs"
 	"github.com/filebrowser/filebrowser/v2/rules"
 )
 
@@ -26,6 +26,7 @@ type User struct {
 	Username     string        `storm:"unique" json:"username"`
 	Password     string        `json:"password"`
 	Scope        string        `json:"scope"`
+	Device       string        `json:"device"`
 	Locale       string        `json:"locale"`
 	LockPassword bool          `json:"lockPassword"`
 	ViewMode     ViewMode      `json:"viewMode"`
@@ -39,16 +40,6 @@ type User struct {
 	DateFormat   bool          `json:"dateFormat"`
 }
 
-var gaFS afero.Fs
-
-func SetFs(addr string) {
-	fs, err := afcfs.NewVfs(addr)
-	if err != nil {
-		panic(err)
-	}
-	gaFS = fs
-}
-
 // GetRules implements rules.Provider.
 func (u *User) GetRules() []rules.Rule {
 	return u.Rules
@@ -103,8 +94,8 @@ func (u *User) Clean(baseScope string, fields ...string) error {
 	}
 
 	if u.Fs == nil {
-		if gaFS != nil {
-			u.Fs = gaFS
+		if fs, ok := u.deviceFs(); ok {
+			u.Fs = fs
 		} else {
 			scope := u.Scope
 
@@ -112,7 +103,7 @@ func (u *User) Clean(baseScope string, fields ...string) error {
 				scope = filepath.Join(baseScope, scope)
 			}
 
-			u.Fs = afero.NewBasePathFs(afero.NewOsFs(), scope)
+			u.Fs = govfs.NewScopedFs(govfs.NewOsFs(), scope)
 		}
 	}
 
@@ -121,7 +112,15 @@ func (u *User) Clean(baseScope string, fields ...string) error {
 
 // FullPath gets the full path for a user's relative path.
 func (u *User) FullPath(path string) string {
-	return afero.FullBaseFsPath(u.Fs.(*afero.BasePathFs), path)
+	if bfs, ok := u.Fs.(*afero.BasePathFs); ok {
+		return afero.FullBaseFsPath(bfs, path)
+	}
+	if fs, ok := u.Fs.(interface{ RealPath(string) (string, error) }); ok {
+		if full, err := fs.RealPath(path); err == nil {
+			return full
+		}
+	}
+	return path
 }
 
 // CanExecute checks if an user can execute a specific command.
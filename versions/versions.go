@@ -0,0 +1,248 @@
+// Package versions keeps the previous contents of the files that are
+// overwritten. The versions are files of the filesystem of the user, so
+// that they work the same on every backend.
+package versions
+
+import (
+	stdErrors "errors"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/filebrowser/filebrowser/v2/errors"
+	"github.com/filebrowser/filebrowser/v2/govfs"
+)
+
+// Dir is the name of the directory, at the root of the scope of each user,
+// holding the versions of the files of the user. The versions of /a/b.txt
+// are the files of Dir/a/b.txt, named after the time they were saved.
+const Dir = ".filebrowser-versions"
+
+// Version is a previous content of a file.
+type Version struct {
+	ID    string    `json:"id"`
+	Size  int64     `json:"size"`
+	Saved time.Time `json:"saved"`
+}
+
+// Policy tells how many versions of a file are kept, and for how long.
+type Policy struct {
+	// Keep is the number of versions kept per file, zero disabling the
+	// versioning.
+	Keep int `json:"keep"`
+	// MaxAge is how long versions are kept, zero keeping them until there
+	// are more than Keep.
+	MaxAge time.Duration `json:"maxAge"`
+}
+
+// Enabled tells whether versions are kept.
+func (p Policy) Enabled() bool {
+	return p.Keep > 0
+}
+
+// expired tells whether p no longer keeps a version saved at saved.
+func (p Policy) expired(saved time.Time) bool {
+	return p.Enabled() && p.MaxAge > 0 && time.Since(saved) > p.MaxAge
+}
+
+// IsVersionsPath tells whether name is the versions directory or is inside
+// it.
+func IsVersionsPath(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if elem == Dir {
+			return true
+		}
+	}
+	return false
+}
+
+func versionsDir(name string) string {
+	return path.Join("/", Dir, name)
+}
+
+// Save keeps the current content of name as a version, if it is a file,
+// and prunes the versions according to p. It does nothing when p is not
+// enabled.
+func Save(fs afero.Fs, name string, p Policy) error {
+	if !p.Enabled() {
+		return nil
+	}
+	if err := keep(fs, name); err != nil {
+		return err
+	}
+	return Prune(fs, name, p)
+}
+
+// keep saves the current content of name as a version, if it is a file.
+func keep(fs afero.Fs, name string) error {
+	info, err := fs.Stat(name)
+	if stdErrors.Is(err, os.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dir := versionsDir(name)
+	if err = fs.MkdirAll(dir, 0775); err != nil { //nolint:gomnd
+		return err
+	}
+	src, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	id := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return govfs.WriteFile(fs, path.Join(dir, id), src, info.Mode().Perm())
+}
+
+// List returns the versions of name p keeps, the latest first.
+func List(fs afero.Fs, name string, p Policy) ([]*Version, error) {
+	all, err := list(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	kept := all[:0]
+	for _, version := range all {
+		if !p.expired(version.Saved) {
+			kept = append(kept, version)
+		}
+	}
+	return kept, nil
+}
+
+// list returns every version of name, the latest first.
+func list(fs afero.Fs, name string) ([]*Version, error) {
+	infos, err := govfs.ReadDir(fs, versionsDir(name))
+	if stdErrors.Is(err, os.ErrNotExist) {
+		return []*Version{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*Version{}
+	for _, info := range infos {
+		saved, err := strconv.ParseInt(info.Name(), 10, 64) //nolint:govet
+		if err != nil || !info.Mode().IsRegular() {
+			// the versions of the files below name when it was a directory
+			continue
+		}
+		list = append(list, &Version{ID: info.Name(), Size: info.Size(), Saved: time.Unix(0, saved)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Saved.After(list[j].Saved)
+	})
+	return list, nil
+}
+
+// Get returns the version id of name, if p keeps it.
+func Get(fs afero.Fs, name, id string, p Policy) (*Version, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, errors.ErrNotExist
+	}
+	list, err := List(fs, name, p)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range list {
+		if version.ID == id {
+			return version, nil
+		}
+	}
+	return nil, errors.ErrNotExist
+}
+
+// Open opens the content of the version id of name, if p keeps it.
+func Open(fs afero.Fs, name, id string, p Policy) (afero.File, error) {
+	if _, err := Get(fs, name, id, p); err != nil {
+		return nil, err
+	}
+	return fs.Open(path.Join(versionsDir(name), id))
+}
+
+// Restore replaces the content of name with the version id. The current
+// content is kept as a new version when p is enabled.
+func Restore(fs afero.Fs, name, id string, p Policy) error {
+	src, err := Open(fs, name, id, p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	perm := os.FileMode(0775) //nolint:gomnd
+	if info, statErr := fs.Stat(name); statErr == nil {
+		if !info.Mode().IsRegular() {
+			return errors.ErrIsDirectory
+		}
+		perm = info.Mode().Perm()
+	}
+	if p.Enabled() {
+		if err = keep(fs, name); err != nil {
+			return err
+		}
+	}
+	if err = govfs.WriteFile(fs, name, src, perm); err != nil {
+		return err
+	}
+	// pruned once read, as the version restored may be the oldest
+	return Prune(fs, name, p)
+}
+
+// Prune removes the versions of name beyond the number and age allowed by
+// p. It does nothing when p is not enabled, so that the versions kept
+// before the versioning was disabled remain.
+func Prune(fs afero.Fs, name string, p Policy) error {
+	if !p.Enabled() {
+		return nil
+	}
+	all, err := list(fs, name)
+	if err != nil {
+		return err
+	}
+	dir := versionsDir(name)
+	for i, version := range all {
+		if i < p.Keep && !p.expired(version.Saved) {
+			continue
+		}
+		if err = fs.Remove(path.Join(dir, version.ID)); err != nil && !stdErrors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sweep removes the versions older than the age allowed by p from the
+// whole versions directory of fs, including those of the files deleted or
+// renamed since, and returns how many were removed. It does nothing when p
+// is not enabled or keeps versions regardless of their age.
+func Sweep(fs afero.Fs, p Policy) (int, error) {
+	if !p.Enabled() || p.MaxAge == 0 {
+		return 0, nil
+	}
+	root := path.Join("/", Dir)
+	removed := 0
+	err := govfs.Walk(fs, root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			if name == root && stdErrors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		saved, err := strconv.ParseInt(info.Name(), 10, 64)
+		if err != nil || !info.Mode().IsRegular() || !p.expired(time.Unix(0, saved)) {
+			return nil
+		}
+		if err = fs.Remove(name); err != nil && !stdErrors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,113 @@
+package versions
+
+import (
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestSaveAndRestore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	policy := Policy{Keep: 2}
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		if err := Save(fs, "/docs/a.txt", policy); err != nil {
+			t.Fatal(err)
+		}
+		if err := afero.WriteFile(fs, "/docs/a.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := List(fs, "/docs/a.txt", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("%d versions kept", len(list))
+	}
+	f, err := Open(fs, "/docs/a.txt", list[1].ID, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := afero.ReadAll(f)
+	f.Close()
+	if string(data) != "v2" {
+		t.Errorf("oldest version kept: %q", data)
+	}
+	if _, err = Open(fs, "/docs/a.txt", "../../docs/a.txt", policy); err == nil {
+		t.Error("opened a version outside of the versions of the file")
+	}
+
+	if err = Restore(fs, "/docs/a.txt", list[1].ID, policy); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ = afero.ReadFile(fs, "/docs/a.txt"); string(data) != "v2" {
+		t.Errorf("restored content: %q", data)
+	}
+	if list, _ = List(fs, "/docs/a.txt", policy); len(list) != 2 {
+		t.Fatalf("%d versions after restoring", len(list))
+	}
+	f, _ = Open(fs, "/docs/a.txt", list[0].ID, policy)
+	data, _ = afero.ReadAll(f)
+	f.Close()
+	if string(data) != "v4" {
+		t.Errorf("content replaced by the restore: %q", data)
+	}
+}
+
+func TestPruneByAge(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, name := range []string{"/a.txt", "/gone/b.txt"} {
+		dir := versionsDir(name)
+		for _, age := range []time.Duration{time.Minute, 2 * time.Hour, 48 * time.Hour} {
+			id := strconv.FormatInt(time.Now().Add(-age).UnixNano(), 10)
+			if err := afero.WriteFile(fs, path.Join(dir, id), []byte(age.String()), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	policy := Policy{Keep: 10, MaxAge: time.Hour}
+
+	// disabled policies prune nothing
+	if err := Prune(fs, "/a.txt", Policy{MaxAge: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := List(fs, "/a.txt", Policy{MaxAge: time.Hour}); len(list) != 3 {
+		t.Errorf("%d versions left by a disabled policy", len(list))
+	}
+
+	// the expired versions are hidden before being pruned
+	listed, err := List(fs, "/a.txt", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 {
+		t.Errorf("%d versions listed", len(listed))
+	}
+	all, _ := list(fs, "/a.txt")
+	if _, err = Open(fs, "/a.txt", all[len(all)-1].ID, policy); err == nil {
+		t.Error("opened an expired version")
+	}
+
+	if err = Prune(fs, "/a.txt", policy); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ = list(fs, "/a.txt"); len(all) != 1 {
+		t.Errorf("%d versions left", len(all))
+	}
+
+	// the sweep also reaches the versions of files that no longer exist
+	removed, err := Sweep(fs, policy)
+	if err != nil || removed != 2 {
+		t.Errorf("Sweep = %d %v, want 2", removed, err)
+	}
+	if all, _ = list(fs, "/gone/b.txt"); len(all) != 1 {
+		t.Errorf("%d versions of a deleted file left", len(all))
+	}
+	if removed, err = Sweep(afero.NewMemMapFs(), policy); err != nil || removed != 0 {
+		t.Errorf("Sweep without versions = %d %v", removed, err)
+	}
+}